@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+	"golang.org/x/oauth2"
+)
+
+// command is a subcommand of the command line interface
+type command struct {
+	Description string
+	Run         func(config *oauth2.Config, args []string) error
+}
+
+var (
+	commands = map[string]command{
+		"import":  {"Import GPX, FIT and TCX files (or ZIP archives) as activities", runImport},
+		"mail":    {"Send a sample export by email to check the smtp settings", runMail},
+		"mirror":  {"Mirror all data of an athlete to a directory (incremental and resumable)", runMirror},
+		"webhook": {"Send a sample webhook event to a running server (local stand-in for Strava)", runWebhook},
+	}
+)
+
+// Run executes the subcommand given by the first argument
+func Run(config *oauth2.Config, args []string) error {
+	cmd, exists := commands[args[0]]
+	if !exists {
+		printUsage()
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return cmd.Run(config, args[1:])
+}
+
+// printUsage prints all available subcommands
+func printUsage() {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].Description)
+	}
+	fmt.Fprintln(os.Stderr, "\nThe web server is started if no command is given.")
+}
+
+// addTokenFlag adds the flag used to pass the refresh token of the athlete (defaults to
+// STRAVA_REFRESH_TOKEN)
+func addTokenFlag(flags *flag.FlagSet) *string {
+	return flags.String("token", os.Getenv("STRAVA_REFRESH_TOKEN"), "refresh token of the athlete")
+}
+
+// getAPIClient returns a swagger client and the authentication context for a refresh token
+func getAPIClient(config *oauth2.Config, refreshToken string) (*swagger.APIClient, context.Context, error) {
+	if refreshToken == "" {
+		return nil, nil, errors.New("refresh token missing (use -token or STRAVA_REFRESH_TOKEN)")
+	}
+
+	tokenSource := config.TokenSource(context.Background(), &oauth2.Token{RefreshToken: refreshToken})
+	auth := context.WithValue(context.Background(), swagger.ContextOAuth2, tokenSource)
+
+	return swagger.NewAPIClient(swagger.NewConfiguration()), auth, nil
+}
@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aschbacd/strava-export/pkg/importer"
+	"golang.org/x/oauth2"
+)
+
+// runImport uploads the given files to Strava and prints the status of every file
+func runImport(config *oauth2.Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	token := addTokenFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s import [flags] file...\n\nFlags:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no files given")
+	}
+
+	client, auth, err := getAPIClient(config, *token)
+	if err != nil {
+		return err
+	}
+
+	// Read files
+	files := []importer.File{}
+	for _, path := range flags.Args() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, importer.File{Name: filepath.Base(path), Data: data})
+	}
+
+	// Upload files
+	failed := 0
+	(&importer.Importer{Client: client, Auth: auth}).Import(files, func(result importer.Result) {
+		line := fmt.Sprintf("%s: %s", result.File, result.Status)
+		if result.ActivityId != 0 {
+			line += fmt.Sprintf(" (https://www.strava.com/activities/%d)", result.ActivityId)
+		}
+		if result.Error != "" {
+			line += " - " + result.Error
+		}
+		fmt.Println(line)
+
+		if result.Status != importer.StatusCreated && result.Status != importer.StatusDuplicate {
+			failed++
+		}
+	})
+
+	if failed > 0 {
+		return fmt.Errorf("%d of the files could not be imported", failed)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/aschbacd/strava-export/pkg/mailer"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/oauth2"
+)
+
+// runMail sends a sample export using the configured smtp server (e.g. to check the settings
+// against a local smtp sink)
+func runMail(config *oauth2.Config, args []string) error {
+	flags := flag.NewFlagSet("mail", flag.ExitOnError)
+	to := flags.String("to", "", "recipient address")
+	language := flags.String("language", mailer.DefaultLanguage, "language of the email (de or en)")
+	link := flags.Bool("link", false, "send a link instead of an attachment")
+	flags.Parse(args)
+
+	if *to == "" {
+		flags.Usage()
+		return fmt.Errorf("recipient address is required")
+	}
+
+	// Sample range (last month)
+	first := time.Date(time.Now().Year(), time.Now().Month(), 1, 0, 0, 0, 0, time.Local)
+	data := struct {
+		Name     string
+		From     time.Time
+		To       time.Time
+		FileName string
+		Link     string
+	}{
+		Name:     "Test",
+		From:     first.AddDate(0, -1, 0),
+		To:       first.AddDate(0, 0, -1),
+		FileName: "test.xlsx",
+	}
+	if *link {
+		data.Link = utils.GetEnv("BASE_URL", "http://localhost:8080") + "/schedules"
+	}
+
+	subject, body, err := mailer.Render("export", *language, data)
+	if err != nil {
+		return err
+	}
+	message := mailer.Message{To: *to, Subject: subject, Body: body}
+
+	if !*link {
+		var buffer bytes.Buffer
+		if err := excelize.NewFile().Write(&buffer); err != nil {
+			return err
+		}
+		message.Attachments = []mailer.Attachment{{
+			Name:        data.FileName,
+			ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			Data:        buffer.Bytes(),
+		}}
+	}
+
+	if err := mailer.Send(mailer.GetConfig(), message); err != nil {
+		return err
+	}
+	fmt.Printf("sent %q to %s\n", subject, *to)
+	return nil
+}
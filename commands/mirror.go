@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/aschbacd/strava-export/pkg/mirror"
+	"golang.org/x/oauth2"
+)
+
+// runMirror copies all data of an athlete into a directory, running it again only adds new
+// activities (or resumes an interrupted mirror)
+func runMirror(config *oauth2.Config, args []string) error {
+	flags := flag.NewFlagSet("mirror", flag.ExitOnError)
+	token := addTokenFlag(flags)
+	dir := flags.String("dir", "strava-mirror", "directory of the mirror")
+	wait := flags.Bool("wait", true, "wait if the rate limit is reached (otherwise stop and resume on the next run)")
+	refreshDays := flags.Int("refresh-days", 0, "mirror activities of the last days again (e.g. to get new comments and kudos)")
+	flags.Parse(args)
+
+	if *token == "" {
+		return errors.New("refresh token missing (use -token or STRAVA_REFRESH_TOKEN)")
+	}
+	client := oauth2.NewClient(context.Background(), config.TokenSource(context.Background(), &oauth2.Token{RefreshToken: *token}))
+
+	m := mirror.Mirror{
+		Dir:     *dir,
+		Client:  client,
+		Wait:    *wait,
+		Refresh: time.Duration(*refreshDays) * 24 * time.Hour,
+		Log: func(format string, args ...interface{}) {
+			fmt.Printf(format+"\n", args...)
+		},
+	}
+	if err := m.Run(); err != nil {
+		return err
+	}
+	fmt.Println("mirror complete:", *dir)
+	return nil
+}
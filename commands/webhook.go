@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"golang.org/x/oauth2"
+)
+
+// runWebhook acts as local stand-in for Strava: it validates the callback like Strava does when a
+// subscription is created and posts a sample event
+func runWebhook(config *oauth2.Config, args []string) error {
+	flags := flag.NewFlagSet("webhook", flag.ExitOnError)
+	callbackURL := flags.String("url", utils.GetEnv("BASE_URL", "http://localhost:8080")+"/webhook", "callback url of the server")
+	verifyToken := flags.String("verify-token", os.Getenv("WEBHOOK_VERIFY_TOKEN"), "verify token of the server")
+	event := flags.String("event", "create", "event to send (create, update, delete or deauthorize)")
+	owner := flags.Int64("owner", 0, "athlete id (must have logged in before)")
+	activity := flags.Int64("activity", 0, "activity id (not required for deauthorize)")
+	title := flags.String("title", "", "new title sent with update events")
+	subscription := flags.Int64("subscription", 1, "subscription id (must match WEBHOOK_SUBSCRIPTION_ID of the server)")
+	flags.Parse(args)
+
+	if *owner == 0 || (*activity == 0 && *event != "deauthorize") {
+		flags.Usage()
+		return fmt.Errorf("athlete and activity id are required")
+	}
+
+	// Validate callback
+	challenge := "challenge-" + fmt.Sprint(time.Now().UnixNano())
+	query := url.Values{
+		"hub.mode":         {"subscribe"},
+		"hub.challenge":    {challenge},
+		"hub.verify_token": {*verifyToken},
+	}
+	resp, err := http.Get(*callbackURL + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var answer map[string]string
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("callback validation failed (status %d)", resp.StatusCode)
+	} else if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil || answer["hub.challenge"] != challenge {
+		return fmt.Errorf("callback validation failed (challenge not returned)")
+	}
+	fmt.Println("callback validated")
+
+	// Send event
+	sample := models.WebhookEvent{
+		ObjectType:     "activity",
+		ObjectId:       *activity,
+		AspectType:     *event,
+		Updates:        map[string]interface{}{},
+		OwnerId:        *owner,
+		SubscriptionId: *subscription,
+		EventTime:      time.Now().Unix(),
+	}
+	switch *event {
+	case "create", "delete":
+	case "update":
+		if *title != "" {
+			sample.Updates["title"] = *title
+		}
+	case "deauthorize":
+		sample.ObjectType = "athlete"
+		sample.ObjectId = *owner
+		sample.AspectType = "update"
+		sample.Updates["authorized"] = "false"
+	default:
+		return fmt.Errorf("unknown event %q", *event)
+	}
+
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	resp, err = http.Post(*callbackURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("event not accepted (status %d)", resp.StatusCode)
+	}
+	fmt.Printf("sent event: %s\n", data)
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -14,10 +15,19 @@ import (
 	"github.com/aschbacd/strava-export/models"
 	"github.com/aschbacd/strava-export/pkg/logger"
 	swagger "github.com/aschbacd/strava-export/pkg/strava"
+	"github.com/aschbacd/strava-export/pkg/userdata"
 	"github.com/aschbacd/strava-export/pkg/utils"
 	"github.com/gin-gonic/gin"
 )
 
+var (
+	// Returned by requests failing because of the rate limit
+	errRateLimitReached = fmt.Errorf("rate limit reached")
+
+	// Number of activities whose details are fetched at the same time
+	DETAILWORKERS = 4
+)
+
 // GetActivitiesPage returns the activities page
 func GetActivitiesPage(c *gin.Context) {
 	// Get page number (default = 1)
@@ -53,7 +63,7 @@ func GetActivitiesPage(c *gin.Context) {
 	linkAfter.Set("page", fmt.Sprint(pageNumber+1))
 
 	// Get activities (not detailed)
-	activities, rateLimitReached, errors := getActivities(c, athleteActivityOpts, false)
+	activities, rateLimitReached, errors := getActivities(c, athleteActivityOpts, fetchOptions{})
 	if len(errors) > 0 || rateLimitReached {
 		// Log all errors
 		for _, err := range errors {
@@ -70,15 +80,57 @@ func GetActivitiesPage(c *gin.Context) {
 		return
 	}
 
+	// Get athlete id (used to check for a template, failed scheduled exports and delivery settings)
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	failedRuns, err := countUnseenFailedRuns(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	mailSettings, err := loadMailSettings(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	storageSettings, err := loadStorageSettings(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
 	// Return activities view
 	c.HTML(http.StatusOK, "activities", gin.H{
-		"activities": activities,
-		"hasBefore":  pageNumber > 1,
-		"hasAfter":   len(activities) == 30,
-		"linkBefore": "?" + linkBefore.Encode(),
-		"linkAfter":  "?" + linkAfter.Encode(),
-		"from":       c.Query("from"),
-		"to":         c.Query("to"),
+		"activities":  activities,
+		"hasBefore":   pageNumber > 1,
+		"hasAfter":    len(activities) == 30,
+		"linkBefore":  "?" + linkBefore.Encode(),
+		"linkAfter":   "?" + linkAfter.Encode(),
+		"from":        c.Query("from"),
+		"to":          c.Query("to"),
+		"charts":      c.Query("charts") != "",
+		"laps":        c.Query("laps") != "",
+		"splits":      c.Query("splits") != "",
+		"zones":       c.Query("zones") != "",
+		"commute":     c.Query("commute") != "",
+		"units":       c.Query("units"),
+		"template":    c.Query("template") != "",
+		"hasTemplate": userdata.Exists(athleteID, TEMPLATEFILE),
+		"failedRuns":  failedRuns,
+		"canSendMail": canSendMail(mailSettings),
+		"hasStorage":  storageSettings.Type != models.StorageTypeNone,
+		"delivered":   c.Query("delivered"),
+		"csrfToken":   c.GetString("csrfToken"),
 	})
 }
 
@@ -109,123 +161,314 @@ func setAthleteActivitiesOpts(c *gin.Context, athleteActivityOpts *swagger.Activ
 	return nil
 }
 
-// getActivities generates a formatted list of activities
-func getActivities(c *gin.Context, athleteActivityOpts swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts, detailed bool) ([]models.Activity, bool, []error) {
-	// Get token source from authentication middleware
-	tokenSource, exists := c.Get("tokenSource")
-	if !exists {
-		return nil, false, []error{fmt.Errorf("client not passed by authentication middleware")}
+// fetchOptions defines which data is fetched in addition to the activity summary
+type fetchOptions struct {
+	// Calories, heart rate, gear, ...
+	Details bool
+	// Laps (requires an additional request per activity)
+	Laps bool
+	// Heart rate and power zones (requires an additional request per activity)
+	Zones bool
+}
+
+// fetchActivities gets all activities page by page and passes them to a handler in ascending order,
+// so that only a single page has to be kept in memory
+func fetchActivities(c *gin.Context, athleteActivityOpts swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts, options fetchOptions, handler func([]models.Activity) error) (bool, []error) {
+	// Activities are returned in ascending order if a start timestamp is set
+	if !athleteActivityOpts.After.IsSet() {
+		athleteActivityOpts.After = optional.NewInt32(0)
 	}
 
+	perPage := int32(30)
+	if athleteActivityOpts.PerPage.IsSet() {
+		perPage = athleteActivityOpts.PerPage.Value()
+	}
+
+	for page := int32(1); ; page++ {
+		athleteActivityOpts.Page = optional.NewInt32(page)
+
+		// Get activities of page
+		activities, rateLimitReached, errors := getActivities(c, athleteActivityOpts, options)
+		if len(errors) > 0 || rateLimitReached {
+			return rateLimitReached, errors
+		}
+
+		// Details are fetched concurrently, so activities of a page have to be sorted again
+		sort.Slice(activities, func(i, j int) bool {
+			return activities[i].Date.Unix() < activities[j].Date.Unix()
+		})
+
+		if err := handler(activities); err != nil {
+			return false, []error{err}
+		}
+
+		// Last page
+		if int32(len(activities)) < perPage {
+			return false, nil
+		}
+	}
+}
+
+// getActivities generates a formatted list of activities
+func getActivities(c *gin.Context, athleteActivityOpts swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts, options fetchOptions) ([]models.Activity, bool, []error) {
 	// Create new swagger client
-	client := swagger.NewAPIClient(swagger.NewConfiguration())
-	auth := context.WithValue(context.Background(), swagger.ContextOAuth2, tokenSource)
+	client, auth, err := getAPIClient(c)
+	if err != nil {
+		return nil, false, []error{err}
+	}
 
 	// Create list
 	activities := []models.Activity{}
 
 	// Get activities from Strava
-	stravaActivities, resp, _ := client.ActivitiesApi.GetLoggedInAthleteActivities(auth, &athleteActivityOpts)
-	if resp.StatusCode == http.StatusTooManyRequests {
-		return nil, true, []error{fmt.Errorf("rate limit reached")}
+	stravaActivities, resp, err := client.ActivitiesApi.GetLoggedInAthleteActivities(auth, &athleteActivityOpts)
+	if resp == nil {
+		return nil, false, []error{err}
+	} else if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, true, []error{errRateLimitReached}
 	} else if resp.StatusCode != http.StatusOK {
-		fmt.Println(resp.StatusCode)
-		return nil, false, []error{fmt.Errorf("failed to get activity summary")}
+		return nil, false, []error{fmt.Errorf("failed to get activity summary (status %d)", resp.StatusCode)}
 	}
 
-	// Create channels for details
-	channelActivities := make(chan models.Activity, 100)
-	channelErrors := make(chan error, 100)
-
-	var wg sync.WaitGroup
 	errors := []error{}
+	pending := []models.Activity{}
 
 	// Add activities to list
 	for _, stravaActivity := range stravaActivities {
-		// Convert int into duration
-		duration, err := time.ParseDuration(fmt.Sprint(stravaActivity.MovingTime) + "s")
+		activity, err := newActivity(stravaActivity)
 		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to convert int into duration"))
-		}
-
-		// Create activity
-		activity := models.Activity{
-			Id:            stravaActivity.Id,
-			Name:          stravaActivity.Name,
-			Date:          stravaActivity.StartDate,
-			DateLocal:     stravaActivity.StartDateLocal,
-			Distance:      math.Round(float64(stravaActivity.Distance/10)) / 100,
-			Duration:      duration,
-			ElevationGain: math.Round(float64(stravaActivity.TotalElevationGain*100)) / 100,
-			AverageSpeed:  math.Round(float64(stravaActivity.AverageSpeed*360)) / 100,
-			MaxSpeed:      math.Round(float64(stravaActivity.MaxSpeed*360)) / 100,
-			AverageWatts:  math.Round(float64(stravaActivity.AverageWatts*100)) / 100,
-			MaxWatts:      stravaActivity.MaxWatts,
-			Kilojoules:    math.Round(float64(stravaActivity.Kilojoules*100)) / 100,
+			errors = append(errors, err)
 		}
 
 		// Get activity details
-		if detailed {
-			wg.Add(1)
-			go getActivityDetails(c, activity, channelActivities, channelErrors, &wg)
+		if options.Details || options.Laps || options.Zones {
+			pending = append(pending, activity)
 		} else {
 			// Add activity
 			activities = append(activities, activity)
 		}
 	}
 
-	// Wait for goroutines to finish
-	wg.Wait()
-	close(channelActivities)
-	close(channelErrors)
+	// Create channels for details
+	channelPending := make(chan models.Activity)
+	channelActivities := make(chan models.Activity)
+	channelErrors := make(chan error)
 
-	// Add activities
-	for detailedActivity := range channelActivities {
-		activities = append(activities, detailedActivity)
+	// Details are fetched by a few workers, so a page doesn't send all requests at once
+	var wg sync.WaitGroup
+	for i := 0; i < DETAILWORKERS && i < len(pending); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for activity := range channelPending {
+				detailedActivity, err := getActivityDetails(c, activity, options)
+				if err != nil {
+					channelErrors <- err
+					continue
+				}
+				channelActivities <- detailedActivity
+			}
+		}()
 	}
 
-	// Add errors
-	for err := range channelErrors {
-		errors = append(errors, err)
+	// Close channels once all workers finished, they are drained meanwhile
+	go func() {
+		for _, activity := range pending {
+			channelPending <- activity
+		}
+		close(channelPending)
+		wg.Wait()
+		close(channelActivities)
+		close(channelErrors)
+	}()
+
+	// Add activities and errors
+	for channelActivities != nil || channelErrors != nil {
+		select {
+		case detailedActivity, ok := <-channelActivities:
+			if !ok {
+				channelActivities = nil
+				continue
+			}
+			activities = append(activities, detailedActivity)
+		case err, ok := <-channelErrors:
+			if !ok {
+				channelErrors = nil
+				continue
+			}
+			errors = append(errors, err)
+		}
 	}
 
+	// Requests of details, laps or zones can reach the rate limit as well
+	for _, err := range errors {
+		if err == errRateLimitReached {
+			return activities, true, errors
+		}
+	}
 	return activities, false, errors
 }
 
-// getActivityDetails fetches the details for an activity and pushes it to a channel
-func getActivityDetails(c *gin.Context, activity models.Activity, activities chan<- models.Activity, errors chan<- error, wg *sync.WaitGroup) {
+// newActivity converts an activity summary returned by Strava
+func newActivity(stravaActivity swagger.SummaryActivity) (models.Activity, error) {
+	var err error
+
+	// Convert int into duration
+	duration, parseErr := time.ParseDuration(fmt.Sprint(stravaActivity.MovingTime) + "s")
+	if parseErr != nil {
+		err = fmt.Errorf("failed to convert int into duration")
+	}
+
+	// Get activity type
+	activityType := ""
+	if stravaActivity.Type_ != nil {
+		activityType = string(*stravaActivity.Type_)
+	}
+
+	// Create activity
+	activity := models.Activity{
+		Id:            stravaActivity.Id,
+		Name:          stravaActivity.Name,
+		Type:          activityType,
+		GearId:        stravaActivity.GearId,
+		Commute:       stravaActivity.Commute,
+		Trainer:       stravaActivity.Trainer,
+		Date:          stravaActivity.StartDate,
+		DateLocal:     stravaActivity.StartDateLocal,
+		Distance:      math.Round(float64(stravaActivity.Distance/10)) / 100,
+		Duration:      duration,
+		ElevationGain: math.Round(float64(stravaActivity.TotalElevationGain*100)) / 100,
+		AverageSpeed:  math.Round(float64(stravaActivity.AverageSpeed*360)) / 100,
+		MaxSpeed:      math.Round(float64(stravaActivity.MaxSpeed*360)) / 100,
+		AverageWatts:  math.Round(float64(stravaActivity.AverageWatts*100)) / 100,
+		MaxWatts:      stravaActivity.MaxWatts,
+		Kilojoules:    math.Round(float64(stravaActivity.Kilojoules*100)) / 100,
+	}
+	return activity, err
+}
+
+// getAPIClient returns a swagger client and the context containing the token source
+func getAPIClient(c *gin.Context) (*swagger.APIClient, context.Context, error) {
+	// Get token source from authentication middleware
+	tokenSource, exists := c.Get("tokenSource")
+	if !exists {
+		return nil, nil, fmt.Errorf("client not passed by authentication middleware")
+	}
+
+	client := swagger.NewAPIClient(swagger.NewConfiguration())
+	auth := context.WithValue(context.Background(), swagger.ContextOAuth2, tokenSource)
+	return client, auth, nil
+}
+
+// getActivityDetails fetches the details, laps and zones of an activity
+func getActivityDetails(c *gin.Context, activity models.Activity, options fetchOptions) (models.Activity, error) {
+	// Get laps
+	if options.Laps {
+		laps, err := getActivityLaps(c, activity)
+		if err != nil {
+			return activity, err
+		}
+		activity.Laps = laps
+	}
+
+	// Get zones
+	if options.Zones {
+		zones, err := getActivityZones(c, activity)
+		if err != nil {
+			return activity, err
+		}
+		activity.Zones = zones
+	}
+
+	if !options.Details {
+		return activity, nil
+	}
+
+	stravaActivityDetails, err := fetchActivityDetails(c, activity.Id)
+	if err != nil {
+		return activity, err
+	}
+
+	// Set activity details
+	setActivityDetails(&activity, stravaActivityDetails)
+	return activity, nil
+}
+
+// setActivityDetails sets the attributes of an activity that are only contained in its detailed
+// representation
+func setActivityDetails(activity *models.Activity, details models.ActivityDetails) {
+	activity.AverageCadence = math.Round(float64(details.AverageCadence*100)) / 100
+	activity.AverageHeartRate = math.Round(float64(details.AverageHeartRate*100)) / 100
+	activity.MaxHeartRate = math.Round(float64(details.MaxHeartRate*100)) / 100
+	activity.Calories = math.Round(float64(details.Calories*100)) / 100
+	activity.GearName = details.Gear.Name
+	activity.SplitsMetric = details.SplitsMetric
+	activity.SplitsStandard = details.SplitsStandard
+}
+
+// fetchActivityDetails fetches the detailed representation of an activity
+func fetchActivityDetails(c *gin.Context, activityID int64) (models.ActivityDetails, error) {
 	// Get client from authentication middleware
 	client, exists := c.Get("client")
 	if !exists {
-		errors <- fmt.Errorf("client not passed by authentication middleware")
-		wg.Done()
-		return
+		return models.ActivityDetails{}, fmt.Errorf("client not passed by authentication middleware")
 	}
+	return requestActivityDetails(client.(*http.Client), activityID)
+}
+
+// requestActivityDetails fetches the detailed representation of an activity using an authenticated
+// client (also used outside of requests, e.g. for webhook events)
+func requestActivityDetails(client *http.Client, activityID int64) (models.ActivityDetails, error) {
+	var details models.ActivityDetails
 
 	// JSON response must be used instead of Object because some attributes are not supported
-	resp, err := client.(*http.Client).Get("https://www.strava.com/api/v3/activities/" + fmt.Sprint(activity.Id))
+	resp, err := client.Get("https://www.strava.com/api/v3/activities/" + fmt.Sprint(activityID))
 	if err != nil {
-		errors <- err
-		wg.Done()
-		return
+		return details, err
 	}
-
 	defer resp.Body.Close()
-	var stravaActivityDetails models.ActivityDetails
-	if err = json.NewDecoder(resp.Body).Decode(&stravaActivityDetails); err != nil {
-		errors <- err
-		wg.Done()
-		return
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return details, errRateLimitReached
+	} else if resp.StatusCode != http.StatusOK {
+		return details, fmt.Errorf("failed to get details of activity %d (status %d)", activityID, resp.StatusCode)
 	}
 
-	// Set activity details
-	activity.AverageCadence = math.Round(float64(stravaActivityDetails.AverageCadence*100)) / 100
-	activity.AverageHeartRate = math.Round(float64(stravaActivityDetails.AverageHeartRate*100)) / 100
-	activity.MaxHeartRate = math.Round(float64(stravaActivityDetails.MaxHeartRate*100)) / 100
-	activity.Calories = math.Round(float64(stravaActivityDetails.Calories*100)) / 100
-	activity.GearName = stravaActivityDetails.Gear.Name
-
-	// Push activity to activities
-	activities <- activity
-	wg.Done()
+	err = json.NewDecoder(resp.Body).Decode(&details)
+	return details, err
+}
+
+// getActivityLaps fetches the laps of an activity
+func getActivityLaps(c *gin.Context, activity models.Activity) ([]models.Lap, error) {
+	client, auth, err := getAPIClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	stravaLaps, resp, err := client.ActivitiesApi.GetLapsByActivityId(auth, activity.Id)
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return nil, errRateLimitReached
+	} else if err != nil {
+		return nil, err
+	}
+
+	laps := []models.Lap{}
+	for _, stravaLap := range stravaLaps {
+		laps = append(laps, models.Lap{
+			Id:             stravaLap.Id,
+			ActivityId:     activity.Id,
+			ActivityName:   activity.Name,
+			LapIndex:       stravaLap.LapIndex,
+			Name:           stravaLap.Name,
+			DateLocal:      stravaLap.StartDateLocal,
+			Distance:       float64(stravaLap.Distance),
+			MovingTime:     time.Duration(stravaLap.MovingTime) * time.Second,
+			ElapsedTime:    time.Duration(stravaLap.ElapsedTime) * time.Second,
+			AverageSpeed:   float64(stravaLap.AverageSpeed),
+			MaxSpeed:       float64(stravaLap.MaxSpeed),
+			AverageCadence: math.Round(float64(stravaLap.AverageCadence*100)) / 100,
+			ElevationGain:  float64(stravaLap.TotalElevationGain),
+		})
+	}
+	return laps, nil
 }
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"sync"
+
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/userdata"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ACTIVITYCACHEFILE = "activity-cache.json"
+
+	// Caches are changed by page requests and webhook events, so every change is done while holding
+	// the lock of the athlete
+	activityCacheMutexes      = map[int64]*sync.Mutex{}
+	activityCacheMutexesMutex sync.Mutex
+)
+
+// activityCache contains details of activities that are expensive to fetch (one request per activity)
+type activityCache map[int64]models.CachedActivity
+
+// loadActivityCache loads the cached activities of an athlete
+func loadActivityCache(athleteID int64) (activityCache, error) {
+	cache := activityCache{}
+	if err := userdata.Load(athleteID, ACTIVITYCACHEFILE, &cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// saveActivityCache stores the cached activities of an athlete
+func saveActivityCache(athleteID int64, cache activityCache) error {
+	return userdata.Save(athleteID, ACTIVITYCACHEFILE, cache)
+}
+
+// modifyActivityCache loads, changes and stores the cache of an athlete while holding its lock and
+// returns the stored cache (no requests should be sent by modify, so the lock is held shortly)
+func modifyActivityCache(athleteID int64, modify func(cache activityCache)) (activityCache, error) {
+	activityCacheMutexesMutex.Lock()
+	mutex, exists := activityCacheMutexes[athleteID]
+	if !exists {
+		mutex = &sync.Mutex{}
+		activityCacheMutexes[athleteID] = mutex
+	}
+	activityCacheMutexesMutex.Unlock()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	cache, err := loadActivityCache(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	modify(cache)
+	return cache, saveActivityCache(athleteID, cache)
+}
+
+// updateActivityCache fetches the details of all activities that are not cached yet, the cache is
+// stored even if a request fails so already fetched details don't have to be fetched again (cache is
+// replaced by the stored cache, so it contains changes of webhook events)
+func updateActivityCache(c *gin.Context, athleteID int64, cache activityCache, activities []models.Activity) error {
+	fetched := []models.CachedActivity{}
+	var err error
+	for _, activity := range activities {
+		if _, exists := cache[activity.Id]; exists {
+			continue
+		}
+
+		var details models.ActivityDetails
+		details, err = fetchActivityDetails(c, activity.Id)
+		if err != nil {
+			break
+		}
+
+		fetched = append(fetched, models.CachedActivity{
+			Id:             activity.Id,
+			Name:           activity.Name,
+			Type:           activity.Type,
+			DateLocal:      activity.DateLocal,
+			BestEfforts:    details.BestEfforts,
+			SegmentEfforts: details.SegmentEfforts,
+		})
+	}
+	if len(fetched) == 0 {
+		return err
+	}
+
+	stored, saveErr := modifyActivityCache(athleteID, func(stored activityCache) {
+		for _, activity := range fetched {
+			stored[activity.Id] = activity
+		}
+	})
+	if saveErr != nil {
+		return saveErr
+	}
+	for id := range cache {
+		if _, exists := stored[id]; !exists {
+			delete(cache, id)
+		}
+	}
+	for id, activity := range stored {
+		cache[id] = activity
+	}
+	return err
+}
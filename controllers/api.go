@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antihax/optional"
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Prefix of all api routes
+	APIPREFIX = "/api/"
+
+	// Default and maximum number of activities per api response
+	APIDEFAULTLIMIT = 30
+	APIMAXLIMIT     = 200
+)
+
+// apiActivitiesResponse is a page of activities, the cursor is empty on the last page
+type apiActivitiesResponse struct {
+	Activities []models.Activity `json:"activities"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// apiCursor is the position of a page, activities started at the timestamp with one of the ids were
+// already returned
+type apiCursor struct {
+	Timestamp int32
+	Ids       []int64
+}
+
+// GetAPIActivities returns activities in ascending order, the date range is filtered the same way as
+// on the activities page (from, to), following pages are requested with the returned cursor
+func GetAPIActivities(c *gin.Context) {
+	// Create activities api config
+	athleteActivityOpts := swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts{}
+
+	// Set timestamps for activities api config
+	if err := setAthleteActivitiesOpts(c, &athleteActivityOpts); err != nil {
+		returnAPIError(c, http.StatusBadRequest, "invalid date (expected YYYY-MM-DD)")
+		return
+	}
+
+	// Activities are returned in ascending order if a start timestamp is set, the cursor contains the
+	// start timestamp of the last returned activity and the ids of all returned activities with this
+	// timestamp (the next page starts one second earlier, so activities with the same start aren't
+	// skipped)
+	after := int32(0)
+	if athleteActivityOpts.After.IsSet() {
+		after = athleteActivityOpts.After.Value()
+	}
+	cursor := apiCursor{}
+	if value := c.Query("cursor"); value != "" {
+		var err error
+		if cursor, err = decodeAPICursor(value); err != nil {
+			returnAPIError(c, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		if cursor.Timestamp > after {
+			after = cursor.Timestamp - 1
+		}
+	}
+	athleteActivityOpts.After = optional.NewInt32(after)
+
+	// Page size
+	limit := APIDEFAULTLIMIT
+	if value := c.Query("limit"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 || number > APIMAXLIMIT {
+			returnAPIError(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", APIMAXLIMIT))
+			return
+		}
+		limit = number
+	}
+
+	// Activities of the cursor are requested again and removed afterwards
+	perPage := limit + len(cursor.Ids)
+	if perPage > APIMAXLIMIT {
+		perPage = APIMAXLIMIT
+		limit = perPage - len(cursor.Ids)
+	}
+	athleteActivityOpts.PerPage = optional.NewInt32(int32(perPage))
+	athleteActivityOpts.Page = optional.NewInt32(1)
+
+	// Get activities
+	activities, rateLimitReached, errs := getActivities(c, athleteActivityOpts, getAPIFetchOptions(c))
+	if rateLimitReached {
+		returnAPIError(c, http.StatusTooManyRequests, errRateLimitReached.Error())
+		return
+	} else if len(errs) > 0 {
+		for _, err := range errs {
+			logger.Error(err.Error())
+		}
+		returnAPIError(c, http.StatusInternalServerError, "failed to get activities")
+		return
+	}
+	lastPage := len(activities) < perPage
+
+	// Details are fetched concurrently, so activities have to be sorted again
+	sort.Slice(activities, func(i, j int) bool {
+		if activities[i].Date.Equal(activities[j].Date) {
+			return activities[i].Id < activities[j].Id
+		}
+		return activities[i].Date.Before(activities[j].Date)
+	})
+
+	// Remove activities returned on previous pages
+	returned := map[int64]bool{}
+	for _, id := range cursor.Ids {
+		returned[id] = true
+	}
+	page := []models.Activity{}
+	for _, activity := range activities {
+		if int32(activity.Date.Unix()) == cursor.Timestamp && returned[activity.Id] {
+			continue
+		}
+		page = append(page, activity)
+	}
+	if len(page) > limit {
+		page = page[:limit]
+	}
+
+	response := apiActivitiesResponse{Activities: page}
+	if !lastPage && len(page) > 0 {
+		next := apiCursor{Timestamp: int32(page[len(page)-1].Date.Unix())}
+		if next.Timestamp == cursor.Timestamp {
+			next.Ids = cursor.Ids
+		}
+		for _, activity := range page {
+			if int32(activity.Date.Unix()) == next.Timestamp {
+				next.Ids = append(next.Ids, activity.Id)
+			}
+		}
+		response.NextCursor = encodeAPICursor(next)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetAPIActivity returns a single activity including its details
+func GetAPIActivity(c *gin.Context) {
+	activityID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		returnAPIError(c, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+
+	activity, err := getActivity(c, activityID, getAPIFetchOptions(c))
+	if err != nil {
+		handleAPIError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, activity)
+}
+
+// GetAPIExport returns the Excel report, the options are the same as on the activities page (from, to,
+// charts, laps, splits, zones, units and template)
+func GetAPIExport(c *gin.Context) {
+	f, err := createExport(c)
+	if err != nil {
+		handleAPIError(c, err)
+		return
+	}
+
+	// Set headers to make file downloadable
+	c.Header("Content-Disposition", "attachment;filename=strava-export.xlsx")
+	c.Header("File-Name", "strava-export.xlsx")
+	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+
+	// Write file to gin's response writer
+	f.Write(c.Writer)
+}
+
+// summaryActivity decodes the coordinates of an activity, which are not supported by the generated
+// types
+type summaryActivity struct {
+	swagger.SummaryActivity
+	StartLatlng json.RawMessage `json:"start_latlng,omitempty"`
+	EndLatlng   json.RawMessage `json:"end_latlng,omitempty"`
+}
+
+// getActivity fetches a single activity, the summary and details are decoded from the same response
+func getActivity(c *gin.Context, activityID int64, options fetchOptions) (models.Activity, error) {
+	var data json.RawMessage
+	if err := getStravaJSON(c, fmt.Sprintf("/activities/%d", activityID), &data); err != nil {
+		return models.Activity{}, err
+	}
+
+	var summary summaryActivity
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return models.Activity{}, err
+	}
+
+	var details models.ActivityDetails
+	if err := json.Unmarshal(data, &details); err != nil {
+		return models.Activity{}, err
+	}
+
+	activity, err := newActivity(summary.SummaryActivity)
+	if err != nil {
+		return activity, err
+	}
+	setActivityDetails(&activity, details)
+
+	if options.Laps {
+		if activity.Laps, err = getActivityLaps(c, activity); err != nil {
+			return activity, err
+		}
+	}
+	if options.Zones {
+		if activity.Zones, err = getActivityZones(c, activity); err != nil {
+			return activity, err
+		}
+	}
+	return activity, nil
+}
+
+// getAPIFetchOptions returns the data requested in addition to the activity summary (details, laps,
+// zones)
+func getAPIFetchOptions(c *gin.Context) fetchOptions {
+	isSet := func(key string) bool {
+		value, _ := strconv.ParseBool(c.DefaultQuery(key, "false"))
+		return value
+	}
+	return fetchOptions{Details: isSet("details"), Laps: isSet("laps"), Zones: isSet("zones")}
+}
+
+// encodeAPICursor returns an opaque cursor (e.g. "1700000000:123,456")
+func encodeAPICursor(cursor apiCursor) string {
+	ids := []string{}
+	for _, id := range cursor.Ids {
+		ids = append(ids, fmt.Sprint(id))
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", cursor.Timestamp, strings.Join(ids, ","))))
+}
+
+// decodeAPICursor returns the position of a cursor
+func decodeAPICursor(value string) (apiCursor, error) {
+	cursor := apiCursor{}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+
+	parts := strings.SplitN(string(data), ":", 2)
+	timestamp, err := strconv.ParseInt(parts[0], 10, 32)
+	if err != nil {
+		return cursor, err
+	}
+	cursor.Timestamp = int32(timestamp)
+	if len(parts) == 2 && parts[1] != "" {
+		for _, value := range strings.Split(parts[1], ",") {
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return cursor, err
+			}
+			cursor.Ids = append(cursor.Ids, id)
+		}
+	}
+	if len(cursor.Ids) >= APIMAXLIMIT {
+		return cursor, fmt.Errorf("too many activities in cursor")
+	}
+	return cursor, nil
+}
+
+// isAPIRequest checks if a request is sent to the api (errors are returned as JSON)
+func isAPIRequest(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.URL.Path, APIPREFIX)
+}
+
+// returnAPIError aborts an api request with an error message
+func returnAPIError(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": message})
+}
+
+// handleAPIError returns the status matching an error (rate limit, missing resource, ...)
+func handleAPIError(c *gin.Context, err error) {
+	if err == errRateLimitReached {
+		returnAPIError(c, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if statusErr, ok := err.(stravaStatusError); ok && statusErr == http.StatusNotFound {
+		returnAPIError(c, http.StatusNotFound, "not found")
+		return
+	}
+
+	logger.Error(err.Error())
+	returnAPIError(c, http.StatusInternalServerError, "internal error")
+}
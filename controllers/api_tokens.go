@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/userdata"
+	"github.com/aschbacd/strava-export/pkg/utils"
+)
+
+var (
+	APITOKENSFILE = "api-tokens.json"
+
+	// Prefix of personal api tokens (followed by athlete id and secret)
+	apiTokenPrefix = "sx"
+	// Minimum time between updates of the last usage of a token
+	apiTokenUsageInterval = time.Minute
+	// Tokens are read, changed and stored again by concurrent requests
+	apiTokensMutex sync.Mutex
+)
+
+// loadAPITokens loads the personal api tokens of an athlete
+func loadAPITokens(athleteID int64) ([]models.APIToken, error) {
+	tokens := []models.APIToken{}
+	if err := userdata.Load(athleteID, APITOKENSFILE, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// createAPIToken creates a personal api token and returns it in plain text (only its hash is stored)
+func createAPIToken(athleteID int64, name, scope string) (models.APIToken, string, error) {
+	apiTokensMutex.Lock()
+	defer apiTokensMutex.Unlock()
+
+	tokens, err := loadAPITokens(athleteID)
+	if err != nil {
+		return models.APIToken{}, "", err
+	}
+
+	// The athlete id is part of the token, so only the tokens of a single athlete have to be checked
+	plain := fmt.Sprintf("%s_%d_%s", apiTokenPrefix, athleteID, utils.GetRandomString(40))
+	token := models.APIToken{
+		Id:      utils.GetRandomString(16),
+		Name:    name,
+		Scope:   scope,
+		Hash:    hashAPIToken(plain),
+		Created: time.Now(),
+	}
+
+	tokens = append(tokens, token)
+	return token, plain, userdata.Save(athleteID, APITOKENSFILE, tokens)
+}
+
+// revokeAPIToken removes a personal api token
+func revokeAPIToken(athleteID int64, id string) error {
+	apiTokensMutex.Lock()
+	defer apiTokensMutex.Unlock()
+
+	tokens, err := loadAPITokens(athleteID)
+	if err != nil {
+		return err
+	}
+
+	remaining := []models.APIToken{}
+	for _, token := range tokens {
+		if token.Id != id {
+			remaining = append(remaining, token)
+		}
+	}
+	return userdata.Save(athleteID, APITOKENSFILE, remaining)
+}
+
+// validateAPIToken returns the athlete and the stored token matching a token in plain text, the last
+// usage of the token is updated
+func validateAPIToken(plain string) (int64, models.APIToken, error) {
+	errInvalid := fmt.Errorf("invalid api token")
+
+	parts := strings.SplitN(plain, "_", 3)
+	if len(parts) != 3 || parts[0] != apiTokenPrefix {
+		return 0, models.APIToken{}, errInvalid
+	}
+	athleteID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || !userdata.Exists(athleteID, APITOKENSFILE) {
+		return 0, models.APIToken{}, errInvalid
+	}
+
+	apiTokensMutex.Lock()
+	defer apiTokensMutex.Unlock()
+
+	tokens, err := loadAPITokens(athleteID)
+	if err != nil {
+		return 0, models.APIToken{}, err
+	}
+
+	hash := hashAPIToken(plain)
+	for i, token := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) != 1 {
+			continue
+		}
+
+		if time.Since(token.LastUsed) > apiTokenUsageInterval {
+			tokens[i].LastUsed = time.Now()
+			if err := userdata.Save(athleteID, APITOKENSFILE, tokens); err != nil {
+				return 0, models.APIToken{}, err
+			}
+		}
+		return athleteID, tokens[i], nil
+	}
+	return 0, models.APIToken{}, errInvalid
+}
+
+// hashAPIToken returns the hash of a token that is stored instead of the token itself
+func hashAPIToken(plain string) string {
+	hash := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(hash[:])
+}
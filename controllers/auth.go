@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strings"
 
 	"github.com/aschbacd/strava-export/pkg/logger"
 	"github.com/aschbacd/strava-export/pkg/utils"
@@ -68,17 +69,40 @@ func (ac *AuthController) AuthenticateUser(c *gin.Context) {
 		return
 	}
 
+	// Get athlete id (returned together with the token)
+	var athleteID int64
+	if athlete, ok := token.Extra("athlete").(map[string]interface{}); ok {
+		if id, ok := athlete["id"].(float64); ok {
+			athleteID = int64(id)
+		}
+	}
+
+	// Store token for requests without session (e.g. webhook events)
+	if athleteID != 0 {
+		if err := saveAthleteToken(athleteID, token); err != nil {
+			logger.Error(err.Error())
+			utils.ReturnErrorPage(c)
+			return
+		}
+	}
+
 	// Store token in session
 	session := sessions.Default(c)
+	redirect, _ := session.Get("redirect").(string)
+	session.Delete("redirect")
 	session.Set("token", tokenJSON)
+	session.Set("athleteId", athleteID)
 	if err := session.Save(); err != nil {
 		logger.Error(err.Error())
 		utils.ReturnErrorPage(c)
 		return
 	}
 
-	// Redirect to activities page
-	c.Redirect(http.StatusFound, "/")
+	// Redirect to the page requested before logging in (only local paths) or to the activities page
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		redirect = "/"
+	}
+	c.Redirect(http.StatusFound, redirect)
 }
 
 // Logout invalidates the token and clears the session
@@ -0,0 +1,373 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/antihax/optional"
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+var (
+	// Maximum page size of club requests
+	CLUBSPERPAGE = 200
+
+	CLUBRANKINGSHEETNAME    = "Rangliste"
+	CLUBACTIVITIESSHEETNAME = "Aktivitäten"
+	CLUBNOTESSHEETNAME      = "Hinweise"
+
+	// Limitations of club activities returned by Strava
+	clubNotes = []string{
+		"Strava liefert nur die letzten Aktivitäten eines Clubs, ein Zeitraum kann nicht gewählt werden.",
+		"Aktivitäten enthalten kein Datum und keine Id, daher können sie nicht mit einzelnen Aktivitäten verknüpft werden.",
+		"Mitglieder werden nur mit Vornamen und dem ersten Buchstaben des Nachnamens angegeben, gleichnamige Mitglieder werden zusammengefasst.",
+		"Aktivitäten von Mitgliedern mit privaten Profilen oder Aktivitäten fehlen.",
+	}
+)
+
+// clubReport contains the activities of a club aggregated per member
+type clubReport struct {
+	Club       models.Club
+	Activities []models.ClubActivity
+	Members    []models.ClubMemberStats
+	Types      []string
+}
+
+// GetClubsPage returns the page listing all clubs of the athlete
+func GetClubsPage(c *gin.Context) {
+	clubs, err := getClubs(c)
+	if err == errRateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.HTML(http.StatusOK, "clubs", gin.H{
+		"clubs": clubs,
+	})
+}
+
+// GetClubPage returns the ranking of the members of a club
+func GetClubPage(c *gin.Context) {
+	report, err := getClubReport(c)
+	if err == errRateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.HTML(http.StatusOK, "club", gin.H{
+		"club":       report.Club,
+		"members":    report.Members,
+		"activities": len(report.Activities),
+		"types":      report.Types,
+		"type":       c.Query("type"),
+		"notes":      clubNotes,
+	})
+}
+
+// ExportClub exports the ranking of the members of a club as Excel report
+func ExportClub(c *gin.Context) {
+	report, err := getClubReport(c)
+	if err == errRateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	f := excelize.NewFile()
+	f.SetSheetName("Sheet1", CLUBRANKINGSHEETNAME)
+	f.NewSheet(CLUBACTIVITIESSHEETNAME)
+	f.NewSheet(CLUBNOTESSHEETNAME)
+
+	if err := addClubSheets(f, report); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Set headers to make file downloadable
+	c.Header("Content-Disposition", "attachment;filename=strava-club.xlsx")
+	c.Header("File-Name", "strava-club.xlsx")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Expires", "0")
+
+	// Write file to gin's response writer
+	f.Write(c.Writer)
+}
+
+// addClubSheets writes the ranking, the activities and the limitations of the data to the Excel file
+func addClubSheets(f *excelize.File, report *clubReport) error {
+	// Ranking (ranks are calculated by formulas)
+	if err := setExcelValues(f, CLUBRANKINGSHEETNAME, 1, []interface{}{
+		report.Club.Name,
+	}); err != nil {
+		return err
+	}
+	if err := setExcelValues(f, CLUBRANKINGSHEETNAME, 3, []interface{}{
+		"Rang", "Mitglied", "Aktivitäten", "Strecke [km]", "Höhenzunahme [m]", "Zeit", "Rang Strecke", "Rang Höhenzunahme", "Rang Aktivitäten",
+	}); err != nil {
+		return err
+	}
+
+	firstRow, lastRow := 4, len(report.Members)+3
+	for i, member := range report.Members {
+		row := i + firstRow
+		rank := func(col string) excelFormula {
+			return excelFormula(fmt.Sprintf("RANK(%s%d,$%s$%d:$%s$%d)", col, row, col, firstRow, col, lastRow))
+		}
+
+		if err := setExcelValues(f, CLUBRANKINGSHEETNAME, row, []interface{}{
+			member.Rank,
+			member.Name,
+			member.Activities,
+			member.Distance,
+			member.ElevationGain,
+			member.MovingTime,
+			rank("D"),
+			rank("E"),
+			rank("C"),
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Activities
+	if err := setExcelValues(f, CLUBACTIVITIESSHEETNAME, 1, []interface{}{
+		"Mitglied", "Name", "Sportart", "Strecke [km]", "Zeit", "Gesamtzeit", "Höhenzunahme [m]",
+	}); err != nil {
+		return err
+	}
+	for i, activity := range report.Activities {
+		if err := setExcelValues(f, CLUBACTIVITIESSHEETNAME, i+2, []interface{}{
+			activity.GetAthleteName(),
+			activity.Name,
+			activity.Type,
+			models.MetricUnits.Distance(activity.Distance),
+			time.Duration(activity.MovingTime) * time.Second,
+			time.Duration(activity.ElapsedTime) * time.Second,
+			models.MetricUnits.Elevation(activity.TotalElevationGain),
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Notes
+	for i, note := range append([]string{"Hinweise zu Club-Aktivitäten"}, clubNotes...) {
+		if err := setExcelValues(f, CLUBNOTESSHEETNAME, i+1, []interface{}{note}); err != nil {
+			return err
+		}
+	}
+
+	// Format cells
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	titleStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true, Size: 14}})
+	if err != nil {
+		return err
+	}
+	durationStyle, err := f.NewStyle(&excelize.Style{NumFmt: 46})
+	if err != nil {
+		return err
+	}
+
+	for _, style := range []struct {
+		Sheet        string
+		HCell, VCell string
+		StyleID      int
+	}{
+		{CLUBRANKINGSHEETNAME, "A1", "A1", titleStyle},
+		{CLUBRANKINGSHEETNAME, "A3", "I3", headerStyle},
+		{CLUBRANKINGSHEETNAME, "F4", fmt.Sprintf("F%d", lastRow), durationStyle},
+		{CLUBACTIVITIESSHEETNAME, "A1", "G1", headerStyle},
+		{CLUBACTIVITIESSHEETNAME, "E2", fmt.Sprintf("F%d", len(report.Activities)+1), durationStyle},
+		{CLUBNOTESSHEETNAME, "A1", "A1", headerStyle},
+	} {
+		if err := f.SetCellStyle(style.Sheet, style.HCell, style.VCell, style.StyleID); err != nil {
+			return err
+		}
+	}
+
+	for _, width := range []struct {
+		Sheet    string
+		From, To string
+		Width    float64
+	}{
+		{CLUBRANKINGSHEETNAME, "A", "A", 8},
+		{CLUBRANKINGSHEETNAME, "B", "B", 25},
+		{CLUBRANKINGSHEETNAME, "C", "I", 18},
+		{CLUBACTIVITIESSHEETNAME, "A", "A", 25},
+		{CLUBACTIVITIESSHEETNAME, "B", "B", 40},
+		{CLUBACTIVITIESSHEETNAME, "C", "G", 16},
+		{CLUBNOTESSHEETNAME, "A", "A", 120},
+	} {
+		if err := f.SetColWidth(width.Sheet, width.From, width.To, width.Width); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getClubReport gets the recent activities of the club passed as parameter and aggregates them per
+// member (optionally filtered by activity type), members without activities are included
+func getClubReport(c *gin.Context) (*clubReport, error) {
+	clubID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	client, auth, err := getAPIClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get club
+	stravaClub, resp, err := client.ClubsApi.GetClubById(auth, clubID)
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return nil, errRateLimitReached
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get club %d: %s", clubID, err.Error())
+	}
+
+	report := &clubReport{
+		Club: models.Club{
+			Id:          stravaClub.Id,
+			Name:        stravaClub.Name,
+			SportType:   stravaClub.SportType,
+			City:        stravaClub.City,
+			MemberCount: stravaClub.MemberCount,
+		},
+	}
+
+	// Get members
+	stats := map[string]*models.ClubMemberStats{}
+	for page := int32(1); ; page++ {
+		members, resp, err := client.ClubsApi.GetClubMembersById(auth, clubID, &swagger.ClubsApiGetClubMembersByIdOpts{
+			Page:    optional.NewInt32(page),
+			PerPage: optional.NewInt32(int32(CLUBSPERPAGE)),
+		})
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			return nil, errRateLimitReached
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get members of club %d: %s", clubID, err.Error())
+		}
+
+		for _, member := range members {
+			name := models.GetClubMemberName(member.Firstname, member.Lastname)
+			stats[name] = &models.ClubMemberStats{Name: name}
+		}
+		if len(members) < CLUBSPERPAGE {
+			break
+		}
+	}
+
+	// Get activities (JSON response must be used because athlete names are not supported by Object)
+	types := map[string]bool{}
+	for page := 1; ; page++ {
+		activities := []models.ClubActivity{}
+		query := url.Values{"page": {fmt.Sprint(page)}, "per_page": {fmt.Sprint(CLUBSPERPAGE)}}
+		if err := getStravaJSON(c, fmt.Sprintf("/clubs/%d/activities?%s", clubID, query.Encode()), &activities); err != nil {
+			return nil, err
+		}
+
+		for _, activity := range activities {
+			types[activity.Type] = true
+			if activityType := c.Query("type"); activityType != "" && activity.Type != activityType {
+				continue
+			}
+			report.Activities = append(report.Activities, activity)
+
+			name := activity.GetAthleteName()
+			member, exists := stats[name]
+			if !exists {
+				member = &models.ClubMemberStats{Name: name}
+				stats[name] = member
+			}
+			member.Activities++
+			member.Distance += models.MetricUnits.Distance(activity.Distance)
+			member.ElevationGain += activity.TotalElevationGain
+			member.MovingTime += time.Duration(activity.MovingTime) * time.Second
+		}
+		if len(activities) < CLUBSPERPAGE {
+			break
+		}
+	}
+
+	// Rank members by distance
+	for _, member := range stats {
+		member.Distance = math.Round(member.Distance*100) / 100
+		member.ElevationGain = math.Round(member.ElevationGain*100) / 100
+		report.Members = append(report.Members, *member)
+	}
+	sort.Slice(report.Members, func(i, j int) bool {
+		if report.Members[i].Distance != report.Members[j].Distance {
+			return report.Members[i].Distance > report.Members[j].Distance
+		}
+		return report.Members[i].Name < report.Members[j].Name
+	})
+	for i := range report.Members {
+		report.Members[i].Rank = i + 1
+	}
+
+	for activityType := range types {
+		report.Types = append(report.Types, activityType)
+	}
+	sort.Strings(report.Types)
+
+	return report, nil
+}
+
+// getClubs fetches all clubs of the logged in athlete
+func getClubs(c *gin.Context) ([]models.Club, error) {
+	client, auth, err := getAPIClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	clubs := []models.Club{}
+	for page := int32(1); ; page++ {
+		stravaClubs, resp, err := client.ClubsApi.GetLoggedInAthleteClubs(auth, &swagger.ClubsApiGetLoggedInAthleteClubsOpts{
+			Page:    optional.NewInt32(page),
+			PerPage: optional.NewInt32(int32(CLUBSPERPAGE)),
+		})
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			return nil, errRateLimitReached
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get clubs: %s", err.Error())
+		}
+
+		for _, stravaClub := range stravaClubs {
+			clubs = append(clubs, models.Club{
+				Id:          stravaClub.Id,
+				Name:        stravaClub.Name,
+				SportType:   stravaClub.SportType,
+				City:        stravaClub.City,
+				MemberCount: stravaClub.MemberCount,
+			})
+		}
+		if len(stravaClubs) < CLUBSPERPAGE {
+			return clubs, nil
+		}
+	}
+}
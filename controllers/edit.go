@@ -0,0 +1,650 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antihax/optional"
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+	"github.com/aschbacd/strava-export/pkg/userdata"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+var (
+	EDITFILE  = "edit-pending.json"
+	AUDITFILE = "edit-audit.json"
+
+	// Maximum size of uploaded edit workbooks
+	MAXEDITSIZE int64 = 10 << 20
+	// Number of audit log entries shown on the edit page
+	AUDITENTRIESSHOWN = 50
+
+	// Status of audit log entries
+	auditStatusApplied = "Übernommen"
+	auditStatusFailed  = "Fehler"
+
+	// Changes are applied in the background, the last run of every athlete is kept to show its
+	// results
+	editJobs      = map[int64]*editJob{}
+	editJobsMutex sync.Mutex
+)
+
+// editJob applies the pending changes of an athlete in the background
+type editJob struct {
+	Total            int
+	Applied          int
+	Failed           int
+	RateLimitReached bool
+	Done             bool
+}
+
+// pendingEdits are the changes found in an uploaded workbook that were not applied yet
+type pendingEdits struct {
+	Uploaded time.Time               `json:"uploaded"`
+	Updates  []models.ActivityUpdate `json:"updates"`
+	// Rows that could not be processed
+	Errors []string `json:"errors"`
+}
+
+// GetEditPage returns the page used to download and upload edit workbooks, it shows a preview of
+// the pending changes and the audit log
+func GetEditPage(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	pending := pendingEdits{}
+	if err := userdata.Load(athleteID, EDITFILE, &pending); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	entries := []models.AuditEntry{}
+	if err := userdata.Load(athleteID, AUDITFILE, &entries); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Show latest entries first
+	latest := []models.AuditEntry{}
+	for i := len(entries) - 1; i >= 0 && len(latest) < AUDITENTRIESSHOWN; i-- {
+		latest = append(latest, entries[i])
+	}
+
+	// Result of the last run is shown like the results of imports
+	job := getEditJob(athleteID)
+	errorType := c.Query("error")
+	if errorType == "" && job.Done && job.RateLimitReached {
+		errorType = "rate-limit"
+	}
+
+	c.HTML(http.StatusOK, "edit", gin.H{
+		"from":      c.Query("from"),
+		"to":        c.Query("to"),
+		"error":     errorType,
+		"running":   job.Total > 0 && !job.Done,
+		"job":       job,
+		"pending":   pending,
+		"audit":     latest,
+		"csrfToken": c.GetString("csrfToken"),
+	})
+}
+
+// UploadEditWorkbook compares an uploaded edit workbook with the current activities and stores the
+// changes, so they can be reviewed before they are applied
+func UploadEditWorkbook(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	if job := getEditJob(athleteID); job.Total > 0 && !job.Done {
+		c.Redirect(http.StatusFound, "/edit?error=running")
+		return
+	}
+
+	// Get uploaded file
+	fileHeader, err := c.FormFile("workbook")
+	if err != nil {
+		c.Redirect(http.StatusFound, "/edit?error=missing")
+		return
+	}
+	if fileHeader.Size > MAXEDITSIZE {
+		c.Redirect(http.StatusFound, "/edit?error=size")
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Read rows of edit sheet
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/edit?error=invalid")
+		return
+	}
+	rows, err := f.GetRows(EDITSHEETNAME, excelize.Options{RawCellValue: true})
+	if err != nil || len(rows) == 0 || strings.Join(rows[0], ",") != strings.Join(editColumns, ",") {
+		c.Redirect(http.StatusFound, "/edit?error=invalid")
+		return
+	}
+
+	edits, errors := parseEditRows(rows[1:])
+	if len(edits) == 0 {
+		pending := pendingEdits{Uploaded: time.Now(), Errors: errors}
+		if err := userdata.Save(athleteID, EDITFILE, pending); err != nil {
+			logger.Error(err.Error())
+			utils.ReturnErrorPage(c)
+			return
+		}
+		c.Redirect(http.StatusFound, "/edit")
+		return
+	}
+
+	// Get current activities in the date range of the workbook (local dates may differ by a day)
+	first, last := edits[0].DateLocal, edits[0].DateLocal
+	for _, edit := range edits {
+		if edit.DateLocal.Before(first) {
+			first = edit.DateLocal
+		}
+		if edit.DateLocal.After(last) {
+			last = edit.DateLocal
+		}
+	}
+	athleteActivityOpts := swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts{
+		PerPage: optional.NewInt32(100),
+		After:   optional.NewInt32(int32(first.Add(-24 * time.Hour).Unix())),
+		Before:  optional.NewInt32(int32(last.Add(48 * time.Hour).Unix())),
+	}
+
+	activities := map[int64]models.Activity{}
+	rateLimitReached, fetchErrors := fetchActivities(c, athleteActivityOpts, fetchOptions{}, func(page []models.Activity) error {
+		for _, activity := range page {
+			activities[activity.Id] = activity
+		}
+		return nil
+	})
+	if len(fetchErrors) > 0 || rateLimitReached {
+		// Log all errors
+		for _, err := range fetchErrors {
+			logger.Error(err.Error())
+		}
+
+		// Check if rate limit reached
+		if rateLimitReached {
+			c.Redirect(http.StatusFound, "/rate-limit")
+		} else {
+			utils.ReturnErrorPage(c)
+		}
+
+		return
+	}
+
+	// Get gear (names in the workbook are converted to ids)
+	gear, rateLimitReached, err := getAthleteGear(c)
+	if rateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Compare workbook and activities
+	pending := pendingEdits{Uploaded: time.Now(), Updates: []models.ActivityUpdate{}, Errors: errors}
+	for _, edit := range edits {
+		activity, exists := activities[edit.Id]
+		if !exists {
+			pending.Errors = append(pending.Errors, fmt.Sprintf("Zeile %d: Aktivität %d wurde nicht gefunden", edit.Row, edit.Id))
+			continue
+		}
+
+		update, err := getActivityUpdate(activity, edit, gear)
+		if err != nil {
+			pending.Errors = append(pending.Errors, fmt.Sprintf("Zeile %d: %s", edit.Row, err.Error()))
+			continue
+		}
+		if len(update.Changes) > 0 {
+			pending.Updates = append(pending.Updates, update)
+		}
+	}
+
+	if err := userdata.Save(athleteID, EDITFILE, pending); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/edit")
+}
+
+// ApplyEdits updates the activities of the pending changes one after another in the background,
+// changes that could not be applied because of the rate limit are kept, so they can be applied later
+func ApplyEdits(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	if job := getEditJob(athleteID); job.Total > 0 && !job.Done {
+		c.Redirect(http.StatusFound, "/edit?error=running")
+		return
+	}
+
+	pending := pendingEdits{}
+	if err := userdata.Load(athleteID, EDITFILE, &pending); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	if len(pending.Updates) == 0 {
+		c.Redirect(http.StatusFound, "/edit")
+		return
+	}
+
+	client, auth, err := getAPIClient(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	job := &editJob{Total: len(pending.Updates)}
+	editJobsMutex.Lock()
+	editJobs[athleteID] = job
+	editJobsMutex.Unlock()
+
+	go func() {
+		if err := applyEdits(athleteID, client, auth, pending, job); err != nil {
+			logger.Error(fmt.Sprintf("failed to apply edits of athlete %d: %s", athleteID, err.Error()))
+		}
+		editJobsMutex.Lock()
+		job.Done = true
+		editJobsMutex.Unlock()
+	}()
+
+	c.Redirect(http.StatusFound, "/edit")
+}
+
+// applyEdits sends the pending changes to Strava, the audit log and the remaining changes are stored
+// after every update, so no update is lost or sent twice if the server stops
+func applyEdits(athleteID int64, client *swagger.APIClient, auth context.Context, pending pendingEdits, job *editJob) error {
+	entries := []models.AuditEntry{}
+	if err := userdata.Load(athleteID, AUDITFILE, &entries); err != nil {
+		return err
+	}
+
+	for len(pending.Updates) > 0 {
+		update := pending.Updates[0]
+
+		resp, err := updateActivity(client, auth, update)
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			editJobsMutex.Lock()
+			job.RateLimitReached = true
+			editJobsMutex.Unlock()
+			return nil
+		}
+
+		entry := models.AuditEntry{
+			Time:         time.Now(),
+			ActivityId:   update.ActivityId,
+			ActivityName: update.ActivityName,
+			Changes:      update.Changes,
+			Status:       auditStatusApplied,
+		}
+		if err != nil {
+			entry.Status = auditStatusFailed
+			entry.Error = err.Error()
+			logger.Error(err.Error())
+		}
+		entries = append(entries, entry)
+		pending.Updates = pending.Updates[1:]
+
+		// Store audit log and remaining changes
+		if err := userdata.Save(athleteID, AUDITFILE, entries); err != nil {
+			return err
+		}
+		if len(pending.Updates) > 0 {
+			err = userdata.Save(athleteID, EDITFILE, pending)
+		} else {
+			err = userdata.Delete(athleteID, EDITFILE)
+		}
+		if err != nil {
+			return err
+		}
+
+		editJobsMutex.Lock()
+		if entry.Error != "" {
+			job.Failed++
+		} else {
+			job.Applied++
+		}
+		// Stop before the next request fails
+		job.RateLimitReached = isRateLimitExhausted(resp) && len(pending.Updates) > 0
+		editJobsMutex.Unlock()
+		if job.RateLimitReached {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// getEditJob returns a copy of the last run applying the changes of an athlete
+func getEditJob(athleteID int64) editJob {
+	editJobsMutex.Lock()
+	defer editJobsMutex.Unlock()
+
+	job, exists := editJobs[athleteID]
+	if !exists {
+		return editJob{}
+	}
+	return *job
+}
+
+// DiscardEdits removes the pending changes
+func DiscardEdits(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	if job := getEditJob(athleteID); job.Total > 0 && !job.Done {
+		c.Redirect(http.StatusFound, "/edit?error=running")
+		return
+	}
+
+	if err := userdata.Delete(athleteID, EDITFILE); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/edit")
+}
+
+// ExportAuditLog exports the audit log of all applied changes as Excel file
+func ExportAuditLog(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	entries := []models.AuditEntry{}
+	if err := userdata.Load(athleteID, AUDITFILE, &entries); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	f := excelize.NewFile()
+	f.SetSheetName("Sheet1", AUDITSHEETNAME)
+
+	if err := addAuditSheet(f, entries); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Set headers to make file downloadable
+	c.Header("Content-Disposition", "attachment;filename=strava-edit-log.xlsx")
+	c.Header("File-Name", "strava-edit-log.xlsx")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Expires", "0")
+
+	// Write file to gin's response writer
+	f.Write(c.Writer)
+}
+
+// activityEdit is a row of an uploaded edit workbook
+type activityEdit struct {
+	Row       int
+	Id        int64
+	DateLocal time.Time
+	Name      string
+	Type      string
+	Gear      string
+	Commute   string
+	Trainer   string
+}
+
+// parseEditRows converts the rows of the edit sheet (raw values, without header), empty rows are
+// skipped
+func parseEditRows(rows [][]string) ([]activityEdit, []string) {
+	edits := []activityEdit{}
+	errors := []string{}
+
+	for i, row := range rows {
+		// Fill missing cells
+		for len(row) < len(editColumns) {
+			row = append(row, "")
+		}
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		edit := activityEdit{
+			Row:     i + 2,
+			Name:    strings.TrimSpace(row[2]),
+			Type:    strings.TrimSpace(row[3]),
+			Gear:    strings.TrimSpace(row[4]),
+			Commute: strings.TrimSpace(row[5]),
+			Trainer: strings.TrimSpace(row[6]),
+		}
+
+		// Large numbers may be stored in scientific notation
+		id, err := strconv.ParseFloat(strings.TrimSpace(row[0]), 64)
+		if err != nil || id <= 0 {
+			errors = append(errors, fmt.Sprintf("Zeile %d: ungültige ID %q", edit.Row, row[0]))
+			continue
+		}
+		edit.Id = int64(id)
+
+		date, err := strconv.ParseFloat(strings.TrimSpace(row[1]), 64)
+		if err == nil {
+			edit.DateLocal, err = excelize.ExcelDateToTime(date, false)
+		}
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("Zeile %d: ungültiges Datum %q", edit.Row, row[1]))
+			continue
+		}
+
+		edits = append(edits, edit)
+	}
+
+	return edits, errors
+}
+
+// getActivityUpdate returns the changed fields of an activity
+func getActivityUpdate(activity models.Activity, edit activityEdit, gear []models.Gear) (models.ActivityUpdate, error) {
+	update := models.ActivityUpdate{
+		ActivityId:   activity.Id,
+		ActivityName: activity.Name,
+		Changes:      []models.FieldChange{},
+		Commute:      activity.Commute,
+		Trainer:      activity.Trainer,
+	}
+
+	// Name (can't be removed)
+	if edit.Name == "" {
+		return update, fmt.Errorf("der Name darf nicht leer sein")
+	}
+	if edit.Name != activity.Name {
+		update.Changes = append(update.Changes, models.FieldChange{
+			Field: "name", Label: "Name", OldValue: activity.Name, NewValue: edit.Name, Value: edit.Name,
+		})
+	}
+
+	// Sport type
+	if edit.Type != activity.Type {
+		valid := false
+		for _, activityType := range activityTypes {
+			if strings.EqualFold(edit.Type, string(activityType)) {
+				edit.Type = string(activityType)
+				valid = true
+			}
+		}
+		if !valid {
+			return update, fmt.Errorf("unbekannte Sportart %q", edit.Type)
+		}
+		if edit.Type != activity.Type {
+			update.Changes = append(update.Changes, models.FieldChange{
+				Field: "type", Label: "Sportart", OldValue: activity.Type, NewValue: edit.Type, Value: edit.Type,
+			})
+		}
+	}
+
+	// Gear (name or id, "none" removes the gear)
+	oldGear, newGear := activity.GearId, ""
+	oldGearName, newGearName := activity.GearId, edit.Gear
+	for _, g := range gear {
+		if g.Id == activity.GearId {
+			oldGearName = g.Name
+		}
+		if strings.EqualFold(g.Name, edit.Gear) || g.Id == edit.Gear {
+			newGear, newGearName = g.Id, g.Name
+		}
+	}
+	switch {
+	case edit.Gear == "" && oldGear != "":
+		newGear = "none"
+	case edit.Gear == "" || edit.Gear == oldGear || edit.Gear == oldGearName:
+		newGear = oldGear
+	case newGear == "":
+		return update, fmt.Errorf("unbekannte Ausrüstung %q", edit.Gear)
+	}
+	if newGear != oldGear {
+		update.Changes = append(update.Changes, models.FieldChange{
+			Field: "gear_id", Label: "Ausrüstung", OldValue: oldGearName, NewValue: newGearName, Value: newGear,
+		})
+	}
+
+	// Commute and trainer flags
+	for _, flag := range []struct {
+		Field, Label, Value string
+		Current             bool
+		Updated             *bool
+	}{
+		{"commute", "Pendeln", edit.Commute, activity.Commute, &update.Commute},
+		{"trainer", "Trainer", edit.Trainer, activity.Trainer, &update.Trainer},
+	} {
+		var value bool
+		switch {
+		case strings.EqualFold(flag.Value, editYes):
+			value = true
+		case strings.EqualFold(flag.Value, editNo) || flag.Value == "":
+			value = false
+		default:
+			return update, fmt.Errorf("ungültiger Wert %q für %s (%s/%s)", flag.Value, flag.Label, editYes, editNo)
+		}
+
+		*flag.Updated = value
+		if value != flag.Current {
+			update.Changes = append(update.Changes, models.FieldChange{
+				Field:    flag.Field,
+				Label:    flag.Label,
+				OldValue: formatEditBool(flag.Current),
+				NewValue: formatEditBool(value),
+				Value:    strconv.FormatBool(value),
+			})
+		}
+	}
+
+	return update, nil
+}
+
+// updateActivity sends the changed fields of an activity to Strava (flags are always sent)
+func updateActivity(client *swagger.APIClient, auth context.Context, update models.ActivityUpdate) (*http.Response, error) {
+	body := swagger.UpdatableActivity{Commute: update.Commute, Trainer: update.Trainer}
+	for _, change := range update.Changes {
+		switch change.Field {
+		case "name":
+			body.Name = change.Value
+		case "type":
+			activityType := swagger.ActivityType(change.Value)
+			body.Type_ = &activityType
+		case "gear_id":
+			body.GearId = change.Value
+		}
+	}
+
+	// The response can't be decoded (coordinates are not supported), so only the status is checked
+	_, resp, err := client.ActivitiesApi.UpdateActivityById(auth, update.ActivityId, &swagger.ActivitiesApiUpdateActivityByIdOpts{
+		Body: optional.NewInterface(body),
+	})
+	if resp == nil {
+		return nil, err
+	} else if resp.StatusCode != http.StatusOK {
+		return resp, fmt.Errorf("failed to update activity %d: %s", update.ActivityId, getSwaggerErrorMessage(err, resp))
+	}
+	return resp, nil
+}
+
+// getSwaggerErrorMessage returns the message of a failed request of the swagger client including the
+// response body
+func getSwaggerErrorMessage(err error, resp *http.Response) string {
+	if swaggerErr, ok := err.(swagger.GenericSwaggerError); ok && len(swaggerErr.Body()) > 0 {
+		return fmt.Sprintf("%s: %s", swaggerErr.Error(), strings.TrimSpace(string(swaggerErr.Body())))
+	} else if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+// isRateLimitExhausted checks the rate limit headers of a response (e.g. "X-RateLimit-Usage: 100,250"
+// and "X-RateLimit-Limit: 100,1000"), true if one of the limits is reached
+func isRateLimitExhausted(resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+
+	limits := strings.Split(resp.Header.Get("X-RateLimit-Limit"), ",")
+	usages := strings.Split(resp.Header.Get("X-RateLimit-Usage"), ",")
+	for i := 0; i < len(limits) && i < len(usages); i++ {
+		limit, err := strconv.Atoi(strings.TrimSpace(limits[i]))
+		if err != nil {
+			continue
+		}
+		usage, err := strconv.Atoi(strings.TrimSpace(usages[i]))
+		if err != nil {
+			continue
+		}
+		if usage >= limit {
+			return true
+		}
+	}
+	return false
+}
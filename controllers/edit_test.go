@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aschbacd/strava-export/models"
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+	"github.com/aschbacd/strava-export/pkg/userdata"
+	"golang.org/x/oauth2"
+)
+
+// fakeActivityUpdates records the bodies of activity updates, the rate limit is exhausted by the
+// request with the given number
+type fakeActivityUpdates struct {
+	bodies      []map[string]interface{}
+	exhaustedAt int
+}
+
+// RoundTrip records the update and returns the rate limit headers
+func (f *fakeActivityUpdates) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := map[string]interface{}{}
+	json.NewDecoder(req.Body).Decode(&body)
+	f.bodies = append(f.bodies, body)
+
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set("X-RateLimit-Limit", "100,1000")
+	if len(f.bodies) == f.exhaustedAt {
+		recorder.Header().Set("X-RateLimit-Usage", "100,100")
+	} else {
+		recorder.Header().Set("X-RateLimit-Usage", "1,1")
+	}
+	recorder.WriteString("{}")
+	return recorder.Result(), nil
+}
+
+func TestApplyEdits(t *testing.T) {
+	t.Setenv("DATA_DIR", t.TempDir())
+	strava := &fakeActivityUpdates{exhaustedAt: 2}
+	transport := http.DefaultTransport
+	http.DefaultTransport = strava
+	t.Cleanup(func() { http.DefaultTransport = transport })
+
+	client := swagger.NewAPIClient(swagger.NewConfiguration())
+	auth := context.WithValue(context.Background(), swagger.ContextOAuth2, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "access"}))
+
+	pending := pendingEdits{Updates: []models.ActivityUpdate{
+		{ActivityId: 1, Changes: []models.FieldChange{{Field: "name", Value: "Morning Run"}}, Commute: true},
+		{ActivityId: 2, Changes: []models.FieldChange{{Field: "trainer", Value: "false"}}},
+		{ActivityId: 3, Changes: []models.FieldChange{{Field: "gear_id", Value: "none"}}},
+	}}
+	if err := userdata.Save(testAthleteID, EDITFILE, pending); err != nil {
+		t.Fatal(err)
+	}
+
+	job := &editJob{Total: len(pending.Updates)}
+	if err := applyEdits(testAthleteID, client, auth, pending, job); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flags are always sent, so they can be removed
+	if len(strava.bodies) != 2 {
+		t.Fatalf("expected 2 updates before the rate limit, got %d", len(strava.bodies))
+	}
+	if strava.bodies[0]["name"] != "Morning Run" || strava.bodies[0]["commute"] != true || strava.bodies[0]["trainer"] != false {
+		t.Errorf("unexpected body %v", strava.bodies[0])
+	}
+	if value, exists := strava.bodies[1]["trainer"]; !exists || value != false {
+		t.Errorf("trainer flag not removed: %v", strava.bodies[1])
+	}
+
+	if job.Applied != 2 || !job.RateLimitReached {
+		t.Errorf("unexpected job %+v", *job)
+	}
+
+	// Audit log and remaining changes are stored
+	entries := []models.AuditEntry{}
+	if err := userdata.Load(testAthleteID, AUDITFILE, &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].ActivityId != 2 || entries[1].Status != auditStatusApplied {
+		t.Errorf("unexpected audit log %v", entries)
+	}
+	remaining := pendingEdits{}
+	if err := userdata.Load(testAthleteID, EDITFILE, &remaining); err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining.Updates) != 1 || remaining.Updates[0].ActivityId != 3 {
+		t.Errorf("unexpected remaining changes %v", remaining.Updates)
+	}
+}
@@ -1,12 +1,14 @@
 package controllers
 
 import (
+	"bytes"
 	"fmt"
 	"net/http"
-	"sort"
+	"strings"
 	"time"
 
 	"github.com/antihax/optional"
+	"github.com/aschbacd/strava-export/models"
 	"github.com/aschbacd/strava-export/pkg/logger"
 	swagger "github.com/aschbacd/strava-export/pkg/strava"
 	"github.com/aschbacd/strava-export/pkg/utils"
@@ -16,260 +18,551 @@ import (
 
 var (
 	SHEETNAME = "Strava-Export"
+	TABLENAME = "Aktivitaeten"
+
+	// Columns of the activities sheet (values are returned by getActivityValues)
+	activityColumns = []interface{}{
+		"Datum",
+		"Name",
+		"Strecke",
+		"Zeit",
+		"Höhenzunahme",
+		"Kalorien",
+		"Ø Geschwindigkeit",
+		"Max. Geschwindigkeit",
+		"Ø Trittfrequenz",
+		"Ø Herzfrequenz",
+		"Max. Herzfrequenz",
+		"Ø Watt",
+		"Max. Watt",
+		"Fahrrad",
+		"Sportart",
+	}
 )
 
 // ExportData exports an Excel report
 func ExportData(c *gin.Context) {
+	f, err := createExport(c)
+	if err == errRateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Set headers to make file downloadable
+	c.Header("Content-Disposition", "attachment;filename=strava-export.xlsx")
+	c.Header("File-Name", "strava-export.xlsx")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Expires", "0")
+
+	// Write file to gin's response writer
+	f.Write(c.Writer)
+}
+
+// deliverExport creates the Excel report for the options sent as form and passes it to a delivery
+// (e.g. email), the export is added to the run history and the athlete is redirected to the
+// activities page
+func deliverExport(c *gin.Context, athleteID int64, delivery string, deliver func(run models.ScheduleRun, data []byte) error) {
+	// Export reads its options from the query
+	if err := c.Request.ParseForm(); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	query := c.Request.PostForm
+	c.Request.URL.RawQuery = query.Encode()
+
+	f, err := createExport(c)
+	if err == errRateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	var buffer bytes.Buffer
+	if err := f.Write(&buffer); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Manual exports are added to the history, so they can be downloaded later (e.g. if sent as link)
+	run := models.ScheduleRun{
+		Id:           utils.GetRandomString(16),
+		ScheduleName: MANUALEXPORTNAME,
+		Started:      time.Now(),
+		Status:       models.ScheduleRunSucceeded,
+	}
+	run.From, _ = time.Parse("2006-01-02", query.Get("from"))
+	run.To, _ = time.Parse("2006-01-02", query.Get("to"))
+
+	err = storeExportRun(athleteID, &run, buffer.Bytes())
+	if err == nil {
+		err = deliver(run, buffer.Bytes())
+	}
+	run.Finished = time.Now()
+	if err != nil {
+		run.Status = models.ScheduleRunFailed
+		run.Error = getRunErrorMessage(err)
+		// Failure is shown on the error page
+		run.Seen = true
+	}
+
+	if saveErr := saveScheduleRun(athleteID, "", run, time.Time{}); saveErr != nil {
+		logger.Error(saveErr.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	query.Set("delivered", delivery)
+	c.Redirect(http.StatusFound, "/?"+query.Encode())
+}
+
+// createExport creates the Excel report for the options given as query parameters (date range,
+// laps, splits, zones, charts, commute, template and units)
+func createExport(c *gin.Context) (*excelize.File, error) {
 	// Create activities api config
 	athleteActivityOpts := swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts{
-		PerPage: optional.NewInt32(60),
+		PerPage: optional.NewInt32(100),
 	}
 
 	// Set timestamps for activities api config
 	if err := setAthleteActivitiesOpts(c, &athleteActivityOpts); err != nil {
-		logger.Error(err.Error())
-		utils.ReturnErrorPage(c)
-		return
+		return nil, err
 	}
 
-	// Get activities (detailed)
-	activities, rateLimitReached, errors := getActivities(c, athleteActivityOpts, true)
-	if len(errors) > 0 || rateLimitReached {
-		// Log all errors
-		for _, err := range errors {
-			logger.Error(err.Error())
+	// Create Excel file (from template if requested)
+	var f *excelize.File
+	var writer activitiesWriter
+	var stats *exportStats
+	useTemplate := c.Query("template") != ""
+
+	if useTemplate {
+		athleteID, err := getContextAthleteID(c)
+		if err != nil {
+			return nil, err
 		}
 
-		// Check if rate limit reached
-		if rateLimitReached {
-			c.Redirect(http.StatusFound, "/rate-limit")
-		} else {
-			utils.ReturnErrorPage(c)
+		f, err = openTemplate(athleteID)
+		if err != nil {
+			return nil, err
 		}
 
-		return
+		sheet, err := newTemplateSheet(f)
+		if err != nil {
+			return nil, err
+		}
+		writer, stats = sheet, sheet.Stats
+	} else {
+		f = excelize.NewFile()
+		f.SetSheetName("Sheet1", SHEETNAME)
+
+		sheet, err := newActivitiesSheet(f)
+		if err != nil {
+			return nil, err
+		}
+		writer, stats = sheet, sheet.Stats
 	}
 
-	// Sort activities
-	sort.Slice(activities, func(i, j int) bool {
-		return activities[i].Date.Unix() < activities[j].Date.Unix()
-	})
+	// Add laps sheet (optional)
+	options := fetchOptions{Details: true, Laps: c.Query("laps") != "", Zones: c.Query("zones") != ""}
+	units := models.GetUnits(c.Query("units"))
 
-	// Create Excel file
-	f := excelize.NewFile()
-	f.SetSheetName("Sheet1", SHEETNAME)
+	if options.Laps {
+		laps, err := newLapsSheet(f, units)
+		if err != nil {
+			return nil, err
+		}
+		writer = activitiesWriters{writer, laps}
+	}
 
-	// Set column widths
-	for col, width := range map[string]float64{
-		"A": 14, "B": 50, "C": 9, "D": 10, "E": 14, "F": 9, "G": 16, "H": 19, "I": 13, "J": 13, "K": 16, "L": 7, "M": 10, "N": 15} {
-		if err := f.SetColWidth(SHEETNAME, col, col, width); err != nil {
+	// Add splits sheets (optional)
+	if c.Query("splits") != "" {
+		splits, err := newSplitsSheet(f, units)
+		if err != nil {
+			return nil, err
+		}
+		writer = activitiesWriters{writer, splits}
+	}
+
+	// Add zones sheet (optional)
+	if options.Zones {
+		athleteZones, err := getAthleteZones(c)
+		if err != nil {
+			return nil, err
+		}
+		writer = activitiesWriters{writer, newZonesSheet(f, athleteZones)}
+	}
+
+	// Only export commutes (optional)
+	handler := writer.AddActivities
+	if c.Query("commute") != "" {
+		handler = func(activities []models.Activity) error {
+			commutes := []models.Activity{}
+			for _, activity := range activities {
+				if activity.Commute {
+					commutes = append(commutes, activity)
+				}
+			}
+			return writer.AddActivities(commutes)
+		}
+	}
+
+	// Get activities (detailed) and write them page by page
+	rateLimitReached, errors := fetchActivities(c, athleteActivityOpts, options, handler)
+	if rateLimitReached {
+		return nil, errRateLimitReached
+	} else if len(errors) > 0 {
+		// Log all errors, the first one is returned
+		for _, err := range errors[1:] {
 			logger.Error(err.Error())
-			utils.ReturnErrorPage(c)
-			return
 		}
+		return nil, errors[0]
 	}
 
-	// Define styles
-	borderStyle := []excelize.Border{
-		{
-			Type:  "top",
-			Color: "#000000",
-			Style: 1,
-		},
-		{
-			Type:  "right",
-			Color: "#000000",
-			Style: 1,
-		},
-		{
-			Type:  "bottom",
-			Color: "#000000",
-			Style: 1,
-		},
-		{
-			Type:  "left",
-			Color: "#000000",
-			Style: 1,
-		},
+	// Finish activities (e.g. create table with totals row)
+	if err := writer.Close(); err != nil {
+		return nil, err
 	}
 
-	// Format title
-	if err := f.MergeCell(SHEETNAME, "A1", "N1"); err != nil {
-		logger.Error(err.Error())
-		utils.ReturnErrorPage(c)
-		return
+	// Summary and charts reference the generated activities table
+	if !useTemplate {
+		// Add summary sheets
+		if err := addSummarySheets(f, stats); err != nil {
+			return nil, err
+		}
+
+		// Add charts sheet (optional)
+		if c.Query("charts") != "" && stats.Count > 0 {
+			if err := addPreviousYearDistances(c, stats, c.Query("commute") != ""); err != nil {
+				return nil, err
+			}
+			if err := addChartsSheet(f, stats); err != nil {
+				return nil, err
+			}
+		}
 	}
-	if err := f.SetRowHeight(SHEETNAME, 1, 30); err != nil {
-		logger.Error(err.Error())
-		utils.ReturnErrorPage(c)
-		return
+
+	return f, nil
+}
+
+// activitiesWriter writes activities to a workbook
+type activitiesWriter interface {
+	AddActivities(activities []models.Activity) error
+	Close() error
+}
+
+// activitiesSheet writes activities to the main sheet using a stream writer, only aggregated values
+// are kept in memory
+type activitiesSheet struct {
+	Stats *exportStats
+
+	f              *excelize.File
+	sw             *excelize.StreamWriter
+	row            int
+	dateStyle      int
+	durationStyle  int
+	totalsStyle    int
+	totalsDuration int
+}
+
+// newActivitiesSheet creates a stream writer for the main sheet and writes title and header
+func newActivitiesSheet(f *excelize.File) (*activitiesSheet, error) {
+	// Freeze title and header
+	if err := f.SetPanes(SHEETNAME, `{
+		"freeze": true,
+		"split": false,
+		"x_split": 0,
+		"y_split": 2,
+		"top_left_cell": "A3",
+		"active_pane": "bottomLeft"
+	}`); err != nil {
+		return nil, err
 	}
-	titleStyle, err := f.NewStyle(&excelize.Style{
-		Alignment: &excelize.Alignment{
-			Horizontal: "center",
-			Vertical:   "center",
-		},
-		Font: &excelize.Font{
-			Bold: true,
-			Size: 15,
-		},
-		Border: borderStyle,
-	})
+
+	sw, err := f.NewStreamWriter(SHEETNAME)
 	if err != nil {
-		logger.Error(err.Error())
-		utils.ReturnErrorPage(c)
-		return
+		return nil, err
 	}
-	if err := f.SetCellStyle(SHEETNAME, "A1", "N1", titleStyle); err != nil {
-		logger.Error(err.Error())
-		utils.ReturnErrorPage(c)
-		return
+
+	// Set column widths
+	for i, width := range []float64{14, 50, 9, 10, 14, 9, 16, 19, 13, 13, 16, 7, 10, 15, 14} {
+		if err := sw.SetColWidth(i+1, i+1, width); err != nil {
+			return nil, err
+		}
 	}
 
-	// Format header
-	headerStyle, err := f.NewStyle(&excelize.Style{
+	// Define styles
+	titleStyle, err := f.NewStyle(&excelize.Style{
 		Alignment: &excelize.Alignment{
 			Horizontal: "center",
 			Vertical:   "center",
 		},
 		Font: &excelize.Font{
 			Bold: true,
+			Size: 15,
 		},
-		Border: borderStyle,
 	})
 	if err != nil {
-		logger.Error(err.Error())
-		utils.ReturnErrorPage(c)
-		return
+		return nil, err
 	}
-	if err := f.SetCellStyle(SHEETNAME, "A2", "N2", headerStyle); err != nil {
-		logger.Error(err.Error())
-		utils.ReturnErrorPage(c)
-		return
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 22})
+	if err != nil {
+		return nil, err
 	}
-
-	// Format cells
-	cellStyle, err := f.NewStyle(&excelize.Style{
-		Alignment: &excelize.Alignment{
-			WrapText: true,
-		},
-		Border: borderStyle,
-	})
+	durationStyle, err := f.NewStyle(&excelize.Style{NumFmt: 21})
 	if err != nil {
-		logger.Error(err.Error())
-		utils.ReturnErrorPage(c)
-		return
+		return nil, err
 	}
-	dateStyle, err := f.NewStyle(&excelize.Style{
-		Alignment: &excelize.Alignment{
-			WrapText: true,
-		},
-		Border: borderStyle,
-		NumFmt: 22,
-	})
+	border := []excelize.Border{{Type: "top", Color: "#000000", Style: 6}}
+	totalsStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}, Border: border, NumFmt: 2})
 	if err != nil {
-		logger.Error(err.Error())
-		utils.ReturnErrorPage(c)
-		return
+		return nil, err
 	}
-	durationStyle, err := f.NewStyle(&excelize.Style{
-		Alignment: &excelize.Alignment{
-			WrapText: true,
-		},
-		Border: borderStyle,
-		NumFmt: 21,
-	})
+	totalsDuration, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}, Border: border, NumFmt: 46})
 	if err != nil {
-		logger.Error(err.Error())
-		utils.ReturnErrorPage(c)
-		return
+		return nil, err
 	}
-	f.SetCellStyle(SHEETNAME, "A3", "A"+fmt.Sprint(len(activities)+2), dateStyle)
-	f.SetCellStyle(SHEETNAME, "B3", "C"+fmt.Sprint(len(activities)+2), cellStyle)
-	f.SetCellStyle(SHEETNAME, "D3", "D"+fmt.Sprint(len(activities)+2), durationStyle)
-	f.SetCellStyle(SHEETNAME, "E3", "N"+fmt.Sprint(len(activities)+2), cellStyle)
 
-	// Set title
-	var title string
-	firstDate := activities[0].DateLocal
-	lastDate := activities[len(activities)-1].DateLocal
-
-	if firstDate.Year() == lastDate.Year() && firstDate.Month() == lastDate.Month() {
-		// If same year and same month -> Month - Year
-		title = formatMonth(firstDate.Month()) + " - " + fmt.Sprint(firstDate.Year())
-	} else if firstDate.Year() == lastDate.Year() {
-		// If same year but different month -> Month1 - Month2 (Year)
-		title = formatMonth(firstDate.Month()) + " - " + formatMonth(lastDate.Month()) + " (" + fmt.Sprint(firstDate.Year()) + ")"
-	} else {
-		// If different year and different month -> Month1 (Year1) - Month2 (Year2)
-		title = formatMonth(firstDate.Month()) + " (" + fmt.Sprint(firstDate.Year()) + ") - " + formatMonth(lastDate.Month()) + " (" + fmt.Sprint(lastDate.Year()) + ")"
+	// Set title (calculated from the activities because rows can't be changed after streaming)
+	if err := sw.SetRow("A1", []interface{}{
+		excelize.Cell{StyleID: titleStyle, Formula: getTitleFormula()},
+	}, excelize.RowOpts{Height: 30}); err != nil {
+		return nil, err
 	}
-
-	if err := f.SetCellValue(SHEETNAME, "A1", title); err != nil {
-		logger.Error(err.Error())
-		utils.ReturnErrorPage(c)
-		return
+	if err := sw.MergeCell("A1", "O1"); err != nil {
+		return nil, err
 	}
 
 	// Set Header (row 2)
-	if err := setExcelValues(f, 2, []interface{}{
-		"Datum",
-		"Name",
-		"Strecke",
-		"Zeit",
-		"Höhenzunahme",
-		"Kalorien",
-		"Ø Geschwindigkeit",
-		"Max. Geschwindigkeit",
-		"Ø Trittfrequenz",
-		"Ø Herzfrequenz",
-		"Max. Herzfrequenz",
-		"Ø Watt",
-		"Max. Watt",
-		"Fahrrad",
-	}); err != nil {
-		logger.Error(err.Error())
-		utils.ReturnErrorPage(c)
-		return
+	if err := sw.SetRow("A2", activityColumns); err != nil {
+		return nil, err
 	}
 
-	// Add activities to Excel file
-	for i, activity := range activities {
+	return &activitiesSheet{
+		Stats:          newExportStats(),
+		f:              f,
+		sw:             sw,
+		row:            3,
+		dateStyle:      dateStyle,
+		durationStyle:  durationStyle,
+		totalsStyle:    totalsStyle,
+		totalsDuration: totalsDuration,
+	}, nil
+}
+
+// AddActivities writes activities to the main sheet (rows must be passed in ascending order)
+func (s *activitiesSheet) AddActivities(activities []models.Activity) error {
+	for _, activity := range activities {
+		// Format date and duration
+		values := getActivityValues(activity)
+		values[0] = excelize.Cell{StyleID: s.dateStyle, Value: values[0]}
+		values[3] = excelize.Cell{StyleID: s.durationStyle, Value: values[3]}
+
 		// Set values (row 3+)
-		if err := setExcelValues(f, i+3, []interface{}{
-			activity.DateLocal,
-			activity.Name,
-			activity.Distance,
-			activity.Duration,
-			activity.ElevationGain,
-			activity.Calories,
-			activity.AverageSpeed,
-			activity.MaxSpeed,
-			activity.AverageCadence,
-			activity.AverageHeartRate,
-			activity.MaxHeartRate,
-			activity.AverageWatts,
-			activity.MaxWatts,
-			activity.GearName,
-		}); err != nil {
-			logger.Error(err.Error())
-			utils.ReturnErrorPage(c)
-			return
+		if err := s.sw.SetRow("A"+fmt.Sprint(s.row), values); err != nil {
+			return err
 		}
+
+		s.Stats.Add(activity)
+		s.row++
 	}
+	return nil
+}
 
-	// Set headers to make file downloadable
-	c.Header("Content-Disposition", "attachment;filename=strava-export.xlsx")
-	c.Header("File-Name", "strava-export.xlsx")
-	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Expires", "0")
+// Close formats the activities as Excel table and adds a totals row and conditional formatting
+// for heart rate and power
+func (s *activitiesSheet) Close() error {
+	lastRow := s.row - 1
 
-	// Write file to gin's response writer
-	f.Write(c.Writer)
+	// Create table (including autofilter), at least one data row is required
+	if lastRow < 3 {
+		if err := s.sw.SetRow("A3", []interface{}{}); err != nil {
+			return err
+		}
+		lastRow = 3
+	}
+	if err := s.sw.AddTable("A2", "O"+fmt.Sprint(lastRow), `{
+		"table_name": "`+TABLENAME+`",
+		"table_style": "TableStyleMedium2",
+		"show_row_stripes": true
+	}`); err != nil {
+		return err
+	}
+
+	// Totals row (subtotals only include rows matching the autofilter)
+	subtotal := func(function int, column string) excelize.Cell {
+		return excelize.Cell{StyleID: s.totalsStyle, Formula: fmt.Sprintf("SUBTOTAL(%d,%s)", function, getTableColumn(column))}
+	}
+	totalsRow := lastRow + 1
+	totals := []interface{}{
+		excelize.Cell{StyleID: s.totalsStyle},
+		excelize.Cell{StyleID: s.totalsStyle, Value: "Summe"},
+		subtotal(109, "Strecke"),
+		excelize.Cell{StyleID: s.totalsDuration, Formula: fmt.Sprintf("SUBTOTAL(109,%s)", getTableColumn("Zeit"))},
+		subtotal(109, "Höhenzunahme"),
+		subtotal(109, "Kalorien"),
+		subtotal(101, "Ø Geschwindigkeit"),
+		subtotal(104, "Max. Geschwindigkeit"),
+		subtotal(101, "Ø Trittfrequenz"),
+		subtotal(101, "Ø Herzfrequenz"),
+		subtotal(104, "Max. Herzfrequenz"),
+		subtotal(101, "Ø Watt"),
+		subtotal(104, "Max. Watt"),
+		excelize.Cell{StyleID: s.totalsStyle},
+		excelize.Cell{StyleID: s.totalsStyle},
+	}
+	if err := s.sw.SetRow("A"+fmt.Sprint(totalsRow), totals); err != nil {
+		return err
+	}
+
+	// Color scale for heart rate and power (low = green, high = red), the worksheet settings
+	// are written by the stream writer on flush
+	for _, cols := range [][]string{{"J", "K"}, {"L", "M"}} {
+		if err := s.f.SetConditionalFormat(SHEETNAME, fmt.Sprintf("%s3:%s%d", cols[0], cols[1], lastRow), `[{
+			"type": "3_color_scale",
+			"criteria": "=",
+			"min_type": "min",
+			"mid_type": "percentile",
+			"max_type": "max",
+			"min_color": "#63BE7B",
+			"mid_color": "#FFEB84",
+			"max_color": "#F8696B"
+		}]`); err != nil {
+			return err
+		}
+	}
+
+	return s.sw.Flush()
+}
+
+// exportStats contains aggregated values of all exported activities (used for summary and charts)
+type exportStats struct {
+	Count     int
+	FirstDate time.Time
+	LastDate  time.Time
+	// Rows of summary sheets per period
+	SummaryKeys []map[summaryKey]bool
+	// Distance per day [km]
+	DailyDistances map[time.Time]float64
+	// Distance per day of the previous year before the first exported activity [km]
+	PreviousDailyDistances map[time.Time]float64
+}
+
+// newExportStats returns empty export stats
+func newExportStats() *exportStats {
+	stats := exportStats{
+		SummaryKeys:            []map[summaryKey]bool{},
+		DailyDistances:         map[time.Time]float64{},
+		PreviousDailyDistances: map[time.Time]float64{},
+	}
+	for range summaryPeriods {
+		stats.SummaryKeys = append(stats.SummaryKeys, map[summaryKey]bool{})
+	}
+	return &stats
+}
+
+// Add adds an activity to the export stats
+func (s *exportStats) Add(activity models.Activity) {
+	if s.Count == 0 || activity.DateLocal.Before(s.FirstDate) {
+		s.FirstDate = activity.DateLocal
+	}
+	if s.Count == 0 || activity.DateLocal.After(s.LastDate) {
+		s.LastDate = activity.DateLocal
+	}
+	s.Count++
+
+	for i, period := range summaryPeriods {
+		start := period.Start(activity.DateLocal)
+		s.SummaryKeys[i][summaryKey{Start: start, Type: summaryAllTypes}] = true
+		s.SummaryKeys[i][summaryKey{Start: start, Type: activity.Type}] = true
+	}
+
+	s.DailyDistances[getDay(activity.DateLocal)] += activity.Distance
+}
+
+// getDay returns the start of the day of a local date
+func getDay(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// getActivityValues returns the values of an activity in the order of the exported columns
+func getActivityValues(activity models.Activity) []interface{} {
+	return []interface{}{
+		activity.DateLocal,
+		activity.Name,
+		activity.Distance,
+		activity.Duration,
+		activity.ElevationGain,
+		activity.Calories,
+		activity.AverageSpeed,
+		activity.MaxSpeed,
+		activity.AverageCadence,
+		activity.AverageHeartRate,
+		activity.MaxHeartRate,
+		activity.AverageWatts,
+		activity.MaxWatts,
+		activity.GearName,
+		activity.Type,
+	}
+}
+
+// getTitle returns the title for the given first and last activity date
+func getTitle(firstDate, lastDate time.Time) string {
+	if firstDate.Year() == lastDate.Year() && firstDate.Month() == lastDate.Month() {
+		// If same year and same month -> Month - Year
+		return formatMonth(firstDate.Month()) + " - " + fmt.Sprint(firstDate.Year())
+	} else if firstDate.Year() == lastDate.Year() {
+		// If same year but different month -> Month1 - Month2 (Year)
+		return formatMonth(firstDate.Month()) + " - " + formatMonth(lastDate.Month()) + " (" + fmt.Sprint(firstDate.Year()) + ")"
+	}
+	// If different year and different month -> Month1 (Year1) - Month2 (Year2)
+	return formatMonth(firstDate.Month()) + " (" + fmt.Sprint(firstDate.Year()) + ") - " + formatMonth(lastDate.Month()) + " (" + fmt.Sprint(lastDate.Year()) + ")"
+}
+
+// getTableColumn returns a structured reference to a column of the activities table
+func getTableColumn(column string) string {
+	return fmt.Sprintf("%s[[%s]]", TABLENAME, column)
+}
+
+// getTitleFormula returns a formula calculating the same title as getTitle, e.g. "März - 2021",
+// "März - Mai (2021)" or "Dezember (2020) - Jänner (2021)"
+func getTitleFormula() string {
+	first := fmt.Sprintf("MIN(%s)", getTableColumn("Datum"))
+	last := fmt.Sprintf("MAX(%s)", getTableColumn("Datum"))
+
+	// Month names
+	months := []string{}
+	for month := time.January; month <= time.December; month++ {
+		months = append(months, `"`+formatMonth(month)+`"`)
+	}
+	month := func(date string) string {
+		return fmt.Sprintf("CHOOSE(MONTH(%s),%s)", date, strings.Join(months, ","))
+	}
+	year := func(date string) string {
+		return fmt.Sprintf("YEAR(%s)", date)
+	}
+
+	return fmt.Sprintf(`IF(COUNT(%s)=0,"",IF(AND(%s=%s,MONTH(%s)=MONTH(%s)),%s&" - "&%s,IF(%s=%s,%s&" - "&%s&" ("&%s&")",%s&" ("&%s&") - "&%s&" ("&%s&")")))`,
+		getTableColumn("Datum"),
+		year(first), year(last), first, last, month(first), year(first),
+		year(first), year(last), month(first), month(last), year(first),
+		month(first), year(first), month(last), year(last))
 }
 
 // setExcelValues sets the values for a given row
-func setExcelValues(f *excelize.File, row int, items []interface{}) error {
+func setExcelValues(f *excelize.File, sheet string, row int, items []interface{}) error {
 	for i, item := range items {
 		// Get cell names (e.g. A2, B7, C8, ...)
 		axis, err := excelize.CoordinatesToCellName(i+1, row)
@@ -277,14 +570,51 @@ func setExcelValues(f *excelize.File, row int, items []interface{}) error {
 			return err
 		}
 
+		// Set formula
+		if formula, ok := item.(excelFormula); ok {
+			if err := f.SetCellFormula(sheet, axis, string(formula)); err != nil {
+				return err
+			}
+			continue
+		}
+
 		// Set value
-		if err := f.SetCellValue(SHEETNAME, axis, item); err != nil {
+		if err := f.SetCellValue(sheet, axis, item); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// newBorderStyle creates a style with a thin border around the cell and a number format (0 = general)
+func newBorderStyle(f *excelize.File, numFmt int) (int, error) {
+	return f.NewStyle(&excelize.Style{Border: getThinBorder(), NumFmt: numFmt})
+}
+
+// newBorderHeaderStyle creates a bold and centered style with a thin border for header cells
+func newBorderHeaderStyle(f *excelize.File) (int, error) {
+	return f.NewStyle(&excelize.Style{
+		Alignment: &excelize.Alignment{
+			Horizontal: "center",
+			Vertical:   "center",
+		},
+		Font: &excelize.Font{
+			Bold: true,
+		},
+		Border: getThinBorder(),
+	})
+}
+
+// getThinBorder returns a thin black border on all sides of a cell
+func getThinBorder() []excelize.Border {
+	return []excelize.Border{
+		{Type: "top", Color: "#000000", Style: 1},
+		{Type: "right", Color: "#000000", Style: 1},
+		{Type: "bottom", Color: "#000000", Style: 1},
+		{Type: "left", Color: "#000000", Style: 1},
+	}
+}
+
 // formatMonth returns a month name for a given int
 func formatMonth(month time.Month) string {
 	months := []string{
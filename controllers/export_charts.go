@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/antihax/optional"
+	"github.com/aschbacd/strava-export/models"
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+var (
+	CHARTSHEETNAME = "Diagramme"
+
+	// First day of a leap year used to align days of different years
+	calendarStart = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// addChartsSheet adds a sheet with charts for distance per week, cumulative distance compared to
+// the previous year and elevation gain compared to distance, the aggregated chart data is stored on
+// the same sheet next to the charts
+func addChartsSheet(f *excelize.File, stats *exportStats) error {
+	f.NewSheet(CHARTSHEETNAME)
+
+	// Set column widths for chart data
+	for col, width := range map[string]float64{"K": 12, "L": 10, "N": 8, "O": 12, "P": 12} {
+		if err := f.SetColWidth(CHARTSHEETNAME, col, col, width); err != nil {
+			return err
+		}
+	}
+
+	// Distance per week (K:L)
+	week := summaryPeriods[0]
+	weeks := []string{}
+	distances := map[string]float64{}
+	firstWeek := week.Start(stats.FirstDate)
+	lastWeek := week.Start(stats.LastDate)
+	for start := firstWeek; !start.After(lastWeek); start = start.AddDate(0, 0, 7) {
+		weeks = append(weeks, week.Label(start))
+	}
+	for day, distance := range stats.DailyDistances {
+		distances[week.Label(week.Start(day))] += distance
+	}
+
+	if err := f.SetSheetRow(CHARTSHEETNAME, "K1", &[]interface{}{"Woche", "Strecke"}); err != nil {
+		return err
+	}
+	for i, label := range weeks {
+		if err := f.SetSheetRow(CHARTSHEETNAME, "K"+fmt.Sprint(i+2), &[]interface{}{label, math.Round(distances[label]*100) / 100}); err != nil {
+			return err
+		}
+	}
+
+	// Cumulative distance of the last year and the year before (N:P), days are aligned by month and
+	// day (29th of February is empty in other years)
+	year := stats.LastDate.Year()
+	cumulative := map[int][]float64{year: make([]float64, 366), year - 1: make([]float64, 366)}
+	for _, dailyDistances := range []map[time.Time]float64{stats.DailyDistances, stats.PreviousDailyDistances} {
+		for day, distance := range dailyDistances {
+			if values, ok := cumulative[day.Year()]; ok {
+				values[getCalendarDay(day)] += distance
+			}
+		}
+	}
+	for _, values := range cumulative {
+		for i := 1; i < len(values); i++ {
+			values[i] += values[i-1]
+		}
+	}
+
+	if err := f.SetSheetRow(CHARTSHEETNAME, "N1", &[]interface{}{"Tag", fmt.Sprint(year), fmt.Sprint(year - 1)}); err != nil {
+		return err
+	}
+	for day := 0; day < 366; day++ {
+		// Leave days after the last activity empty
+		var current interface{}
+		if day <= getCalendarDay(stats.LastDate) {
+			current = math.Round(cumulative[year][day]*100) / 100
+		}
+		label := calendarStart.AddDate(0, 0, day).Format("02.01.")
+		if err := f.SetSheetRow(CHARTSHEETNAME, "N"+fmt.Sprint(day+2), &[]interface{}{label, current, math.Round(cumulative[year-1][day]*100) / 100}); err != nil {
+			return err
+		}
+	}
+
+	// Add charts
+	sheet := "'" + CHARTSHEETNAME + "'!"
+	lastWeekRow := fmt.Sprint(len(weeks) + 1)
+	mainSheet := "'" + SHEETNAME + "'!"
+	lastActivityRow := fmt.Sprint(stats.Count + 2)
+
+	for _, chart := range []struct {
+		Cell   string
+		Format map[string]interface{}
+	}{
+		{"A1", map[string]interface{}{
+			"type": "col",
+			"series": []map[string]interface{}{
+				{
+					"name":       sheet + "$L$1",
+					"categories": sheet + "$K$2:$K$" + lastWeekRow,
+					"values":     sheet + "$L$2:$L$" + lastWeekRow,
+				},
+			},
+			"title":  map[string]interface{}{"name": "Strecke pro Woche [km]"},
+			"legend": map[string]interface{}{"none": true},
+		}},
+		{"A18", map[string]interface{}{
+			"type": "line",
+			"series": []map[string]interface{}{
+				{
+					"name":       sheet + "$O$1",
+					"categories": sheet + "$N$2:$N$367",
+					"values":     sheet + "$O$2:$O$367",
+				},
+				{
+					"name":       sheet + "$P$1",
+					"categories": sheet + "$N$2:$N$367",
+					"values":     sheet + "$P$2:$P$367",
+				},
+			},
+			"title":  map[string]interface{}{"name": "Kumulierte Strecke [km]"},
+			"x_axis": map[string]interface{}{"tick_label_skip": 30},
+		}},
+		{"A35", map[string]interface{}{
+			"type": "scatter",
+			"series": []map[string]interface{}{
+				{
+					"name":       mainSheet + "$E$2",
+					"categories": mainSheet + "$C$3:$C$" + lastActivityRow,
+					"values":     mainSheet + "$E$3:$E$" + lastActivityRow,
+					"marker":     map[string]interface{}{"symbol": "circle", "size": 5},
+					"line":       map[string]interface{}{"none": true},
+				},
+			},
+			"title":  map[string]interface{}{"name": "Höhenzunahme [m] / Strecke [km]"},
+			"legend": map[string]interface{}{"none": true},
+		}},
+	} {
+		// Use the same size for all charts
+		chart.Format["dimension"] = map[string]interface{}{"width": 640, "height": 320}
+
+		formatJSON, err := json.Marshal(chart.Format)
+		if err != nil {
+			return err
+		}
+		if err := f.AddChart(CHARTSHEETNAME, chart.Cell, string(formatJSON)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addPreviousYearDistances fetches the activities of the year before the last exported activity that
+// are not part of the export (before the first exported activity), so the cumulative distance can be
+// compared to the whole previous year
+func addPreviousYearDistances(c *gin.Context, stats *exportStats, commutesOnly bool) error {
+	year := stats.LastDate.Year() - 1
+	start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(year+1, 1, 1, 0, 0, 0, 0, time.UTC)
+	if firstDay := getDay(stats.FirstDate); firstDay.Before(end) {
+		end = firstDay
+	}
+	if !end.After(start) {
+		return nil
+	}
+
+	// Dates are local, so a day is added to the requested range and the activities are filtered
+	athleteActivityOpts := swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts{
+		PerPage: optional.NewInt32(100),
+		After:   optional.NewInt32(int32(start.AddDate(0, 0, -1).Unix())),
+		Before:  optional.NewInt32(int32(end.AddDate(0, 0, 1).Unix())),
+	}
+	rateLimitReached, errors := fetchActivities(c, athleteActivityOpts, fetchOptions{}, func(activities []models.Activity) error {
+		for _, activity := range activities {
+			if activity.DateLocal.Before(start) || !activity.DateLocal.Before(end) || (commutesOnly && !activity.Commute) {
+				continue
+			}
+			stats.PreviousDailyDistances[getDay(activity.DateLocal)] += activity.Distance
+		}
+		return nil
+	})
+	if rateLimitReached {
+		return errRateLimitReached
+	} else if len(errors) > 0 {
+		return errors[0]
+	}
+	return nil
+}
+
+// getCalendarDay returns the index of a day in a leap year (0-365), so the same month and day of
+// different years have the same index
+func getCalendarDay(date time.Time) int {
+	return time.Date(calendarStart.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC).YearDay() - 1
+}
@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetCalendarDay(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		date time.Time
+		day  int
+	}{
+		{date(2023, time.January, 1), 0},
+		{date(2024, time.February, 28), 58},
+		{date(2024, time.February, 29), 59},
+		{date(2023, time.March, 1), 60},
+		{date(2024, time.March, 1), 60},
+		{date(2023, time.December, 31), 365},
+		{date(2024, time.December, 31), 365},
+	}
+	for _, test := range tests {
+		if day := getCalendarDay(test.date); day != test.day {
+			t.Errorf("%s: expected day %d, got %d", test.date.Format("2006-01-02"), test.day, day)
+		}
+	}
+}
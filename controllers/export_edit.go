@@ -0,0 +1,365 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/antihax/optional"
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+var (
+	EDITSHEETNAME     = "Bearbeiten"
+	EDITGEARSHEETNAME = "Ausrüstung"
+	AUDITSHEETNAME    = "Protokoll"
+
+	// Columns of the edit sheet (the id is used to match rows when the workbook is uploaded again)
+	editColumns = []string{"ID", "Datum", "Name", "Sportart", "Ausrüstung", "Pendeln", "Trainer"}
+
+	// Sport types that can be set
+	activityTypes = []swagger.ActivityType{
+		swagger.ALPINE_SKI,
+		swagger.BACKCOUNTRY_SKI,
+		swagger.CANOEING,
+		swagger.CROSSFIT,
+		swagger.E_BIKE_RIDE,
+		swagger.ELLIPTICAL,
+		swagger.GOLF,
+		swagger.HANDCYCLE,
+		swagger.HIKE,
+		swagger.ICE_SKATE,
+		swagger.INLINE_SKATE,
+		swagger.KAYAKING,
+		swagger.KITESURF,
+		swagger.NORDIC_SKI,
+		swagger.RIDE,
+		swagger.ROCK_CLIMBING,
+		swagger.ROLLER_SKI,
+		swagger.ROWING,
+		swagger.RUN,
+		swagger.SAIL,
+		swagger.SKATEBOARD,
+		swagger.SNOWBOARD,
+		swagger.SNOWSHOE,
+		swagger.SOCCER,
+		swagger.STAIR_STEPPER,
+		swagger.STAND_UP_PADDLING,
+		swagger.SURFING,
+		swagger.SWIM,
+		swagger.VELOMOBILE,
+		swagger.VIRTUAL_RIDE,
+		swagger.VIRTUAL_RUN,
+		swagger.WALK,
+		swagger.WEIGHT_TRAINING,
+		swagger.WHEELCHAIR,
+		swagger.WINDSURF,
+		swagger.WORKOUT,
+		swagger.YOGA,
+	}
+
+	// Values of boolean columns
+	editYes = "Ja"
+	editNo  = "Nein"
+)
+
+// ExportEditWorkbook exports the editable fields of all activities in the date range, the workbook
+// can be edited and uploaded on the edit page
+func ExportEditWorkbook(c *gin.Context) {
+	// Create activities api config
+	athleteActivityOpts := swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts{
+		PerPage: optional.NewInt32(100),
+	}
+
+	// Set timestamps for activities api config
+	if err := setAthleteActivitiesOpts(c, &athleteActivityOpts); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Get gear (names are used instead of ids)
+	gear, rateLimitReached, err := getAthleteGear(c)
+	if rateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	gearNames := map[string]string{}
+	for _, g := range gear {
+		gearNames[g.Id] = g.Name
+	}
+
+	// Create Excel file
+	f := excelize.NewFile()
+	f.SetSheetName("Sheet1", EDITSHEETNAME)
+
+	if err := setExcelValues(f, EDITSHEETNAME, 1, toInterfaces(editColumns)); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	row := 2
+	rateLimitReached, errors := fetchActivities(c, athleteActivityOpts, fetchOptions{}, func(activities []models.Activity) error {
+		for _, activity := range activities {
+			gearName := gearNames[activity.GearId]
+			if gearName == "" {
+				// Retired gear is not listed for the athlete
+				gearName = activity.GearId
+			}
+
+			if err := setExcelValues(f, EDITSHEETNAME, row, []interface{}{
+				activity.Id,
+				activity.DateLocal,
+				activity.Name,
+				activity.Type,
+				gearName,
+				formatEditBool(activity.Commute),
+				formatEditBool(activity.Trainer),
+			}); err != nil {
+				return err
+			}
+			row++
+		}
+		return nil
+	})
+	if len(errors) > 0 || rateLimitReached {
+		// Log all errors
+		for _, err := range errors {
+			logger.Error(err.Error())
+		}
+
+		// Check if rate limit reached
+		if rateLimitReached {
+			c.Redirect(http.StatusFound, "/rate-limit")
+		} else {
+			utils.ReturnErrorPage(c)
+		}
+
+		return
+	}
+
+	if err := formatEditWorkbook(f, row-1, gear); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Set headers to make file downloadable
+	c.Header("Content-Disposition", "attachment;filename=strava-edit.xlsx")
+	c.Header("File-Name", "strava-edit.xlsx")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Expires", "0")
+
+	// Write file to gin's response writer
+	f.Write(c.Writer)
+}
+
+// formatEditWorkbook formats the edit sheet and adds a sheet listing all gear as well as drop-down
+// lists for the gear and boolean columns
+func formatEditWorkbook(f *excelize.File, lastRow int, gear []models.Gear) error {
+	// Gear sheet
+	f.NewSheet(EDITGEARSHEETNAME)
+	if err := setExcelValues(f, EDITGEARSHEETNAME, 1, []interface{}{"Name", "ID", "Typ"}); err != nil {
+		return err
+	}
+	for i, g := range gear {
+		if err := setExcelValues(f, EDITGEARSHEETNAME, i+2, []interface{}{g.Name, g.Id, g.GetType()}); err != nil {
+			return err
+		}
+	}
+
+	// Styles
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	idStyle, err := f.NewStyle(&excelize.Style{NumFmt: 1, Font: &excelize.Font{Color: "#808080"}})
+	if err != nil {
+		return err
+	}
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 22, Font: &excelize.Font{Color: "#808080"}})
+	if err != nil {
+		return err
+	}
+
+	for _, style := range []struct {
+		Sheet        string
+		HCell, VCell string
+		StyleID      int
+	}{
+		{EDITSHEETNAME, "A1", "G1", headerStyle},
+		{EDITSHEETNAME, "A2", fmt.Sprintf("A%d", lastRow), idStyle},
+		{EDITSHEETNAME, "B2", fmt.Sprintf("B%d", lastRow), dateStyle},
+		{EDITGEARSHEETNAME, "A1", "C1", headerStyle},
+	} {
+		if err := f.SetCellStyle(style.Sheet, style.HCell, style.VCell, style.StyleID); err != nil {
+			return err
+		}
+	}
+	for i, width := range []float64{14, 16, 50, 16, 30, 10, 10} {
+		col, err := excelize.ColumnNumberToName(i + 1)
+		if err != nil {
+			return err
+		}
+		if err := f.SetColWidth(EDITSHEETNAME, col, col, width); err != nil {
+			return err
+		}
+	}
+	if err := f.SetColWidth(EDITGEARSHEETNAME, "A", "A", 30); err != nil {
+		return err
+	}
+	if err := f.SetColWidth(EDITGEARSHEETNAME, "B", "C", 14); err != nil {
+		return err
+	}
+
+	// Freeze header
+	if err := f.SetPanes(EDITSHEETNAME, `{
+		"freeze": true,
+		"split": false,
+		"x_split": 0,
+		"y_split": 1,
+		"top_left_cell": "A2",
+		"active_pane": "bottomLeft"
+	}`); err != nil {
+		return err
+	}
+
+	if lastRow < 2 {
+		return nil
+	}
+
+	// Drop-down lists
+	booleans := excelize.NewDataValidation(true)
+	booleans.Sqref = fmt.Sprintf("F2:G%d", lastRow)
+	if err := booleans.SetDropList([]string{editYes, editNo}); err != nil {
+		return err
+	}
+	if err := f.AddDataValidation(EDITSHEETNAME, booleans); err != nil {
+		return err
+	}
+
+	if len(gear) > 0 {
+		// The reference is written as is, so it can point to another sheet as well
+		gearList := excelize.NewDataValidation(true)
+		gearList.Sqref = fmt.Sprintf("E2:E%d", lastRow)
+		if err := gearList.SetSqrefDropList(fmt.Sprintf("'%s'!$A$2:$A$%d", EDITGEARSHEETNAME, len(gear)+1), true); err != nil {
+			return err
+		}
+		if err := f.AddDataValidation(EDITSHEETNAME, gearList); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addAuditSheet adds a sheet containing a row per changed field of the audit log
+func addAuditSheet(f *excelize.File, entries []models.AuditEntry) error {
+	if err := setExcelValues(f, AUDITSHEETNAME, 1, []interface{}{
+		"Zeit", "Aktivität", "Name", "Feld", "Alter Wert", "Neuer Wert", "Status", "Fehler",
+	}); err != nil {
+		return err
+	}
+
+	row := 2
+	for _, entry := range entries {
+		for _, change := range entry.Changes {
+			if err := setExcelValues(f, AUDITSHEETNAME, row, []interface{}{
+				entry.Time.Local(),
+				excelFormula(getActivityLinkFormula(entry.ActivityId)),
+				entry.ActivityName,
+				change.Label,
+				change.OldValue,
+				change.NewValue,
+				entry.Status,
+				entry.Error,
+			}); err != nil {
+				return err
+			}
+			row++
+		}
+	}
+
+	// Format cells
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 22})
+	if err != nil {
+		return err
+	}
+	if err := f.SetCellStyle(AUDITSHEETNAME, "A1", "H1", headerStyle); err != nil {
+		return err
+	}
+	if row > 2 {
+		if err := f.SetCellStyle(AUDITSHEETNAME, "A2", fmt.Sprintf("A%d", row-1), dateStyle); err != nil {
+			return err
+		}
+	}
+	if err := f.SetColWidth(AUDITSHEETNAME, "A", "B", 16); err != nil {
+		return err
+	}
+	if err := f.SetColWidth(AUDITSHEETNAME, "C", "C", 40); err != nil {
+		return err
+	}
+	return f.SetColWidth(AUDITSHEETNAME, "D", "H", 20)
+}
+
+// getAthleteGear fetches all bikes and shoes of the logged in athlete sorted by name (retired gear
+// is not included)
+func getAthleteGear(c *gin.Context) ([]models.Gear, bool, error) {
+	client, auth, err := getAPIClient(c)
+	if err != nil {
+		return nil, false, err
+	}
+
+	athlete, resp, err := client.AthletesApi.GetLoggedInAthlete(auth)
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return nil, true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	gear := []models.Gear{}
+	for _, g := range append(athlete.Bikes, athlete.Shoes...) {
+		gear = append(gear, models.Gear{
+			Id:       g.Id,
+			Name:     g.Name,
+			Primary:  g.Primary,
+			Distance: float64(g.Distance) / 1000,
+		})
+	}
+
+	sort.Slice(gear, func(i, j int) bool {
+		return gear[i].Name < gear[j].Name
+	})
+	return gear, false, nil
+}
+
+// formatEditBool returns the value of a boolean column
+func formatEditBool(value bool) string {
+	if value {
+		return editYes
+	}
+	return editNo
+}
+
+// toInterfaces converts a list of strings (e.g. a header) to values accepted by setExcelValues
+func toInterfaces(values []string) []interface{} {
+	items := []interface{}{}
+	for _, value := range values {
+		items = append(items, value)
+	}
+	return items
+}
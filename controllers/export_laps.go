@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/aschbacd/strava-export/models"
+	"github.com/xuri/excelize/v2"
+)
+
+var (
+	LAPSSHEETNAME = "Runden"
+)
+
+// lapsSheet writes the laps of all activities to a separate sheet using a stream writer
+type lapsSheet struct {
+	units         models.Units
+	sw            *excelize.StreamWriter
+	row           int
+	dateStyle     int
+	durationStyle int
+	paceStyle     int
+}
+
+// newLapsSheet creates the laps sheet and writes the header
+func newLapsSheet(f *excelize.File, units models.Units) (*lapsSheet, error) {
+	f.NewSheet(LAPSSHEETNAME)
+
+	// Freeze header
+	if err := f.SetPanes(LAPSSHEETNAME, `{
+		"freeze": true,
+		"split": false,
+		"x_split": 0,
+		"y_split": 1,
+		"top_left_cell": "A2",
+		"active_pane": "bottomLeft"
+	}`); err != nil {
+		return nil, err
+	}
+
+	sw, err := f.NewStreamWriter(LAPSSHEETNAME)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set column widths
+	for i, width := range []float64{14, 40, 8, 20, 16, 14, 10, 12, 18, 24, 26, 15, 20} {
+		if err := sw.SetColWidth(i+1, i+1, width); err != nil {
+			return nil, err
+		}
+	}
+
+	// Define styles
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return nil, err
+	}
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 22})
+	if err != nil {
+		return nil, err
+	}
+	durationStyle, err := f.NewStyle(&excelize.Style{NumFmt: 21})
+	if err != nil {
+		return nil, err
+	}
+	paceStyle, err := f.NewStyle(&excelize.Style{NumFmt: 45})
+	if err != nil {
+		return nil, err
+	}
+
+	// Set header
+	header := []interface{}{}
+	for _, value := range []string{
+		"Aktivität",
+		"Aktivitätsname",
+		"Runde",
+		"Name",
+		"Beginn",
+		fmt.Sprintf("Strecke [%s]", units.DistanceUnit),
+		"Zeit",
+		"Gesamtzeit",
+		fmt.Sprintf("Ø Tempo [%s]", units.PaceUnit),
+		fmt.Sprintf("Ø Geschwindigkeit [%s]", units.SpeedUnit),
+		fmt.Sprintf("Max. Geschwindigkeit [%s]", units.SpeedUnit),
+		"Ø Trittfrequenz",
+		fmt.Sprintf("Höhenzunahme [%s]", units.ElevationUnit),
+	} {
+		header = append(header, excelize.Cell{StyleID: headerStyle, Value: value})
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return nil, err
+	}
+
+	return &lapsSheet{
+		units:         units,
+		sw:            sw,
+		row:           2,
+		dateStyle:     dateStyle,
+		durationStyle: durationStyle,
+		paceStyle:     paceStyle,
+	}, nil
+}
+
+// AddActivities writes the laps of the given activities
+func (s *lapsSheet) AddActivities(activities []models.Activity) error {
+	for _, activity := range activities {
+		for _, lap := range activity.Laps {
+			if err := s.sw.SetRow("A"+fmt.Sprint(s.row), []interface{}{
+				excelize.Cell{Formula: getActivityLinkFormula(lap.ActivityId)},
+				lap.ActivityName,
+				lap.LapIndex,
+				lap.Name,
+				excelize.Cell{StyleID: s.dateStyle, Value: lap.DateLocal},
+				s.units.Distance(lap.Distance),
+				excelize.Cell{StyleID: s.durationStyle, Value: lap.MovingTime},
+				excelize.Cell{StyleID: s.durationStyle, Value: lap.ElapsedTime},
+				excelize.Cell{StyleID: s.paceStyle, Value: s.units.Pace(lap.AverageSpeed)},
+				s.units.Speed(lap.AverageSpeed),
+				s.units.Speed(lap.MaxSpeed),
+				lap.AverageCadence,
+				s.units.Elevation(lap.ElevationGain),
+			}); err != nil {
+				return err
+			}
+			s.row++
+		}
+	}
+	return nil
+}
+
+// Close ends the streaming of the laps sheet
+func (s *lapsSheet) Close() error {
+	return s.sw.Flush()
+}
+
+// activitiesWriters passes activities to multiple writers
+type activitiesWriters []activitiesWriter
+
+// AddActivities passes activities to all writers
+func (w activitiesWriters) AddActivities(activities []models.Activity) error {
+	for _, writer := range w {
+		if err := writer.AddActivities(activities); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes all writers
+func (w activitiesWriters) Close() error {
+	for _, writer := range w {
+		if err := writer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getActivityLinkFormula returns a formula linking to an activity on Strava (displaying the id)
+func getActivityLinkFormula(activityID int64) string {
+	return fmt.Sprintf(`HYPERLINK("https://www.strava.com/activities/%d",%d)`, activityID, activityID)
+}
@@ -0,0 +1,285 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+var (
+	SEGMENTSSHEETNAME = "Segmente"
+)
+
+// ExportSegments exports every effort on the starred segments (or a single segment) as Excel report
+func ExportSegments(c *gin.Context) {
+	// Get segments
+	var segments []models.Segment
+	var err error
+	if id := c.Query("id"); id != "" {
+		var segmentID int64
+		segmentID, err = strconv.ParseInt(id, 10, 64)
+		if err == nil {
+			var segment models.Segment
+			segment, err = getSegment(c, segmentID)
+			segments = []models.Segment{segment}
+		}
+	} else {
+		segments, err = getStarredSegments(c)
+	}
+	if err == errRateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Create Excel file
+	f := excelize.NewFile()
+	f.SetSheetName("Sheet1", SEGMENTSSHEETNAME)
+
+	if err := setExcelValues(f, SEGMENTSSHEETNAME, 1, []interface{}{
+		"Segment", "Sportart", "Strecke [km]", "Ø Steigung [%]", "Höhenunterschied [m]", "Ort", "Versuche", "Bestzeit", "Letzte Zeit",
+	}); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Activity cache is only loaded if the athlete has no subscription
+	var cache activityCache
+	usedNames := map[string]bool{strings.ToLower(SEGMENTSSHEETNAME): true}
+	for i, segment := range segments {
+		efforts, err := getSegmentEfforts(c, segment.Id, &cache)
+		if err == errRateLimitReached {
+			c.Redirect(http.StatusFound, "/rate-limit")
+			return
+		} else if err != nil {
+			logger.Error(err.Error())
+			utils.ReturnErrorPage(c)
+			return
+		}
+
+		// Add sheet containing all efforts
+		sheet := getUniqueSheetName(segment.Name, usedNames)
+		if err := addSegmentSheet(f, sheet, efforts); err != nil {
+			logger.Error(err.Error())
+			utils.ReturnErrorPage(c)
+			return
+		}
+
+		// Add segment to overview (linking to its sheet)
+		row := i + 2
+		values := []interface{}{
+			segment.Name,
+			segment.ActivityType,
+			models.MetricUnits.Distance(segment.Distance),
+			segment.AverageGrade,
+			models.MetricUnits.Elevation(segment.ElevationHigh - segment.ElevationLow),
+			segment.City,
+			len(efforts),
+		}
+		if len(efforts) > 0 {
+			best := efforts[0]
+			for _, effort := range efforts {
+				if effort.ElapsedTime < best.ElapsedTime {
+					best = effort
+				}
+			}
+			values = append(values, best.GetDuration(), efforts[len(efforts)-1].GetDuration())
+		}
+		if err := setExcelValues(f, SEGMENTSSHEETNAME, row, values); err != nil {
+			logger.Error(err.Error())
+			utils.ReturnErrorPage(c)
+			return
+		}
+		if err := f.SetCellHyperLink(SEGMENTSSHEETNAME, fmt.Sprintf("A%d", row), fmt.Sprintf("'%s'!A1", sheet), "Location"); err != nil {
+			logger.Error(err.Error())
+			utils.ReturnErrorPage(c)
+			return
+		}
+	}
+
+	// Format overview
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	if err := f.SetCellStyle(SEGMENTSSHEETNAME, "A1", "I1", headerStyle); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	if err := f.SetColWidth(SEGMENTSSHEETNAME, "A", "A", 40); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	if err := f.SetColWidth(SEGMENTSSHEETNAME, "B", "I", 18); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Set headers to make file downloadable
+	c.Header("Content-Disposition", "attachment;filename=strava-segments.xlsx")
+	c.Header("File-Name", "strava-segments.xlsx")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Expires", "0")
+
+	// Write file to gin's response writer
+	f.Write(c.Writer)
+}
+
+// addSegmentSheet adds a sheet containing all efforts on a segment and a chart of the elapsed times
+// including a linear trend (calculated by the TREND formula)
+func addSegmentSheet(f *excelize.File, sheet string, efforts []models.SegmentEffort) error {
+	f.NewSheet(sheet)
+
+	// Set header
+	if err := setExcelValues(f, sheet, 1, []interface{}{
+		"Datum", "Zeit", "Zeit [s]", "Trend [s]", "Ø Watt", "Ø Herzfrequenz", "Max. Herzfrequenz", "PR-Rang", "Aktivität",
+	}); err != nil {
+		return err
+	}
+
+	// Set values
+	lastRow := len(efforts) + 1
+	for i, effort := range efforts {
+		row := i + 2
+
+		prRank := interface{}(nil)
+		if effort.PrRank > 0 {
+			prRank = effort.PrRank
+		}
+
+		if err := setExcelValues(f, sheet, row, []interface{}{
+			effort.StartDateLocal,
+			effort.GetDuration(),
+			effort.ElapsedTime,
+			excelFormula(fmt.Sprintf("TREND($C$2:$C$%d,$A$2:$A$%d,A%d)", lastRow, lastRow, row)),
+			effort.AverageWatts,
+			effort.AverageHeartRate,
+			effort.MaxHeartRate,
+			prRank,
+			excelFormula(getActivityLinkFormula(effort.Activity.Id)),
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Format cells
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 22})
+	if err != nil {
+		return err
+	}
+	durationStyle, err := f.NewStyle(&excelize.Style{NumFmt: 21})
+	if err != nil {
+		return err
+	}
+	numberStyle, err := f.NewStyle(&excelize.Style{NumFmt: 1})
+	if err != nil {
+		return err
+	}
+
+	for _, style := range []struct {
+		HCell, VCell string
+		StyleID      int
+	}{
+		{"A1", "I1", headerStyle},
+		{"A2", fmt.Sprintf("A%d", lastRow), dateStyle},
+		{"B2", fmt.Sprintf("B%d", lastRow), durationStyle},
+		{"D2", fmt.Sprintf("D%d", lastRow), numberStyle},
+	} {
+		if err := f.SetCellStyle(sheet, style.HCell, style.VCell, style.StyleID); err != nil {
+			return err
+		}
+	}
+	if err := f.SetColWidth(sheet, "A", "I", 16); err != nil {
+		return err
+	}
+
+	// A trend requires at least two efforts
+	if len(efforts) < 2 {
+		return nil
+	}
+
+	series := []map[string]interface{}{}
+	for _, col := range []string{"C", "D"} {
+		series = append(series, map[string]interface{}{
+			"name":       fmt.Sprintf("'%s'!$%s$1", sheet, col),
+			"categories": fmt.Sprintf("'%s'!$A$2:$A$%d", sheet, lastRow),
+			"values":     fmt.Sprintf("'%s'!$%s$2:$%s$%d", sheet, col, col, lastRow),
+		})
+	}
+
+	format, err := json.Marshal(map[string]interface{}{
+		"type":   "line",
+		"series": series,
+		"format": map[string]interface{}{
+			"x_scale": 1.0,
+			"y_scale": 1.0,
+		},
+		"legend": map[string]interface{}{
+			"position": "bottom",
+		},
+		"title": map[string]interface{}{
+			"name": "Zeit",
+		},
+		"dimension": map[string]interface{}{
+			"width":  640,
+			"height": 320,
+		},
+		"x_axis": map[string]interface{}{
+			"num_format": "dd.mm.yyyy",
+		},
+		"y_axis": map[string]interface{}{
+			"major_grid_lines": true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return f.AddChart(sheet, "K2", string(format))
+}
+
+// getUniqueSheetName returns a valid sheet name (max. 31 characters, no special characters) that is
+// not used yet
+func getUniqueSheetName(name string, usedNames map[string]bool) string {
+	name = strings.NewReplacer(":", "", "\\", "", "/", "", "?", "", "*", "", "[", "", "]", "", "'", "").Replace(name)
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Segment"
+	}
+
+	truncate := func(name string, length int) string {
+		if runes := []rune(name); len(runes) > length {
+			return strings.TrimSpace(string(runes[:length]))
+		}
+		return name
+	}
+
+	unique := truncate(name, 31)
+	for i := 2; usedNames[strings.ToLower(unique)]; i++ {
+		suffix := fmt.Sprintf(" (%d)", i)
+		unique = truncate(name, 31-len(suffix)) + suffix
+	}
+
+	usedNames[strings.ToLower(unique)] = true
+	return unique
+}
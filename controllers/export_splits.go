@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aschbacd/strava-export/models"
+	"github.com/xuri/excelize/v2"
+)
+
+var (
+	SPLITSSHEETNAME        = "Splits"
+	SPLITSSUMMARYSHEETNAME = "Splits-Auswertung"
+
+	// Activity types containing splits
+	splitActivityTypes = map[string]bool{"Run": true, "TrailRun": true, "VirtualRun": true}
+)
+
+// splitsSheet writes the splits of all runs and a pace summary per run to separate sheets
+type splitsSheet struct {
+	units         models.Units
+	sw            *excelize.StreamWriter
+	summary       *excelize.StreamWriter
+	row           int
+	summaryRow    int
+	dateStyle     int
+	durationStyle int
+	paceStyle     int
+}
+
+// newSplitsSheet creates the splits sheets and writes the headers
+func newSplitsSheet(f *excelize.File, units models.Units) (*splitsSheet, error) {
+	// Define styles
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return nil, err
+	}
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 22})
+	if err != nil {
+		return nil, err
+	}
+	durationStyle, err := f.NewStyle(&excelize.Style{NumFmt: 21})
+	if err != nil {
+		return nil, err
+	}
+	paceStyle, err := f.NewStyle(&excelize.Style{NumFmt: 45})
+	if err != nil {
+		return nil, err
+	}
+
+	// Create sheets
+	writers := []*excelize.StreamWriter{}
+	for _, sheet := range []struct {
+		Name   string
+		Widths []float64
+		Header []string
+	}{
+		{
+			Name:   SPLITSSHEETNAME,
+			Widths: []float64{14, 40, 16, 8, 14, 10, 12, 18, 24, 24, 12},
+			Header: []string{
+				"Aktivität",
+				"Aktivitätsname",
+				"Datum",
+				"Split",
+				fmt.Sprintf("Strecke [%s]", units.DistanceUnit),
+				"Zeit",
+				"Gesamtzeit",
+				fmt.Sprintf("Ø Tempo [%s]", units.PaceUnit),
+				fmt.Sprintf("Ø Geschwindigkeit [%s]", units.SpeedUnit),
+				fmt.Sprintf("Höhendifferenz [%s]", units.ElevationUnit),
+				"Tempozone",
+			},
+		},
+		{
+			Name:   SPLITSSUMMARYSHEETNAME,
+			Widths: []float64{14, 40, 16, 8, 16, 18, 16, 18, 18, 18, 22, 16},
+			Header: []string{
+				"Aktivität",
+				"Aktivitätsname",
+				"Datum",
+				"Splits",
+				"Schnellster Split",
+				fmt.Sprintf("Schnellstes Tempo [%s]", units.PaceUnit),
+				"Langsamster Split",
+				fmt.Sprintf("Langsamstes Tempo [%s]", units.PaceUnit),
+				fmt.Sprintf("Tempo 1. Hälfte [%s]", units.PaceUnit),
+				fmt.Sprintf("Tempo 2. Hälfte [%s]", units.PaceUnit),
+				"Standardabweichung Tempo",
+				"Negativer Split",
+			},
+		},
+	} {
+		f.NewSheet(sheet.Name)
+
+		// Freeze header
+		if err := f.SetPanes(sheet.Name, `{
+			"freeze": true,
+			"split": false,
+			"x_split": 0,
+			"y_split": 1,
+			"top_left_cell": "A2",
+			"active_pane": "bottomLeft"
+		}`); err != nil {
+			return nil, err
+		}
+
+		sw, err := f.NewStreamWriter(sheet.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		// Set column widths
+		for i, width := range sheet.Widths {
+			if err := sw.SetColWidth(i+1, i+1, width); err != nil {
+				return nil, err
+			}
+		}
+
+		// Set header
+		header := []interface{}{}
+		for _, value := range sheet.Header {
+			header = append(header, excelize.Cell{StyleID: headerStyle, Value: value})
+		}
+		if err := sw.SetRow("A1", header); err != nil {
+			return nil, err
+		}
+
+		writers = append(writers, sw)
+	}
+
+	return &splitsSheet{
+		units:         units,
+		sw:            writers[0],
+		summary:       writers[1],
+		row:           2,
+		summaryRow:    2,
+		dateStyle:     dateStyle,
+		durationStyle: durationStyle,
+		paceStyle:     paceStyle,
+	}, nil
+}
+
+// AddActivities writes the splits and pace summary of all runs
+func (s *splitsSheet) AddActivities(activities []models.Activity) error {
+	for _, activity := range activities {
+		if !splitActivityTypes[activity.Type] {
+			continue
+		}
+
+		// Use splits matching the units
+		splits := activity.SplitsMetric
+		if s.units.Name == models.ImperialUnits.Name {
+			splits = activity.SplitsStandard
+		}
+		if len(splits) == 0 {
+			continue
+		}
+
+		for _, split := range splits {
+			if err := s.sw.SetRow("A"+fmt.Sprint(s.row), []interface{}{
+				excelize.Cell{Formula: getActivityLinkFormula(activity.Id)},
+				activity.Name,
+				excelize.Cell{StyleID: s.dateStyle, Value: activity.DateLocal},
+				split.Split,
+				s.units.Distance(split.Distance),
+				excelize.Cell{StyleID: s.durationStyle, Value: time.Duration(split.MovingTime) * time.Second},
+				excelize.Cell{StyleID: s.durationStyle, Value: time.Duration(split.ElapsedTime) * time.Second},
+				excelize.Cell{StyleID: s.paceStyle, Value: s.units.Pace(split.AverageSpeed)},
+				s.units.Speed(split.AverageSpeed),
+				s.units.Elevation(split.ElevationDifference),
+				split.PaceZone,
+			}); err != nil {
+				return err
+			}
+			s.row++
+		}
+
+		// Add pace summary
+		pace := getSplitsPaceSummary(splits)
+		negativeSplit := "Nein"
+		if pace.NegativeSplit {
+			negativeSplit = "Ja"
+		}
+
+		if err := s.summary.SetRow("A"+fmt.Sprint(s.summaryRow), []interface{}{
+			excelize.Cell{Formula: getActivityLinkFormula(activity.Id)},
+			activity.Name,
+			excelize.Cell{StyleID: s.dateStyle, Value: activity.DateLocal},
+			len(splits),
+			pace.Fastest.Split,
+			excelize.Cell{StyleID: s.paceStyle, Value: s.units.Pace(pace.Fastest.AverageSpeed)},
+			pace.Slowest.Split,
+			excelize.Cell{StyleID: s.paceStyle, Value: s.units.Pace(pace.Slowest.AverageSpeed)},
+			excelize.Cell{StyleID: s.paceStyle, Value: s.units.Pace(pace.FirstHalfSpeed)},
+			excelize.Cell{StyleID: s.paceStyle, Value: s.units.Pace(pace.SecondHalfSpeed)},
+			excelize.Cell{StyleID: s.paceStyle, Value: time.Duration(pace.Deviation * float64(time.Second))},
+			negativeSplit,
+		}); err != nil {
+			return err
+		}
+		s.summaryRow++
+	}
+	return nil
+}
+
+// Close ends the streaming of the splits sheets
+func (s *splitsSheet) Close() error {
+	if err := s.sw.Flush(); err != nil {
+		return err
+	}
+	return s.summary.Flush()
+}
+
+// splitsPaceSummary describes how consistent the pace of a run was
+type splitsPaceSummary struct {
+	Fastest         models.Split
+	Slowest         models.Split
+	FirstHalfSpeed  float64 // [m/s]
+	SecondHalfSpeed float64 // [m/s]
+	// Standard deviation of the pace of all splits [s]
+	Deviation     float64
+	NegativeSplit bool
+}
+
+// getSplitsPaceSummary returns the fastest and slowest split and compares both halves of a run,
+// short splits (e.g. the rest of the distance at the end) are ignored for fastest and slowest
+func getSplitsPaceSummary(splits []models.Split) splitsPaceSummary {
+	summary := splitsPaceSummary{}
+
+	// Get longest split to detect short splits
+	maxDistance := 0.0
+	for _, split := range splits {
+		maxDistance = math.Max(maxDistance, split.Distance)
+	}
+
+	// Fastest, slowest and average pace
+	paces := []float64{}
+	for _, split := range splits {
+		if split.Distance < maxDistance/2 || split.AverageSpeed <= 0 {
+			continue
+		}
+		if len(paces) == 0 || split.AverageSpeed > summary.Fastest.AverageSpeed {
+			summary.Fastest = split
+		}
+		if len(paces) == 0 || split.AverageSpeed < summary.Slowest.AverageSpeed {
+			summary.Slowest = split
+		}
+		paces = append(paces, maxDistance/split.AverageSpeed)
+	}
+
+	// Standard deviation of pace
+	if len(paces) > 0 {
+		mean := 0.0
+		for _, pace := range paces {
+			mean += pace / float64(len(paces))
+		}
+		variance := 0.0
+		for _, pace := range paces {
+			variance += (pace - mean) * (pace - mean) / float64(len(paces))
+		}
+		summary.Deviation = math.Round(math.Sqrt(variance))
+	}
+
+	// Compare halves by distance and moving time
+	var distances, times [2]float64
+	for i, split := range splits {
+		half := 0
+		if i >= len(splits)/2 {
+			half = 1
+		}
+		distances[half] += split.Distance
+		times[half] += float64(split.MovingTime)
+	}
+	if times[0] > 0 && times[1] > 0 {
+		summary.FirstHalfSpeed = distances[0] / times[0]
+		summary.SecondHalfSpeed = distances[1] / times[1]
+		summary.NegativeSplit = summary.SecondHalfSpeed > summary.FirstHalfSpeed
+	}
+
+	return summary
+}
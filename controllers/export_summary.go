@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// excelFormula is a cell value that is written as formula instead of a constant
+type excelFormula string
+
+// summaryPeriod describes how activities are grouped on a summary sheet
+type summaryPeriod struct {
+	SheetName string
+	Start     func(date time.Time) time.Time
+	End       func(start time.Time) time.Time
+	Label     func(start time.Time) string
+}
+
+// summaryKey identifies a row on a summary sheet
+type summaryKey struct {
+	Start time.Time
+	Type  string
+}
+
+var (
+	// Summary sheets (weekly, monthly, yearly)
+	summaryPeriods = []summaryPeriod{
+		{
+			SheetName: "Wochen",
+			Start: func(date time.Time) time.Time {
+				// ISO weeks start on monday
+				offset := (int(date.Weekday()) + 6) % 7
+				return time.Date(date.Year(), date.Month(), date.Day()-offset, 0, 0, 0, 0, time.UTC)
+			},
+			End: func(start time.Time) time.Time {
+				return start.AddDate(0, 0, 6)
+			},
+			Label: func(start time.Time) string {
+				year, week := start.ISOWeek()
+				return fmt.Sprintf("KW %02d/%d", week, year)
+			},
+		},
+		{
+			SheetName: "Monate",
+			Start: func(date time.Time) time.Time {
+				return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+			},
+			End: func(start time.Time) time.Time {
+				return start.AddDate(0, 1, -1)
+			},
+			Label: func(start time.Time) string {
+				return formatMonth(start.Month()) + " " + fmt.Sprint(start.Year())
+			},
+		},
+		{
+			SheetName: "Jahre",
+			Start: func(date time.Time) time.Time {
+				return time.Date(date.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+			},
+			End: func(start time.Time) time.Time {
+				return start.AddDate(1, 0, -1)
+			},
+			Label: func(start time.Time) string {
+				return fmt.Sprint(start.Year())
+			},
+		},
+	}
+
+	// Label used for rows containing all activity types
+	summaryAllTypes = "Alle"
+)
+
+// addSummarySheets adds a summary sheet for every period to the Excel file
+func addSummarySheets(f *excelize.File, stats *exportStats) error {
+	for i, period := range summaryPeriods {
+		if err := addSummarySheet(f, period, stats.SummaryKeys[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSummarySheet adds totals and averages per period and activity type, the values are calculated
+// by formulas referencing the activities table so they stay up to date when rows are edited or added
+func addSummarySheet(f *excelize.File, period summaryPeriod, keyMap map[summaryKey]bool) error {
+	f.NewSheet(period.SheetName)
+
+	// Get rows (all types first, then every type in alphabetical order)
+	keys := []summaryKey{}
+	for key := range keyMap {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].Start.Equal(keys[j].Start) {
+			return keys[i].Start.Before(keys[j].Start)
+		}
+		if keys[i].Type == summaryAllTypes || keys[j].Type == summaryAllTypes {
+			return keys[i].Type == summaryAllTypes
+		}
+		return keys[i].Type < keys[j].Type
+	})
+
+	// Set column widths
+	for col, width := range map[string]float64{
+		"A": 16, "B": 11, "C": 11, "D": 14, "E": 9, "F": 10, "G": 12, "H": 11, "I": 11, "J": 14, "K": 17, "L": 10, "M": 12} {
+		if err := f.SetColWidth(period.SheetName, col, col, width); err != nil {
+			return err
+		}
+	}
+
+	// Set header
+	if err := setExcelValues(f, period.SheetName, 1, []interface{}{
+		"Zeitraum",
+		"Beginn",
+		"Ende",
+		"Sportart",
+		"Anzahl",
+		"Strecke",
+		"Ø Strecke",
+		"Zeit",
+		"Ø Zeit",
+		"Höhenzunahme",
+		"Ø Höhenzunahme",
+		"Kalorien",
+		"Ø Kalorien",
+	}); err != nil {
+		return err
+	}
+
+	// Columns of activities table
+	dateRange := getTableColumn("Datum")
+	typeRange := getTableColumn("Sportart")
+
+	// Set values
+	for i, key := range keys {
+		row := i + 2
+
+		// Criteria matching period and activity type (rows of all types also count activities without a
+		// type, which aren't matched by a wildcard)
+		criteria := fmt.Sprintf(`%s,">="&$B%d,%s,"<"&($C%d+1)`, dateRange, row, dateRange, row)
+		if key.Type != summaryAllTypes {
+			criteria += fmt.Sprintf(",%s,$D%d", typeRange, row)
+		}
+
+		// Get sum and average formulas for a column of the activities table
+		sum := func(column string) excelFormula {
+			return excelFormula(fmt.Sprintf("SUMIFS(%s,%s)", getTableColumn(column), criteria))
+		}
+		average := func(col string) excelFormula {
+			return excelFormula(fmt.Sprintf("IF($E%d=0,0,%s%d/$E%d)", row, col, row, row))
+		}
+
+		if err := setExcelValues(f, period.SheetName, row, []interface{}{
+			period.Label(key.Start),
+			key.Start,
+			period.End(key.Start),
+			key.Type,
+			excelFormula(fmt.Sprintf("COUNTIFS(%s)", criteria)),
+			sum("Strecke"),
+			average("F"),
+			sum("Zeit"),
+			average("H"),
+			sum("Höhenzunahme"),
+			average("J"),
+			sum("Kalorien"),
+			average("L"),
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Define styles
+	headerStyle, err := newBorderHeaderStyle(f)
+	if err != nil {
+		return err
+	}
+	cellStyle, err := newBorderStyle(f, 0)
+	if err != nil {
+		return err
+	}
+	dateStyle, err := newBorderStyle(f, 14)
+	if err != nil {
+		return err
+	}
+	numberStyle, err := newBorderStyle(f, 2)
+	if err != nil {
+		return err
+	}
+	durationStyle, err := newBorderStyle(f, 46)
+	if err != nil {
+		return err
+	}
+
+	// Format cells
+	lastSummaryRow := fmt.Sprint(len(keys) + 1)
+	for _, style := range []struct {
+		HCell, VCell string
+		StyleID      int
+	}{
+		{"A1", "M1", headerStyle},
+		{"A2", "A" + lastSummaryRow, cellStyle},
+		{"B2", "C" + lastSummaryRow, dateStyle},
+		{"D2", "E" + lastSummaryRow, cellStyle},
+		{"F2", "G" + lastSummaryRow, numberStyle},
+		{"H2", "I" + lastSummaryRow, durationStyle},
+		{"J2", "M" + lastSummaryRow, numberStyle},
+	} {
+		if err := f.SetCellStyle(period.SheetName, style.HCell, style.VCell, style.StyleID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
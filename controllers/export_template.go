@@ -0,0 +1,336 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/userdata"
+	"github.com/xuri/excelize/v2"
+)
+
+var (
+	TEMPLATEFILE = "template.xlsx"
+
+	// Placeholders used in templates
+	placeholderActivities = "{{activities}}"
+	placeholderTitle      = "{{title}}"
+)
+
+// templateSheet writes activities into a workbook template supplied by the user, the activities are
+// inserted starting at the row containing the activities placeholder
+type templateSheet struct {
+	Stats *exportStats
+
+	f         *excelize.File
+	sheet     string
+	anchorCol int
+	anchorRow int
+	row       int
+	styles    []int
+	// Cells and merged cells below the anchor row, they are moved below the activities once all
+	// activities are written (inserting a row per activity is slow and doesn't adjust formulas)
+	footer       []templateCell
+	footerMerges [][4]int
+}
+
+// templateCell is a cell of the template footer, the row is relative to the anchor row
+type templateCell struct {
+	col     int
+	row     int
+	value   interface{}
+	formula string
+	style   int
+}
+
+// openTemplate opens the workbook template of an athlete
+func openTemplate(athleteID int64) (*excelize.File, error) {
+	data, err := ioutil.ReadFile(userdata.GetFilePath(athleteID, TEMPLATEFILE))
+	if err != nil {
+		return nil, err
+	}
+	return excelize.OpenReader(bytes.NewReader(data))
+}
+
+// findPlaceholder returns the sheet and cells containing a placeholder
+func findPlaceholder(f *excelize.File, placeholder string) (string, []string, error) {
+	for _, sheet := range f.GetSheetList() {
+		cells, err := f.SearchSheet(sheet, placeholder)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(cells) > 0 {
+			return sheet, cells, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// newTemplateSheet creates a writer for the activities placeholder of a template
+func newTemplateSheet(f *excelize.File) (*templateSheet, error) {
+	// Find anchor row
+	sheet, cells, err := findPlaceholder(f, placeholderActivities)
+	if err != nil {
+		return nil, err
+	} else if len(cells) == 0 {
+		return nil, fmt.Errorf("template doesn't contain placeholder %s", placeholderActivities)
+	}
+
+	col, row, err := excelize.CellNameToCoordinates(cells[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// Use styles of anchor row for all activities
+	styles := []int{}
+	for i := 0; i < len(getActivityValues(models.Activity{})); i++ {
+		axis, err := excelize.CoordinatesToCellName(col+i, row)
+		if err != nil {
+			return nil, err
+		}
+		style, err := f.GetCellStyle(sheet, axis)
+		if err != nil {
+			return nil, err
+		}
+		styles = append(styles, style)
+	}
+
+	if err := f.SetCellValue(sheet, cells[0], nil); err != nil {
+		return nil, err
+	}
+
+	// Rows below the anchor row have to be moved down
+	footer, err := removeTemplateFooter(f, sheet, row, col+len(styles)-1)
+	if err != nil {
+		return nil, err
+	}
+	footerMerges, err := removeTemplateFooterMerges(f, sheet, row)
+	if err != nil {
+		return nil, err
+	}
+
+	return &templateSheet{
+		Stats:        newExportStats(),
+		f:            f,
+		sheet:        sheet,
+		anchorCol:    col,
+		anchorRow:    row,
+		row:          row,
+		styles:       styles,
+		footer:       footer,
+		footerMerges: footerMerges,
+	}, nil
+}
+
+// removeTemplateFooter returns and clears the cells below the anchor row (at least up to the last
+// activity column, so styles of empty cells are kept)
+func removeTemplateFooter(f *excelize.File, sheet string, anchorRow, lastCol int) ([]templateCell, error) {
+	rows, err := f.GetRows(sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	footer := []templateCell{}
+	for row := anchorRow + 1; row <= len(rows); row++ {
+		cols := len(rows[row-1])
+		if cols < lastCol {
+			cols = lastCol
+		}
+		for col := 1; col <= cols; col++ {
+			axis, err := excelize.CoordinatesToCellName(col, row)
+			if err != nil {
+				return nil, err
+			}
+			cell := templateCell{col: col, row: row - anchorRow}
+			if cell.formula, err = f.GetCellFormula(sheet, axis); err != nil {
+				return nil, err
+			}
+			if cell.style, err = f.GetCellStyle(sheet, axis); err != nil {
+				return nil, err
+			}
+			if cell.value, err = getTemplateCellValue(f, sheet, axis); err != nil {
+				return nil, err
+			}
+			if cell.formula == "" && cell.value == nil && cell.style == 0 {
+				continue
+			}
+			footer = append(footer, cell)
+
+			if err := f.SetCellFormula(sheet, axis, ""); err != nil {
+				return nil, err
+			}
+			if err := f.SetCellValue(sheet, axis, nil); err != nil {
+				return nil, err
+			}
+			if err := f.SetCellStyle(sheet, axis, axis, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return footer, nil
+}
+
+// removeTemplateFooterMerges returns and unmerges the merged cells below the anchor row (start and
+// end column and row relative to the anchor row)
+func removeTemplateFooterMerges(f *excelize.File, sheet string, anchorRow int) ([][4]int, error) {
+	mergeCells, err := f.GetMergeCells(sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	merges := [][4]int{}
+	for _, mergeCell := range mergeCells {
+		startCol, startRow, err := excelize.CellNameToCoordinates(mergeCell.GetStartAxis())
+		if err != nil {
+			return nil, err
+		}
+		endCol, endRow, err := excelize.CellNameToCoordinates(mergeCell.GetEndAxis())
+		if err != nil {
+			return nil, err
+		}
+		if startRow <= anchorRow {
+			continue
+		}
+
+		merges = append(merges, [4]int{startCol, startRow - anchorRow, endCol, endRow - anchorRow})
+		if err := f.UnmergeCell(sheet, mergeCell.GetStartAxis(), mergeCell.GetEndAxis()); err != nil {
+			return nil, err
+		}
+	}
+	return merges, nil
+}
+
+// getTemplateCellValue returns the typed value of a cell (nil if empty)
+func getTemplateCellValue(f *excelize.File, sheet, axis string) (interface{}, error) {
+	value, err := f.GetCellValue(sheet, axis, excelize.Options{RawCellValue: true})
+	if err != nil || value == "" {
+		return nil, err
+	}
+
+	cellType, err := f.GetCellType(sheet, axis)
+	if err != nil {
+		return nil, err
+	}
+	switch cellType {
+	case excelize.CellTypeString:
+		return value, nil
+	case excelize.CellTypeBool:
+		return value == "1", nil
+	}
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return number, nil
+	}
+	return value, nil
+}
+
+// AddActivities writes activities into the template (rows must be passed in ascending order)
+func (s *templateSheet) AddActivities(activities []models.Activity) error {
+	for _, activity := range activities {
+		for i, value := range getActivityValues(activity) {
+			axis, err := excelize.CoordinatesToCellName(s.anchorCol+i, s.row)
+			if err != nil {
+				return err
+			}
+			if err := s.f.SetCellValue(s.sheet, axis, value); err != nil {
+				return err
+			}
+			if s.styles[i] == 0 {
+				continue
+			}
+			if err := s.f.SetCellStyle(s.sheet, axis, axis, s.styles[i]); err != nil {
+				return err
+			}
+		}
+
+		s.Stats.Add(activity)
+		s.row++
+	}
+	return nil
+}
+
+// Close moves the footer below the activities, replaces the title placeholders and defines the name
+// "Aktivitaeten" for the inserted rows, so formulas in the template can reference them (e.g.
+// =SUM(INDEX(Aktivitaeten,0,3))), formulas of the footer are not adjusted, so they have to use the
+// name instead of cell ranges
+func (s *templateSheet) Close() error {
+	lastRow := s.row - 1
+	if lastRow < s.anchorRow {
+		lastRow = s.anchorRow
+	}
+
+	// Move footer
+	for _, cell := range s.footer {
+		axis, err := excelize.CoordinatesToCellName(cell.col, lastRow+cell.row)
+		if err != nil {
+			return err
+		}
+		if cell.formula != "" {
+			err = s.f.SetCellFormula(s.sheet, axis, cell.formula)
+		} else if cell.value != nil {
+			err = s.f.SetCellValue(s.sheet, axis, cell.value)
+		}
+		if err != nil {
+			return err
+		}
+		if cell.style == 0 {
+			continue
+		}
+		if err := s.f.SetCellStyle(s.sheet, axis, axis, cell.style); err != nil {
+			return err
+		}
+	}
+	for _, merge := range s.footerMerges {
+		hcell, err := excelize.CoordinatesToCellName(merge[0], lastRow+merge[1])
+		if err != nil {
+			return err
+		}
+		vcell, err := excelize.CoordinatesToCellName(merge[2], lastRow+merge[3])
+		if err != nil {
+			return err
+		}
+		if err := s.f.MergeCell(s.sheet, hcell, vcell); err != nil {
+			return err
+		}
+	}
+
+	// Replace title
+	title := ""
+	if s.Stats.Count > 0 {
+		title = getTitle(s.Stats.FirstDate, s.Stats.LastDate)
+	}
+	for _, sheet := range s.f.GetSheetList() {
+		cells, err := s.f.SearchSheet(sheet, `\{\{title\}\}`, true)
+		if err != nil {
+			return err
+		}
+		for _, cell := range cells {
+			value, err := s.f.GetCellValue(sheet, cell)
+			if err != nil {
+				return err
+			}
+			if err := s.f.SetCellValue(sheet, cell, strings.ReplaceAll(value, placeholderTitle, title)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Define name for activities
+	hcell, err := excelize.CoordinatesToCellName(s.anchorCol, s.anchorRow, true)
+	if err != nil {
+		return err
+	}
+	vcell, err := excelize.CoordinatesToCellName(s.anchorCol+len(s.styles)-1, lastRow, true)
+	if err != nil {
+		return err
+	}
+
+	definedName := &excelize.DefinedName{
+		Name:     TABLENAME,
+		RefersTo: fmt.Sprintf("'%s'!%s:%s", s.sheet, hcell, vcell),
+	}
+	s.f.DeleteDefinedName(definedName)
+	return s.f.SetDefinedName(definedName)
+}
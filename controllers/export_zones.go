@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/aschbacd/strava-export/models"
+	"github.com/xuri/excelize/v2"
+)
+
+var (
+	ZONESSHEETNAME = "Zonen"
+)
+
+// zonesSheet aggregates the time in zone per week and writes it to a separate sheet when closed
+type zonesSheet struct {
+	f            *excelize.File
+	athleteZones *models.AthleteZones
+	stats        *zoneStats
+}
+
+// newZonesSheet creates a writer for the zones sheet
+func newZonesSheet(f *excelize.File, athleteZones *models.AthleteZones) *zonesSheet {
+	return &zonesSheet{
+		f:            f,
+		athleteZones: athleteZones,
+		stats:        newZoneStats(),
+	}
+}
+
+// AddActivities adds the time in zone of the given activities
+func (s *zonesSheet) AddActivities(activities []models.Activity) error {
+	for _, activity := range activities {
+		s.stats.Add(activity)
+	}
+	return nil
+}
+
+// Close writes the time in zone per week (one column per zone)
+func (s *zonesSheet) Close() error {
+	s.f.NewSheet(ZONESSHEETNAME)
+
+	// Get columns
+	header := []interface{}{"Woche", "Beginn"}
+	labels := map[string][]string{}
+	for _, zoneType := range zoneTypes {
+		labels[zoneType.Type] = s.stats.GetLabels(zoneType.Type, s.athleteZones)
+		for _, label := range labels[zoneType.Type] {
+			header = append(header, zoneType.Label+" "+label)
+		}
+		if len(labels[zoneType.Type]) > 0 {
+			header = append(header, zoneType.Label+" Gesamt")
+		}
+	}
+
+	// Set header
+	if err := setExcelValues(s.f, ZONESSHEETNAME, 1, header); err != nil {
+		return err
+	}
+
+	// Set values
+	weeks := s.stats.GetWeeks()
+	for i, week := range weeks {
+		values := []interface{}{summaryPeriods[0].Label(week.Start), week.Start}
+		for _, zoneType := range zoneTypes {
+			count := len(labels[zoneType.Type])
+			if count == 0 {
+				continue
+			}
+
+			times := week.Times[zoneType.Type]
+			for j := 0; j < count; j++ {
+				if j < len(times) {
+					values = append(values, times[j])
+				} else {
+					values = append(values, 0)
+				}
+			}
+
+			// Total of zones
+			first, err := excelize.ColumnNumberToName(len(values) - count + 1)
+			if err != nil {
+				return err
+			}
+			last, err := excelize.ColumnNumberToName(len(values))
+			if err != nil {
+				return err
+			}
+			values = append(values, excelFormula(fmt.Sprintf("SUM(%s%d:%s%d)", first, i+2, last, i+2)))
+		}
+
+		if err := setExcelValues(s.f, ZONESSHEETNAME, i+2, values); err != nil {
+			return err
+		}
+	}
+
+	// Define styles
+	headerStyle, err := s.f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	dateStyle, err := s.f.NewStyle(&excelize.Style{NumFmt: 14})
+	if err != nil {
+		return err
+	}
+	durationStyle, err := s.f.NewStyle(&excelize.Style{NumFmt: 46})
+	if err != nil {
+		return err
+	}
+
+	// Format cells
+	lastCol, err := excelize.ColumnNumberToName(len(header))
+	if err != nil {
+		return err
+	}
+	if err := s.f.SetColWidth(ZONESSHEETNAME, "A", "B", 12); err != nil {
+		return err
+	}
+	if err := s.f.SetColWidth(ZONESSHEETNAME, "C", lastCol, 24); err != nil {
+		return err
+	}
+	if err := s.f.SetCellStyle(ZONESSHEETNAME, "A1", lastCol+"1", headerStyle); err != nil {
+		return err
+	}
+	if len(weeks) == 0 {
+		return nil
+	}
+
+	lastRow := fmt.Sprint(len(weeks) + 1)
+	if err := s.f.SetCellStyle(ZONESSHEETNAME, "B2", "B"+lastRow, dateStyle); err != nil {
+		return err
+	}
+	return s.f.SetCellStyle(ZONESSHEETNAME, "C2", lastCol+lastRow, durationStyle)
+}
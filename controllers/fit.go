@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/antihax/optional"
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/fit"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// DownloadActivityFIT returns a FIT file generated from the details, laps and streams of an activity
+func DownloadActivityFIT(c *gin.Context) {
+	activityID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	fileName, data, err := getActivityFIT(c, activityID)
+	if err == errRateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Set headers to make file downloadable
+	c.Header("Content-Disposition", "attachment;filename="+fileName)
+	c.Header("File-Name", fileName)
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Expires", "0")
+
+	c.Data(http.StatusOK, "application/vnd.ant.fit", data)
+}
+
+var (
+	// File listing the activities missing in a ZIP archive because the rate limit was reached
+	FITNOTICEFILE = "FEHLENDE-AKTIVITAETEN.txt"
+)
+
+// ExportFIT returns a ZIP archive containing FIT files of all activities in the date range, the
+// finished files are returned if the rate limit is reached
+func ExportFIT(c *gin.Context) {
+	athleteActivityOpts := swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts{
+		PerPage: optional.NewInt32(100),
+	}
+	if err := setAthleteActivitiesOpts(c, &athleteActivityOpts); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	activities := []models.Activity{}
+	rateLimitReached, errors := fetchActivities(c, athleteActivityOpts, fetchOptions{}, func(page []models.Activity) error {
+		activities = append(activities, page...)
+		return nil
+	})
+	if rateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if len(errors) > 0 {
+		for _, err := range errors {
+			logger.Error(err.Error())
+		}
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Written to a temporary file first, so the rate limit or error page can be returned if a request
+	// fails
+	f, err := ioutil.TempFile("", "strava-fit")
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	archive := zip.NewWriter(f)
+	for i, activity := range activities {
+		fileName, data, err := getActivityFIT(c, activity.Id)
+		if err == errRateLimitReached && i == 0 {
+			c.Redirect(http.StatusFound, "/rate-limit")
+			return
+		} else if err == errRateLimitReached {
+			// Finished files are returned, missing activities are listed in the archive
+			if err := addMissingFITNotice(archive, activities[i:]); err != nil {
+				logger.Error(err.Error())
+				utils.ReturnErrorPage(c)
+				return
+			}
+			break
+		} else if err != nil {
+			logger.Error(err.Error())
+			utils.ReturnErrorPage(c)
+			return
+		}
+
+		entry, err := archive.Create(fileName)
+		if err == nil {
+			_, err = entry.Write(data)
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			utils.ReturnErrorPage(c)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Expires", "0")
+	c.FileAttachment(f.Name(), "strava-fit.zip")
+}
+
+// addMissingFITNotice adds a text file listing the activities that couldn't be exported because the
+// rate limit was reached
+func addMissingFITNotice(archive *zip.Writer, missing []models.Activity) error {
+	entry, err := archive.Create(FITNOTICEFILE)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(entry, "Das Rate-Limit von Strava wurde erreicht, %d Aktivitäten fehlen in diesem Export.\r\n", len(missing))
+	fmt.Fprint(entry, "Bitte die fehlenden Aktivitäten später erneut exportieren:\r\n\r\n")
+	for _, activity := range missing {
+		if _, err := fmt.Fprintf(entry, "%s %s (%d)\r\n", activity.DateLocal.Format("2006-01-02 15:04"), activity.Name, activity.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fitActivity is the detailed activity used for FIT files, fields containing coordinates are not
+// decoded (the generated LatLng is an empty struct)
+type fitActivity struct {
+	swagger.DetailedActivity
+	StartLatlng    json.RawMessage `json:"start_latlng,omitempty"`
+	EndLatlng      json.RawMessage `json:"end_latlng,omitempty"`
+	SegmentEfforts json.RawMessage `json:"segment_efforts,omitempty"`
+	BestEfforts    json.RawMessage `json:"best_efforts,omitempty"`
+}
+
+// getActivityFIT fetches the details, laps and streams of an activity and returns the file name and
+// the encoded FIT file
+func getActivityFIT(c *gin.Context, activityID int64) (string, []byte, error) {
+	client, auth, err := getAPIClient(c)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// JSON response must be used instead of Object because coordinates are not supported
+	activity := fitActivity{}
+	if err := getStravaJSON(c, fmt.Sprintf("/activities/%d", activityID), &activity); err != nil {
+		return "", nil, err
+	}
+	laps, resp, err := client.ActivitiesApi.GetLapsByActivityId(auth, activityID)
+	if err := getSwaggerError(resp, err); err != nil {
+		return "", nil, err
+	}
+	streams, err := getActivityStreams(c, activityID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err := fit.EncodeActivity(activity.DetailedActivity, laps, streams.StreamSet, streams.getLatLng())
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("%s-%d.fit", activity.StartDateLocal.Format("2006-01-02"), activity.Id), data, nil
+}
+
+// getSwaggerError returns the error of a request made with the swagger client
+func getSwaggerError(resp *http.Response, err error) error {
+	if resp == nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return err
+	case http.StatusTooManyRequests:
+		return errRateLimitReached
+	default:
+		return stravaStatusError(resp.StatusCode)
+	}
+}
@@ -0,0 +1,341 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antihax/optional"
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+	"github.com/aschbacd/strava-export/pkg/userdata"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	MAINTENANCEFILE = "maintenance.json"
+)
+
+// gearUsage contains the distance and time covered with a gear in the selected date range
+type gearUsage struct {
+	Gear       models.Gear
+	Activities int
+	Distance   float64 // [km]
+	Duration   time.Duration
+}
+
+// maintenanceStatus is a maintenance interval including the current state of its gear
+type maintenanceStatus struct {
+	Interval          models.MaintenanceInterval
+	Gear              models.Gear
+	DistanceSince     float64 // [km]
+	RemainingDistance float64 // [km]
+	Due               bool
+	DueSoon           bool
+}
+
+// GetGearPage returns the gear page showing the usage of all gear and due maintenance
+func GetGearPage(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Create activities api config
+	athleteActivityOpts := swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts{
+		PerPage: optional.NewInt32(100),
+	}
+
+	// Set timestamps for activities api config
+	if err := setAthleteActivitiesOpts(c, &athleteActivityOpts); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Use current year by default
+	from := c.Query("from")
+	if from == "" {
+		start := time.Date(time.Now().Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+		from = start.Format("2006-01-02")
+		athleteActivityOpts.After = optional.NewInt32(int32(start.Add(-time.Second).Unix()))
+	}
+
+	// Get usage per gear
+	usages := map[string]*gearUsage{}
+	rateLimitReached, errors := fetchActivities(c, athleteActivityOpts, fetchOptions{}, func(activities []models.Activity) error {
+		for _, activity := range activities {
+			if activity.GearId == "" {
+				continue
+			}
+			usage, exists := usages[activity.GearId]
+			if !exists {
+				usage = &gearUsage{}
+				usages[activity.GearId] = usage
+			}
+			usage.Activities++
+			usage.Distance += activity.Distance
+			usage.Duration += activity.Duration
+		}
+		return nil
+	})
+	if len(errors) > 0 || rateLimitReached {
+		// Log all errors
+		for _, err := range errors {
+			logger.Error(err.Error())
+		}
+
+		// Check if rate limit reached
+		if rateLimitReached {
+			c.Redirect(http.StatusFound, "/rate-limit")
+		} else {
+			utils.ReturnErrorPage(c)
+		}
+
+		return
+	}
+
+	// Get maintenance intervals (gear may not have been used in the date range)
+	intervals := []models.MaintenanceInterval{}
+	if err := userdata.Load(athleteID, MAINTENANCEFILE, &intervals); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	for _, interval := range intervals {
+		if _, exists := usages[interval.GearId]; !exists {
+			usages[interval.GearId] = &gearUsage{}
+		}
+	}
+
+	// Get details of all gear (name, total distance)
+	gear := map[string]models.Gear{}
+	for id, usage := range usages {
+		details, rateLimitReached, err := getGear(c, id)
+		if rateLimitReached {
+			c.Redirect(http.StatusFound, "/rate-limit")
+			return
+		} else if err != nil {
+			logger.Error(err.Error())
+			utils.ReturnErrorPage(c)
+			return
+		}
+
+		gear[id] = details
+		usage.Gear = details
+		usage.Distance = math.Round(usage.Distance*100) / 100
+	}
+
+	// Sort gear by distance in date range
+	gearUsages := []gearUsage{}
+	for _, usage := range usages {
+		gearUsages = append(gearUsages, *usage)
+	}
+	sort.Slice(gearUsages, func(i, j int) bool {
+		if gearUsages[i].Distance != gearUsages[j].Distance {
+			return gearUsages[i].Distance > gearUsages[j].Distance
+		}
+		return gearUsages[i].Gear.Name < gearUsages[j].Gear.Name
+	})
+
+	// Get maintenance status (due first)
+	maintenance := []maintenanceStatus{}
+	for _, interval := range intervals {
+		intervalGear := gear[interval.GearId]
+		maintenance = append(maintenance, maintenanceStatus{
+			Interval:          interval,
+			Gear:              intervalGear,
+			DistanceSince:     interval.GetDistanceSinceService(intervalGear),
+			RemainingDistance: interval.GetRemainingDistance(intervalGear),
+			Due:               interval.IsDue(intervalGear),
+			DueSoon:           interval.IsDueSoon(intervalGear),
+		})
+	}
+	sort.SliceStable(maintenance, func(i, j int) bool {
+		return maintenance[i].RemainingDistance < maintenance[j].RemainingDistance
+	})
+
+	// Return gear view
+	c.HTML(http.StatusOK, "gear", gin.H{
+		"gear":        gearUsages,
+		"maintenance": maintenance,
+		"from":        from,
+		"to":          c.Query("to"),
+		"error":       c.Query("error"),
+		"csrfToken":   c.GetString("csrfToken"),
+	})
+}
+
+// AddMaintenanceInterval stores a new maintenance interval for a component of a gear, the current
+// distance of the gear is used as last service
+func AddMaintenanceInterval(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Validate form
+	gearID := c.PostForm("gear")
+	component := strings.TrimSpace(c.PostForm("component"))
+	interval, err := strconv.ParseFloat(strings.Replace(c.PostForm("interval"), ",", ".", 1), 64)
+	if gearID == "" || component == "" || err != nil || interval <= 0 {
+		c.Redirect(http.StatusFound, "/gear?error=invalid")
+		return
+	}
+
+	gear, rateLimitReached, err := getGear(c, gearID)
+	if rateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Add interval
+	if err := updateMaintenanceIntervals(athleteID, func(intervals []models.MaintenanceInterval) []models.MaintenanceInterval {
+		return append(intervals, models.MaintenanceInterval{
+			Id:              utils.GetRandomString(16),
+			GearId:          gear.Id,
+			Component:       component,
+			Interval:        interval,
+			ServiceDistance: gear.Distance,
+			ServiceDate:     time.Now(),
+		})
+	}); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/gear")
+}
+
+// ServiceMaintenanceInterval marks a component as serviced at the current distance of its gear
+func ServiceMaintenanceInterval(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Get interval
+	intervals := []models.MaintenanceInterval{}
+	if err := userdata.Load(athleteID, MAINTENANCEFILE, &intervals); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	gearID := ""
+	for _, interval := range intervals {
+		if interval.Id == c.Param("id") {
+			gearID = interval.GearId
+		}
+	}
+	if gearID == "" {
+		c.Redirect(http.StatusFound, "/gear")
+		return
+	}
+
+	gear, rateLimitReached, err := getGear(c, gearID)
+	if rateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Reset interval
+	if err := updateMaintenanceIntervals(athleteID, func(intervals []models.MaintenanceInterval) []models.MaintenanceInterval {
+		for i := range intervals {
+			if intervals[i].Id == c.Param("id") {
+				intervals[i].ServiceDistance = gear.Distance
+				intervals[i].ServiceDate = time.Now()
+			}
+		}
+		return intervals
+	}); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/gear")
+}
+
+// DeleteMaintenanceInterval removes a maintenance interval
+func DeleteMaintenanceInterval(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	if err := updateMaintenanceIntervals(athleteID, func(intervals []models.MaintenanceInterval) []models.MaintenanceInterval {
+		remaining := []models.MaintenanceInterval{}
+		for _, interval := range intervals {
+			if interval.Id != c.Param("id") {
+				remaining = append(remaining, interval)
+			}
+		}
+		return remaining
+	}); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/gear")
+}
+
+// updateMaintenanceIntervals loads, modifies and stores the maintenance intervals of an athlete
+func updateMaintenanceIntervals(athleteID int64, update func([]models.MaintenanceInterval) []models.MaintenanceInterval) error {
+	intervals := []models.MaintenanceInterval{}
+	if err := userdata.Load(athleteID, MAINTENANCEFILE, &intervals); err != nil {
+		return err
+	}
+	return userdata.Save(athleteID, MAINTENANCEFILE, update(intervals))
+}
+
+// getGear fetches the details of a gear (returns true if the rate limit is reached)
+func getGear(c *gin.Context, id string) (models.Gear, bool, error) {
+	client, auth, err := getAPIClient(c)
+	if err != nil {
+		return models.Gear{}, false, err
+	}
+
+	stravaGear, resp, err := client.GearsApi.GetGearById(auth, id)
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return models.Gear{}, true, fmt.Errorf("rate limit reached")
+	} else if resp != nil && resp.StatusCode == http.StatusNotFound {
+		// Gear was deleted
+		return models.Gear{Id: id, Name: id}, false, nil
+	} else if err != nil {
+		return models.Gear{}, false, fmt.Errorf("failed to get gear %s: %s", id, err.Error())
+	}
+
+	return models.Gear{
+		Id:        stravaGear.Id,
+		Name:      stravaGear.Name,
+		BrandName: stravaGear.BrandName,
+		ModelName: stravaGear.ModelName,
+		Primary:   stravaGear.Primary,
+		Distance:  math.Round(float64(stravaGear.Distance/10)) / 100,
+	}, false, nil
+}
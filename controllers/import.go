@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/aschbacd/strava-export/pkg/importer"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Maximum size of all files uploaded at once
+	MAXIMPORTSIZE int64 = 100 << 20
+
+	// Imports are processed in the background, the last import of every athlete is kept to show its
+	// results
+	imports      = map[int64]*importJob{}
+	importsMutex sync.Mutex
+)
+
+// importJob is an import of an athlete processed in the background
+type importJob struct {
+	Total   int
+	Results []importer.Result
+	Done    bool
+}
+
+// GetImportPage returns the import page including the progress of the last import
+func GetImportPage(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	job := getImportJob(athleteID)
+	c.HTML(http.StatusOK, "import", gin.H{
+		"error":     c.Query("error"),
+		"results":   job.Results,
+		"running":   job.Total > 0 && !job.Done,
+		"total":     job.Total,
+		"done":      len(job.Results),
+		"csrfToken": c.GetString("csrfToken"),
+	})
+}
+
+// ImportFiles uploads GPX, FIT and TCX files (or ZIP archives containing them) to Strava in the
+// background, the result of every file is shown on the import page
+func ImportFiles(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	if job := getImportJob(athleteID); job.Total > 0 && !job.Done {
+		c.Redirect(http.StatusFound, "/import?error=running")
+		return
+	}
+
+	// Get uploaded files
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MAXIMPORTSIZE)
+	form, err := c.MultipartForm()
+	if err != nil {
+		c.Redirect(http.StatusFound, "/import?error=size")
+		return
+	}
+
+	files := []importer.File{}
+	for _, fileHeader := range form.File["files"] {
+		file, err := fileHeader.Open()
+		if err != nil {
+			logger.Error(err.Error())
+			utils.ReturnErrorPage(c)
+			return
+		}
+
+		data, err := ioutil.ReadAll(file)
+		file.Close()
+		if err != nil {
+			logger.Error(err.Error())
+			utils.ReturnErrorPage(c)
+			return
+		}
+		files = append(files, importer.File{Name: fileHeader.Filename, Data: data})
+	}
+	if len(files) == 0 {
+		c.Redirect(http.StatusFound, "/import?error=missing")
+		return
+	}
+
+	// Upload files
+	client, auth, err := getAPIClient(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Archives are expanded first, so the number of files is known
+	files, results := importer.ExpandFiles(files)
+	job := &importJob{Total: len(files) + len(results), Results: results}
+	importsMutex.Lock()
+	imports[athleteID] = job
+	importsMutex.Unlock()
+
+	go func() {
+		i := &importer.Importer{Client: client, Auth: auth}
+		for _, file := range files {
+			result := i.Upload(file)
+			importsMutex.Lock()
+			job.Results = append(job.Results, result)
+			importsMutex.Unlock()
+		}
+		importsMutex.Lock()
+		job.Done = true
+		importsMutex.Unlock()
+	}()
+
+	c.Redirect(http.StatusFound, "/import")
+}
+
+// getImportJob returns a copy of the last import of an athlete
+func getImportJob(athleteID int64) importJob {
+	importsMutex.Lock()
+	defer importsMutex.Unlock()
+
+	job, exists := imports[athleteID]
+	if !exists {
+		return importJob{}
+	}
+	return importJob{
+		Total:   job.Total,
+		Results: append([]importer.Result{}, job.Results...),
+		Done:    job.Done,
+	}
+}
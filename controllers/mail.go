@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	"github.com/aschbacd/strava-export/pkg/mailer"
+	"github.com/aschbacd/strava-export/pkg/userdata"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	MAILSETTINGSFILE = "mail.json"
+	// Larger exports are sent as link (mail servers often reject large messages)
+	MAILMAXATTACHMENTSIZE = 10 << 20
+)
+
+// exportMailData contains the values used in the mail templates
+type exportMailData struct {
+	Name     string
+	From     time.Time
+	To       time.Time
+	FileName string
+	Link     string
+	Error    string
+}
+
+// UpdateMailSettings sets the address, language and delivery type used for emails
+func UpdateMailSettings(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// An empty address disables emails
+	settings := models.MailSettings{
+		Address:  strings.TrimSpace(c.PostForm("address")),
+		Language: c.PostForm("language"),
+		Delivery: c.PostForm("delivery"),
+	}
+	if settings.Address != "" {
+		if _, err := mail.ParseAddress(settings.Address); err != nil {
+			c.Redirect(http.StatusFound, "/settings?error=mail")
+			return
+		}
+	}
+	if !models.IsSelectOption(models.MailLanguages, settings.Language) || !models.IsSelectOption(models.MailDeliveries, settings.Delivery) {
+		c.Redirect(http.StatusFound, "/settings?error=mail")
+		return
+	}
+
+	if err := userdata.Save(athleteID, MAILSETTINGSFILE, settings); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/settings")
+}
+
+// SendExport creates the Excel report and sends it to the athlete, the options are sent as form
+// (same options as for downloads)
+func SendExport(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	settings, err := loadMailSettings(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	if !canSendMail(settings) {
+		c.Redirect(http.StatusFound, "/settings?error=mail")
+		return
+	}
+
+	deliverExport(c, athleteID, "mail", func(run models.ScheduleRun, data []byte) error {
+		return sendExportMail(settings, run, data)
+	})
+}
+
+// loadMailSettings loads the email settings of an athlete (emails are sent in german as attachment
+// by default)
+func loadMailSettings(athleteID int64) (models.MailSettings, error) {
+	settings := models.MailSettings{
+		Language: mailer.DefaultLanguage,
+		Delivery: models.MailDeliveryAttachment,
+	}
+	err := userdata.Load(athleteID, MAILSETTINGSFILE, &settings)
+	return settings, err
+}
+
+// canSendMail checks if the server is configured to send emails and the athlete set an address
+func canSendMail(settings models.MailSettings) bool {
+	return mailer.GetConfig().IsConfigured() && settings.Address != ""
+}
+
+// sendExportMail sends the file exported by a run as attachment or as link to the run history
+func sendExportMail(settings models.MailSettings, run models.ScheduleRun, data []byte) error {
+	mailData := exportMailData{
+		Name:     run.ScheduleName,
+		From:     run.From,
+		To:       run.To,
+		FileName: getScheduleRunFileName(run),
+	}
+
+	message := mailer.Message{To: settings.Address}
+	if settings.Delivery == models.MailDeliveryLink || len(data) > MAILMAXATTACHMENTSIZE {
+		mailData.Link = os.Getenv("BASE_URL") + "/schedules/runs/" + run.Id
+	} else {
+		message.Attachments = []mailer.Attachment{{
+			Name:        mailData.FileName,
+			ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			Data:        data,
+		}}
+	}
+
+	var err error
+	message.Subject, message.Body, err = mailer.Render("export", settings.Language, mailData)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(mailer.GetConfig(), message)
+}
+
+// sendFailureMail notifies the athlete about a failed scheduled export
+func sendFailureMail(settings models.MailSettings, run models.ScheduleRun) error {
+	mailData := exportMailData{
+		Name:  run.ScheduleName,
+		From:  run.From,
+		To:    run.To,
+		Link:  os.Getenv("BASE_URL") + "/schedules",
+		Error: run.Error,
+	}
+
+	subject, body, err := mailer.Render("failure", settings.Language, mailData)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(mailer.GetConfig(), mailer.Message{To: settings.Address, Subject: subject, Body: body})
+}
@@ -2,9 +2,13 @@ package controllers
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/aschbacd/strava-export/models"
 	"github.com/aschbacd/strava-export/pkg/logger"
 	"github.com/aschbacd/strava-export/pkg/utils"
 	"github.com/gin-contrib/sessions"
@@ -15,9 +19,29 @@ import (
 // AuthMiddleware checks if a user is authenticated
 func (a *AuthController) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Personal api tokens (only accepted by the api)
+		if header := c.GetHeader("Authorization"); header != "" && isAPIRequest(c) {
+			a.authenticateAPIToken(c, header)
+			return
+		}
+
 		// Get token from session storage
 		tokenJSON := sessions.Default(c).Get("token")
 		if tokenJSON == nil {
+			if isAPIRequest(c) {
+				returnAPIError(c, http.StatusUnauthorized, "authentication required")
+				return
+			}
+
+			// Return to the requested page after logging in (e.g. team invites)
+			if c.Request.Method == http.MethodGet {
+				session := sessions.Default(c)
+				session.Set("redirect", c.Request.URL.RequestURI())
+				if err := session.Save(); err != nil {
+					logger.Error(err.Error())
+				}
+			}
+
 			c.Redirect(http.StatusFound, "/login")
 			c.Abort()
 			return
@@ -32,9 +56,157 @@ func (a *AuthController) AuthMiddleware() gin.HandlerFunc {
 		}
 
 		// Set token source and client
+		client := a.OAuthConfig.Client(context.Background(), &token)
 		c.Set("tokenSource", a.OAuthConfig.TokenSource(context.Background(), &token))
-		c.Set("client", a.OAuthConfig.Client(context.Background(), &token))
+		c.Set("client", client)
+
+		// Get athlete id (sessions created before the id was stored don't contain it)
+		session := sessions.Default(c)
+		athleteID, _ := session.Get("athleteId").(int64)
+		if athleteID == 0 {
+			id, err := getAthleteID(client)
+			if err != nil {
+				logger.Error(err.Error())
+				utils.ReturnErrorPage(c)
+				return
+			}
+
+			athleteID = id
+			session.Set("athleteId", athleteID)
+			if err := session.Save(); err != nil {
+				logger.Error(err.Error())
+				utils.ReturnErrorPage(c)
+				return
+			}
+		}
+		c.Set("athleteId", athleteID)
+
+		c.Next()
+	}
+}
+
+// CSRFMiddleware checks the csrf token of requests changing data, the token is stored in the session
+// and sent as form field csrf_token (multipart forms send it in the query, so uploads aren't parsed
+// before handlers limit their size)
+func CSRFMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Personal api tokens aren't sent automatically by browsers
+		if _, exists := c.Get("apiToken"); exists {
+			c.Next()
+			return
+		}
+
+		session := sessions.Default(c)
+		token, _ := session.Get("csrfToken").(string)
+		if token == "" {
+			token = utils.GetRandomString(32)
+			session.Set("csrfToken", token)
+			if err := session.Save(); err != nil {
+				logger.Error(err.Error())
+				utils.ReturnErrorPage(c)
+				return
+			}
+		}
+		c.Set("csrfToken", token)
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		sent := c.Query("csrf_token")
+		if sent == "" && !strings.HasPrefix(c.ContentType(), "multipart/") {
+			sent = c.PostForm("csrf_token")
+		}
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			logger.Warn(fmt.Sprintf("invalid csrf token for %s %s", c.Request.Method, c.Request.URL.Path))
+			c.HTML(http.StatusForbidden, "error", nil)
+			c.Abort()
+			return
+		}
+
+		// Forms are passed on as query by some handlers (e.g. export options), so the token is removed
+		query := c.Request.URL.Query()
+		query.Del("csrf_token")
+		c.Request.URL.RawQuery = query.Encode()
+		c.Request.PostForm.Del("csrf_token")
+		c.Request.Form.Del("csrf_token")
 
 		c.Next()
 	}
 }
+
+// authenticateAPIToken checks a personal api token passed as bearer token and sets the client using
+// the stored Strava token of its athlete
+func (a *AuthController) authenticateAPIToken(c *gin.Context, header string) {
+	plain := strings.TrimPrefix(header, "Bearer ")
+	if plain == header {
+		returnAPIError(c, http.StatusUnauthorized, "expected bearer token")
+		return
+	}
+
+	athleteID, apiToken, err := validateAPIToken(plain)
+	if err != nil {
+		returnAPIError(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	// Stored token is revoked if the athlete logs out or removes the application
+	tokenSource, err := getAthleteTokenSource(&a.OAuthConfig, athleteID)
+	if err != nil {
+		logger.Warn(err.Error())
+		returnAPIError(c, http.StatusUnauthorized, "Strava authorization expired, please log in again")
+		return
+	}
+
+	c.Set("tokenSource", tokenSource)
+	c.Set("client", oauth2.NewClient(context.Background(), tokenSource))
+	c.Set("athleteId", athleteID)
+	c.Set("apiToken", apiToken)
+
+	c.Next()
+}
+
+// RequireAPIScope checks if the personal api token grants a scope (sessions grant all scopes)
+func RequireAPIScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiToken, exists := c.Get("apiToken"); exists {
+			if token := apiToken.(models.APIToken); !token.HasScope(scope) {
+				returnAPIError(c, http.StatusForbidden, fmt.Sprintf("api token requires scope %s", scope))
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// getAthleteID returns the id of the logged in athlete
+func getAthleteID(client *http.Client) (int64, error) {
+	resp, err := client.Get("https://www.strava.com/api/v3/athlete")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to get athlete (status %d)", resp.StatusCode)
+	}
+
+	var athlete struct {
+		Id int64 `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&athlete); err != nil {
+		return 0, err
+	}
+	return athlete.Id, nil
+}
+
+// getContextAthleteID returns the athlete id set by the authentication middleware
+func getContextAthleteID(c *gin.Context) (int64, error) {
+	athleteID, exists := c.Get("athleteId")
+	if !exists {
+		return 0, fmt.Errorf("athlete id not passed by authentication middleware")
+	}
+	return athleteID.(int64), nil
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"bytes"
+	"html/template"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+)
+
+// newTestCSRFRouter returns a router with a session and the csrf middleware, the token is returned by
+// GET /token and POST /form returns the form values passed to the handler
+func newTestCSRFRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.SetHTMLTemplate(template.Must(template.New("error").Parse("error")))
+	r.Use(sessions.Sessions("session", cookie.NewStore([]byte("secret"))))
+	r.Use(CSRFMiddleware())
+	r.GET("/token", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("csrfToken"))
+	})
+	r.POST("/form", func(c *gin.Context) {
+		c.Request.ParseMultipartForm(1 << 20)
+		c.String(http.StatusOK, c.Request.Form.Encode())
+	})
+	return r
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	r := newTestCSRFRouter()
+
+	// Token is created on the first request and stored in the session cookie
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/token", nil))
+	token := recorder.Body.String()
+	cookies := recorder.Result().Cookies()
+	if token == "" || len(cookies) == 0 {
+		t.Fatal("no token created")
+	}
+
+	multipartBody := func() (string, *bytes.Buffer) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("name", "run")
+		writer.Close()
+		return writer.FormDataContentType(), &body
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		form    url.Values
+		multi   bool
+		cookie  bool
+		status  int
+		payload string
+	}{
+		{"form token", "", url.Values{"csrf_token": {token}, "name": {"run"}}, false, true, http.StatusOK, "name=run"},
+		{"query token", "?csrf_token=" + token, url.Values{"name": {"run"}}, false, true, http.StatusOK, "name=run"},
+		{"multipart with query token", "?csrf_token=" + token, nil, true, true, http.StatusOK, "name=run"},
+		{"missing token", "", url.Values{"name": {"run"}}, false, true, http.StatusForbidden, ""},
+		{"wrong token", "", url.Values{"csrf_token": {"wrong"}}, false, true, http.StatusForbidden, ""},
+		{"token of another session", "", url.Values{"csrf_token": {token}}, false, false, http.StatusForbidden, ""},
+	}
+	for _, test := range tests {
+		var req *http.Request
+		if test.multi {
+			contentType, body := multipartBody()
+			req = httptest.NewRequest(http.MethodPost, "/form"+test.query, body)
+			req.Header.Set("Content-Type", contentType)
+		} else {
+			req = httptest.NewRequest(http.MethodPost, "/form"+test.query, strings.NewReader(test.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if test.cookie {
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+		}
+
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, recorder.Code)
+			continue
+		}
+		if test.status == http.StatusOK && !strings.HasPrefix(recorder.Body.String(), test.payload) {
+			t.Errorf("%s: unexpected form %q", test.name, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/antihax/optional"
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+var (
+	RECORDSSHEETNAME     = "Bestzeiten"
+	PROGRESSIONSHEETNAME = "Entwicklung"
+)
+
+// personalRecords contains the best effort per distance and year and the progression of all-time
+// records per distance
+type personalRecords struct {
+	Distances   []string
+	Years       []int
+	Best        map[string]map[int]models.PersonalRecord
+	Progression map[string][]models.PersonalRecord
+}
+
+// recordsRow is a row of the records table (one record per year, nil if there is none)
+type recordsRow struct {
+	Distance string
+	Records  []*models.PersonalRecord
+}
+
+// GetRecordsPage returns the personal records page
+func GetRecordsPage(c *gin.Context) {
+	records, rateLimitReached, err := getPersonalRecords(c)
+	if rateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Create table (distances as rows and years as columns)
+	rows := []recordsRow{}
+	for _, distance := range records.Distances {
+		row := recordsRow{Distance: distance}
+		for _, year := range records.Years {
+			if record, exists := records.Best[distance][year]; exists {
+				row.Records = append(row.Records, &record)
+			} else {
+				row.Records = append(row.Records, nil)
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	progression := []models.PersonalRecord{}
+	for _, distance := range records.Distances {
+		progression = append(progression, records.Progression[distance]...)
+	}
+
+	c.HTML(http.StatusOK, "records", gin.H{
+		"years":       records.Years,
+		"rows":        rows,
+		"progression": progression,
+	})
+}
+
+// ExportRecords exports the personal records and their progression as Excel report
+func ExportRecords(c *gin.Context) {
+	records, rateLimitReached, err := getPersonalRecords(c)
+	if rateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	f := excelize.NewFile()
+	f.SetSheetName("Sheet1", RECORDSSHEETNAME)
+	f.NewSheet(PROGRESSIONSHEETNAME)
+
+	// Set headers
+	if err := setExcelValues(f, RECORDSSHEETNAME, 1, []interface{}{
+		"Strecke", "Jahr", "Zeit", fmt.Sprintf("Ø Tempo [%s]", models.MetricUnits.PaceUnit), "Datum", "Aktivität", "Aktivitätsname",
+	}); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	if err := setExcelValues(f, PROGRESSIONSHEETNAME, 1, []interface{}{
+		"Strecke", "Datum", "Zeit", "Verbesserung", "Aktivität", "Aktivitätsname",
+	}); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Set values
+	recordsRow, progressionRow := 2, 2
+	for _, distance := range records.Distances {
+		for _, year := range records.Years {
+			record, exists := records.Best[distance][year]
+			if !exists {
+				continue
+			}
+			if err := setExcelValues(f, RECORDSSHEETNAME, recordsRow, []interface{}{
+				distance,
+				year,
+				record.Effort.GetDuration(),
+				models.MetricUnits.Pace(record.Effort.Distance / float64(record.Effort.ElapsedTime)),
+				record.Effort.StartDateLocal,
+				excelFormula(getActivityLinkFormula(record.ActivityId)),
+				record.ActivityName,
+			}); err != nil {
+				logger.Error(err.Error())
+				utils.ReturnErrorPage(c)
+				return
+			}
+			recordsRow++
+		}
+
+		for _, record := range records.Progression[distance] {
+			if err := setExcelValues(f, PROGRESSIONSHEETNAME, progressionRow, []interface{}{
+				distance,
+				record.Effort.StartDateLocal,
+				record.Effort.GetDuration(),
+				record.Improvement,
+				excelFormula(getActivityLinkFormula(record.ActivityId)),
+				record.ActivityName,
+			}); err != nil {
+				logger.Error(err.Error())
+				utils.ReturnErrorPage(c)
+				return
+			}
+			progressionRow++
+		}
+	}
+
+	// Format cells
+	if err := formatRecordsSheets(f, recordsRow-1, progressionRow-1); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Set headers to make file downloadable
+	c.Header("Content-Disposition", "attachment;filename=strava-records.xlsx")
+	c.Header("File-Name", "strava-records.xlsx")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Expires", "0")
+
+	// Write file to gin's response writer
+	f.Write(c.Writer)
+}
+
+// formatRecordsSheets sets the column widths and number formats of the records sheets
+func formatRecordsSheets(f *excelize.File, lastRecordsRow, lastProgressionRow int) error {
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 14})
+	if err != nil {
+		return err
+	}
+	durationStyle, err := f.NewStyle(&excelize.Style{NumFmt: 21})
+	if err != nil {
+		return err
+	}
+	paceStyle, err := f.NewStyle(&excelize.Style{NumFmt: 45})
+	if err != nil {
+		return err
+	}
+
+	for _, style := range []struct {
+		Sheet        string
+		HCell, VCell string
+		StyleID      int
+	}{
+		{RECORDSSHEETNAME, "A1", "G1", headerStyle},
+		{RECORDSSHEETNAME, "C2", fmt.Sprintf("C%d", lastRecordsRow), durationStyle},
+		{RECORDSSHEETNAME, "D2", fmt.Sprintf("D%d", lastRecordsRow), paceStyle},
+		{RECORDSSHEETNAME, "E2", fmt.Sprintf("E%d", lastRecordsRow), dateStyle},
+		{PROGRESSIONSHEETNAME, "A1", "F1", headerStyle},
+		{PROGRESSIONSHEETNAME, "B2", fmt.Sprintf("B%d", lastProgressionRow), dateStyle},
+		{PROGRESSIONSHEETNAME, "C2", fmt.Sprintf("D%d", lastProgressionRow), durationStyle},
+	} {
+		if err := f.SetCellStyle(style.Sheet, style.HCell, style.VCell, style.StyleID); err != nil {
+			return err
+		}
+	}
+
+	for _, sheet := range []string{RECORDSSHEETNAME, PROGRESSIONSHEETNAME} {
+		if err := f.SetColWidth(sheet, "A", "F", 15); err != nil {
+			return err
+		}
+	}
+	if err := f.SetColWidth(RECORDSSHEETNAME, "G", "G", 40); err != nil {
+		return err
+	}
+	return f.SetColWidth(PROGRESSIONSHEETNAME, "F", "F", 40)
+}
+
+// getPersonalRecords gets the best efforts of all runs (details of runs that are not cached yet are
+// fetched) and returns the records per year and their progression
+func getPersonalRecords(c *gin.Context) (*personalRecords, bool, error) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		return nil, false, err
+	}
+
+	cache, err := loadActivityCache(athleteID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Add new runs to cache
+	athleteActivityOpts := swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts{
+		PerPage: optional.NewInt32(100),
+	}
+	rateLimitReached, errors := fetchActivities(c, athleteActivityOpts, fetchOptions{}, func(activities []models.Activity) error {
+		runs := []models.Activity{}
+		for _, activity := range activities {
+			if splitActivityTypes[activity.Type] {
+				runs = append(runs, activity)
+			}
+		}
+		return updateActivityCache(c, athleteID, cache, runs)
+	})
+	for _, err := range errors {
+		if err == errRateLimitReached {
+			rateLimitReached = true
+		}
+	}
+	if rateLimitReached {
+		return nil, true, nil
+	} else if len(errors) > 0 {
+		return nil, false, errors[0]
+	}
+
+	return getRecordsFromCache(cache), false, nil
+}
+
+// getRecordsFromCache returns the best effort per distance and year and the progression of all-time
+// records of the cached activities
+func getRecordsFromCache(cache activityCache) *personalRecords {
+	records := &personalRecords{
+		Best:        map[string]map[int]models.PersonalRecord{},
+		Progression: map[string][]models.PersonalRecord{},
+	}
+
+	// Get all efforts in chronological order
+	efforts := []models.PersonalRecord{}
+	for _, activity := range cache {
+		for _, effort := range activity.BestEfforts {
+			efforts = append(efforts, models.PersonalRecord{
+				Effort:       effort,
+				Year:         effort.StartDateLocal.Year(),
+				ActivityId:   activity.Id,
+				ActivityName: activity.Name,
+			})
+		}
+	}
+	sort.Slice(efforts, func(i, j int) bool {
+		return efforts[i].Effort.StartDateLocal.Before(efforts[j].Effort.StartDateLocal)
+	})
+
+	distances := map[string]float64{}
+	years := map[int]bool{}
+	for _, effort := range efforts {
+		name := effort.Effort.Name
+		distances[name] = effort.Effort.Distance
+		years[effort.Year] = true
+
+		// Best effort per year
+		if records.Best[name] == nil {
+			records.Best[name] = map[int]models.PersonalRecord{}
+		}
+		if best, exists := records.Best[name][effort.Year]; !exists || effort.Effort.ElapsedTime < best.Effort.ElapsedTime {
+			records.Best[name][effort.Year] = effort
+		}
+
+		// All-time records
+		progression := records.Progression[name]
+		if len(progression) == 0 {
+			records.Progression[name] = append(progression, effort)
+		} else if previous := progression[len(progression)-1]; effort.Effort.ElapsedTime < previous.Effort.ElapsedTime {
+			effort.Improvement = previous.Effort.GetDuration() - effort.Effort.GetDuration()
+			records.Progression[name] = append(progression, effort)
+		}
+	}
+
+	// Sort distances by length and years ascending
+	for distance := range distances {
+		records.Distances = append(records.Distances, distance)
+	}
+	sort.Slice(records.Distances, func(i, j int) bool {
+		return distances[records.Distances[i]] < distances[records.Distances[j]]
+	})
+	for year := range years {
+		records.Years = append(records.Years, year)
+	}
+	sort.Ints(records.Years)
+
+	return records
+}
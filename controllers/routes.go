@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Maximum page size of route requests
+	ROUTESPERPAGE = 200
+
+	// Supported route file formats
+	routeFormats = map[string]string{"gpx": "export_gpx", "tcx": "export_tcx"}
+
+	// Characters that are replaced in file names
+	fileNameRegex = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// GetRoutesPage returns the page listing all routes of the athlete
+func GetRoutesPage(c *gin.Context) {
+	routes, err := getRoutes(c)
+	if err == errRateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.HTML(http.StatusOK, "routes", gin.H{
+		"routes": routes,
+	})
+}
+
+// DownloadRoute returns the GPX or TCX file of a single route
+func DownloadRoute(c *gin.Context) {
+	format := c.Param("format")
+	if _, exists := routeFormats[format]; !exists {
+		c.Redirect(http.StatusFound, "/routes")
+		return
+	}
+
+	routeID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/routes")
+		return
+	}
+
+	// Get route (used for the file name)
+	var route models.Route
+	if err := getStravaJSON(c, fmt.Sprintf("/routes/%d", routeID), &route); err == errRateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Get route file
+	file, err := getRouteFile(c, route.Id, format)
+	if err == errRateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	defer file.Close()
+
+	// Set headers to make file downloadable
+	fileName := getRouteFileName(route, format)
+	c.Header("Content-Disposition", "attachment;filename="+fileName)
+	c.Header("File-Name", fileName)
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Expires", "0")
+
+	if _, err := io.Copy(c.Writer, file); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// DownloadRoutes returns a ZIP file containing the GPX or TCX files of the selected routes (all routes
+// if none are selected)
+func DownloadRoutes(c *gin.Context) {
+	format := c.Query("format")
+	if _, exists := routeFormats[format]; !exists {
+		format = "gpx"
+	}
+
+	routes, err := getRoutes(c)
+	if err == errRateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Filter selected routes
+	if ids := c.QueryArray("id"); len(ids) > 0 {
+		selected := map[string]bool{}
+		for _, id := range ids {
+			selected[id] = true
+		}
+
+		selectedRoutes := []models.Route{}
+		for _, route := range routes {
+			if selected[fmt.Sprint(route.Id)] {
+				selectedRoutes = append(selectedRoutes, route)
+			}
+		}
+		routes = selectedRoutes
+	}
+
+	// Fetch first file before writing the response, so errors can still be shown (e.g. missing scope)
+	var firstFile io.ReadCloser
+	if len(routes) > 0 {
+		firstFile, err = getRouteFile(c, routes[0].Id, format)
+		if err == errRateLimitReached {
+			c.Redirect(http.StatusFound, "/rate-limit")
+			return
+		} else if err != nil {
+			logger.Error(err.Error())
+			utils.ReturnErrorPage(c)
+			return
+		}
+	}
+
+	// Set headers to make file downloadable
+	c.Header("Content-Disposition", "attachment;filename=strava-routes.zip")
+	c.Header("File-Name", "strava-routes.zip")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Expires", "0")
+
+	// Write files to ZIP archive (the response can't be changed anymore if a request fails)
+	archive := zip.NewWriter(c.Writer)
+	for i, route := range routes {
+		file := firstFile
+		if i > 0 {
+			file, err = getRouteFile(c, route.Id, format)
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			break
+		}
+
+		entry, err := archive.Create(getRouteFileName(route, format))
+		if err == nil {
+			_, err = io.Copy(entry, file)
+		}
+		file.Close()
+		if err != nil {
+			logger.Error(err.Error())
+			break
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// getRoutes fetches all routes of the logged in athlete
+func getRoutes(c *gin.Context) ([]models.Route, error) {
+	routes := []models.Route{}
+	for page := 1; ; page++ {
+		// JSON response must be used instead of Object because coordinates of segments are not supported
+		pageRoutes := []models.Route{}
+		query := url.Values{"page": {fmt.Sprint(page)}, "per_page": {fmt.Sprint(ROUTESPERPAGE)}}
+		if err := getStravaJSON(c, "/athlete/routes?"+query.Encode(), &pageRoutes); err != nil {
+			return nil, err
+		}
+
+		routes = append(routes, pageRoutes...)
+		if len(pageRoutes) < ROUTESPERPAGE {
+			return routes, nil
+		}
+	}
+}
+
+// getRouteFile fetches the GPX or TCX file of a route (the generated client discards the response
+// body, so the request is sent directly)
+func getRouteFile(c *gin.Context, routeID int64, format string) (io.ReadCloser, error) {
+	// Get client from authentication middleware
+	client, exists := c.Get("client")
+	if !exists {
+		return nil, fmt.Errorf("client not passed by authentication middleware")
+	}
+
+	resp, err := client.(*http.Client).Get(fmt.Sprintf("https://www.strava.com/api/v3/routes/%d/%s", routeID, routeFormats[format]))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		resp.Body.Close()
+		return nil, errRateLimitReached
+	} else if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to get %s file of route %d (status %d)", format, routeID, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// getRouteFileName returns a unique file name for a route (e.g. Morning-Ride-123.gpx)
+func getRouteFileName(route models.Route, format string) string {
+	name := strings.Trim(fileNameRegex.ReplaceAllString(route.Name, "-"), "-")
+	if name != "" {
+		name += "-"
+	}
+	return fmt.Sprintf("%s%d.%s", name, route.Id, format)
+}
@@ -0,0 +1,556 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/cron"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	"github.com/aschbacd/strava-export/pkg/userdata"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+var (
+	SCHEDULESFILE    = "schedules.json"
+	SCHEDULERUNSFILE = "schedule-runs.json"
+	// Directory of exported files (in the data directory of an athlete)
+	SCHEDULEEXPORTSDIR = "exports"
+
+	// Interval in which due schedules are searched
+	SCHEDULEINTERVAL = time.Minute
+	// Delay before a run is retried if the rate limit was reached
+	SCHEDULERETRYDELAY = 15 * time.Minute
+	// Number of runs kept in the history (files of older runs are removed)
+	SCHEDULERUNSLIMIT = 100
+	// Name of manual exports in the history (e.g. sent by email)
+	MANUALEXPORTNAME = "Aktivitäten"
+
+	// Export options that can be stored in a schedule
+	scheduleQueryKeys = []string{"charts", "laps", "splits", "zones", "commute", "template", "units"}
+
+	// Schedules and runs are changed by the scheduler and by requests of the athlete
+	schedulesMutex sync.Mutex
+
+	errMailNotConfigured    = errors.New("mail delivery not configured")
+	errAuthorizationExpired = errors.New("strava authorization expired")
+)
+
+type ScheduleController struct {
+	OAuthConfig oauth2.Config
+}
+
+// NewScheduleController creates a schedule controller and starts running due schedules in the
+// background
+func NewScheduleController(config oauth2.Config) *ScheduleController {
+	sc := &ScheduleController{OAuthConfig: config}
+	go sc.runSchedules()
+	return sc
+}
+
+// GetSchedulesPage returns the page listing the schedules and the run history, failed runs are marked
+// as seen
+func GetSchedulesPage(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	schedules, err := loadSchedules(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Created.Before(schedules[j].Created)
+	})
+
+	runs, err := markScheduleRunsSeen(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.HTML(http.StatusOK, "schedules", gin.H{
+		"schedules":    schedules,
+		"runs":         runs,
+		"ranges":       models.ScheduleRanges,
+		"destinations": models.ScheduleDestinations,
+		"hasTemplate":  userdata.Exists(athleteID, TEMPLATEFILE),
+		"timezone":     time.Now().Location().String(),
+		"error":        c.Query("error"),
+		"csrfToken":    c.GetString("csrfToken"),
+	})
+}
+
+// CreateSchedule adds a schedule, the export options are the same as on the activities page
+func CreateSchedule(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	name := strings.TrimSpace(c.PostForm("name"))
+	expression := strings.TrimSpace(c.PostForm("cron"))
+	schedule := models.Schedule{
+		Id:          utils.GetRandomString(16),
+		Name:        name,
+		Cron:        expression,
+		Range:       c.PostForm("range"),
+		Destination: c.PostForm("destination"),
+		Created:     time.Now(),
+	}
+	if name == "" || !models.IsSelectOption(models.ScheduleRanges, schedule.Range) || !models.IsSelectOption(models.ScheduleDestinations, schedule.Destination) {
+		c.Redirect(http.StatusFound, "/schedules?error=invalid")
+		return
+	}
+
+	parsed, err := cron.Parse(expression)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/schedules?error=cron")
+		return
+	}
+	if schedule.NextRun = parsed.Next(time.Now()); schedule.NextRun.IsZero() {
+		c.Redirect(http.StatusFound, "/schedules?error=cron")
+		return
+	}
+
+	// Only keep known export options
+	query := url.Values{}
+	for _, key := range scheduleQueryKeys {
+		if value := c.PostForm(key); value != "" {
+			query.Set(key, value)
+		}
+	}
+	schedule.Query = query.Encode()
+
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	schedules, err := loadSchedules(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	schedules = append(schedules, schedule)
+	if err := userdata.Save(athleteID, SCHEDULESFILE, schedules); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/schedules")
+}
+
+// DeleteSchedule removes a schedule (the run history is kept)
+func DeleteSchedule(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	schedules, err := loadSchedules(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	remaining := []models.Schedule{}
+	for _, schedule := range schedules {
+		if schedule.Id != c.Param("id") {
+			remaining = append(remaining, schedule)
+		}
+	}
+	if err := userdata.Save(athleteID, SCHEDULESFILE, remaining); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/schedules")
+}
+
+// RunScheduleNow marks a schedule as due, it is run by the scheduler within the next minute
+func RunScheduleNow(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	schedules, err := loadSchedules(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	for i := range schedules {
+		if schedules[i].Id == c.Param("id") {
+			schedules[i].NextRun = time.Now()
+		}
+	}
+	if err := userdata.Save(athleteID, SCHEDULESFILE, schedules); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/schedules")
+}
+
+// DownloadScheduleRun returns the file exported by a run
+func DownloadScheduleRun(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	runs, err := loadScheduleRuns(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	for _, run := range runs {
+		if run.Id != c.Param("id") || run.File == "" {
+			continue
+		}
+
+		c.FileAttachment(userdata.GetFilePath(athleteID, run.File), getScheduleRunFileName(run))
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/schedules")
+}
+
+// countUnseenFailedRuns returns the number of failed runs not shown to the athlete yet
+func countUnseenFailedRuns(athleteID int64) (int, error) {
+	runs, err := loadScheduleRuns(athleteID)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, run := range runs {
+		if run.IsFailed() && !run.Seen {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// runSchedules runs due schedules of all athletes one after another (exports are expensive and share
+// the rate limit of the application)
+func (sc *ScheduleController) runSchedules() {
+	for now := range time.Tick(SCHEDULEINTERVAL) {
+		athleteIDs, err := userdata.GetAthleteIDs()
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+
+		for _, athleteID := range athleteIDs {
+			if err := sc.runDueSchedules(athleteID, now); err != nil {
+				logger.Error(fmt.Sprintf("failed to run schedules of athlete %d: %s", athleteID, err.Error()))
+			}
+		}
+	}
+}
+
+// runDueSchedules runs all schedules of an athlete that are due
+func (sc *ScheduleController) runDueSchedules(athleteID int64, now time.Time) error {
+	if !userdata.Exists(athleteID, SCHEDULESFILE) {
+		return nil
+	}
+
+	schedulesMutex.Lock()
+	schedules, err := loadSchedules(athleteID)
+	schedulesMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, schedule := range schedules {
+		if schedule.NextRun.IsZero() || schedule.NextRun.After(now) {
+			continue
+		}
+
+		run, err := sc.runSchedule(athleteID, schedule, now)
+		run.Finished = time.Now()
+		nextRun := now.Add(SCHEDULERETRYDELAY)
+		if err == errRateLimitReached {
+			run.Error = fmt.Sprintf("Rate-Limit erreicht, neuer Versuch um %s", nextRun.Format("15:04"))
+		} else if err != nil {
+			run.Error = getRunErrorMessage(err)
+		}
+		if err != nil {
+			run.Status = models.ScheduleRunFailed
+			logger.Error(fmt.Sprintf("scheduled export %s of athlete %d failed: %s", schedule.Id, athleteID, err.Error()))
+		}
+
+		// Notify athlete by email (runs reaching the rate limit are retried)
+		if err != nil && err != errRateLimitReached {
+			if mailErr := notifyScheduleFailure(athleteID, run); mailErr != nil {
+				logger.Error(fmt.Sprintf("failed to send failure notification to athlete %d: %s", athleteID, mailErr.Error()))
+			}
+		}
+
+		// Next run is calculated from the current time, so missed runs are not repeated
+		if err != errRateLimitReached {
+			parsed, parseErr := cron.Parse(schedule.Cron)
+			if parseErr != nil {
+				return parseErr
+			}
+			nextRun = parsed.Next(time.Now())
+		}
+
+		if err := saveScheduleRun(athleteID, schedule.Id, run, nextRun); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runSchedule exports the activities of the schedule's date range and delivers the file
+func (sc *ScheduleController) runSchedule(athleteID int64, schedule models.Schedule, now time.Time) (models.ScheduleRun, error) {
+	from, to := schedule.GetDateRange(now)
+	run := models.ScheduleRun{
+		Id:           utils.GetRandomString(16),
+		ScheduleId:   schedule.Id,
+		ScheduleName: schedule.Name,
+		From:         from,
+		To:           to,
+		Started:      time.Now(),
+		Status:       models.ScheduleRunSucceeded,
+	}
+
+	c, err := sc.newScheduleContext(athleteID, schedule, from, to)
+	if err != nil {
+		return run, err
+	}
+
+	f, err := createExport(c)
+	if err != nil {
+		return run, err
+	}
+
+	var buffer bytes.Buffer
+	if err := f.Write(&buffer); err != nil {
+		return run, err
+	}
+
+	// File is kept for the run history regardless of the destination
+	if err := storeExportRun(athleteID, &run, buffer.Bytes()); err != nil {
+		return run, err
+	}
+
+	switch schedule.Destination {
+	case models.ScheduleDestinationEmail:
+		settings, err := loadMailSettings(athleteID)
+		if err != nil {
+			return run, err
+		}
+		if !canSendMail(settings) {
+			return run, errMailNotConfigured
+		}
+		if err := sendExportMail(settings, run, buffer.Bytes()); err != nil {
+			return run, err
+		}
+	case models.ScheduleDestinationStorage:
+		if err := storeExport(athleteID, run, buffer.Bytes()); err != nil {
+			return run, err
+		}
+	}
+	return run, nil
+}
+
+// getRunErrorMessage returns the message shown to the athlete if a run failed
+func getRunErrorMessage(err error) string {
+	var uploadErr uploadError
+	switch {
+	case errors.Is(err, errMailNotConfigured):
+		return "E-Mail-Versand nicht konfiguriert"
+	case errors.Is(err, errAuthorizationExpired):
+		return "Strava-Zugriff abgelaufen, bitte erneut anmelden"
+	case errors.As(err, &uploadErr):
+		return getStorageErrorMessage(err)
+	default:
+		return err.Error()
+	}
+}
+
+// storeExportRun stores the file exported by a run in the data directory of the athlete
+func storeExportRun(athleteID int64, run *models.ScheduleRun, data []byte) error {
+	file := path.Join(SCHEDULEEXPORTSDIR, run.Id+".xlsx")
+	if err := userdata.WriteFile(athleteID, file, data); err != nil {
+		return err
+	}
+	run.File = file
+	return nil
+}
+
+// notifyScheduleFailure sends an email about a failed run if the athlete set an address
+func notifyScheduleFailure(athleteID int64, run models.ScheduleRun) error {
+	settings, err := loadMailSettings(athleteID)
+	if err != nil || !canSendMail(settings) {
+		return err
+	}
+	return sendFailureMail(settings, run)
+}
+
+// newScheduleContext creates the context passed to the export (export options and date range as
+// query)
+func (sc *ScheduleController) newScheduleContext(athleteID int64, schedule models.Schedule, from, to time.Time) (*gin.Context, error) {
+	query, err := url.ParseQuery(schedule.Query)
+	if err != nil {
+		return nil, err
+	}
+	query.Set("from", from.Format("2006-01-02"))
+	query.Set("to", to.Format("2006-01-02"))
+
+	// Stored token is revoked if the athlete logs out or removes the application
+	c, err := newAthleteContext(&sc.OAuthConfig, athleteID, query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errAuthorizationExpired, err)
+	}
+	return c, nil
+}
+
+// saveScheduleRun adds a run to the history and sets the next run of its schedule, files of runs
+// removed from the history are deleted
+func saveScheduleRun(athleteID int64, scheduleID string, run models.ScheduleRun, nextRun time.Time) error {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	// Schedule may have been changed or removed during the run (manual exports have no schedule)
+	if scheduleID != "" {
+		schedules, err := loadSchedules(athleteID)
+		if err != nil {
+			return err
+		}
+		for i := range schedules {
+			if schedules[i].Id == scheduleID {
+				schedules[i].LastRun = run.Started
+				schedules[i].NextRun = nextRun
+			}
+		}
+		if err := userdata.Save(athleteID, SCHEDULESFILE, schedules); err != nil {
+			return err
+		}
+	}
+
+	runs, err := loadScheduleRuns(athleteID)
+	if err != nil {
+		return err
+	}
+	runs = append([]models.ScheduleRun{run}, runs...)
+
+	if len(runs) > SCHEDULERUNSLIMIT {
+		for _, removed := range runs[SCHEDULERUNSLIMIT:] {
+			if removed.File != "" {
+				if err := userdata.Delete(athleteID, removed.File); err != nil {
+					return err
+				}
+			}
+		}
+		runs = runs[:SCHEDULERUNSLIMIT]
+	}
+
+	return userdata.Save(athleteID, SCHEDULERUNSFILE, runs)
+}
+
+// markScheduleRunsSeen marks all failed runs as seen and returns the run history
+func markScheduleRunsSeen(athleteID int64) ([]models.ScheduleRun, error) {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	runs, err := loadScheduleRuns(athleteID)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := false
+	for i := range runs {
+		if runs[i].IsFailed() && !runs[i].Seen {
+			runs[i].Seen = true
+			changed = true
+		}
+	}
+	if changed {
+		if err := userdata.Save(athleteID, SCHEDULERUNSFILE, runs); err != nil {
+			return nil, err
+		}
+	}
+	return runs, nil
+}
+
+// loadSchedules loads the schedules of an athlete
+func loadSchedules(athleteID int64) ([]models.Schedule, error) {
+	schedules := []models.Schedule{}
+	if err := userdata.Load(athleteID, SCHEDULESFILE, &schedules); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// loadScheduleRuns loads the run history of an athlete (newest run first)
+func loadScheduleRuns(athleteID int64) ([]models.ScheduleRun, error) {
+	runs := []models.ScheduleRun{}
+	if err := userdata.Load(athleteID, SCHEDULERUNSFILE, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// getScheduleRunFileName returns the name of the file exported by a run (e.g.
+// monatsbericht-2022-01-01-2022-01-31.xlsx, dates are omitted if not set)
+func getScheduleRunFileName(run models.ScheduleRun) string {
+	// Only ascii letters and digits are kept, so the name can be used in headers
+	name := strings.NewReplacer("ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss").Replace(strings.ToLower(run.ScheduleName))
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '-'
+	}, name)
+
+	for _, date := range []time.Time{run.From, run.To} {
+		if !date.IsZero() {
+			name += "-" + date.Format("2006-01-02")
+		}
+	}
+	return name + ".xlsx"
+}
@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/antihax/optional"
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Maximum page size of segment requests
+	SEGMENTSPERPAGE = 200
+)
+
+// GetSegmentsPage returns the page listing all starred segments
+func GetSegmentsPage(c *gin.Context) {
+	segments, err := getStarredSegments(c)
+	if err == errRateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.HTML(http.StatusOK, "segments", gin.H{
+		"segments": segments,
+	})
+}
+
+// getStarredSegments fetches all segments starred by the logged in athlete
+func getStarredSegments(c *gin.Context) ([]models.Segment, error) {
+	segments := []models.Segment{}
+	for page := 1; ; page++ {
+		// JSON response must be used instead of Object because coordinates are not supported
+		pageSegments := []models.Segment{}
+		query := url.Values{"page": {fmt.Sprint(page)}, "per_page": {fmt.Sprint(SEGMENTSPERPAGE)}}
+		if err := getStravaJSON(c, "/segments/starred?"+query.Encode(), &pageSegments); err != nil {
+			return nil, err
+		}
+
+		segments = append(segments, pageSegments...)
+		if len(pageSegments) < SEGMENTSPERPAGE {
+			return segments, nil
+		}
+	}
+}
+
+// getSegment fetches a single segment
+func getSegment(c *gin.Context, segmentID int64) (models.Segment, error) {
+	var segment models.Segment
+	err := getStravaJSON(c, fmt.Sprintf("/segments/%d", segmentID), &segment)
+	return segment, err
+}
+
+// getSegmentEfforts fetches all efforts of the logged in athlete on a segment in ascending order,
+// efforts of cached activities are used if the athlete has no subscription (the activity cache is
+// updated once and kept in cache, so it can be reused for further segments of the same export)
+func getSegmentEfforts(c *gin.Context, segmentID int64, cache *activityCache) ([]models.SegmentEffort, error) {
+	if *cache != nil {
+		return filterSegmentEfforts(*cache, segmentID), nil
+	}
+	efforts := []models.SegmentEffort{}
+	seen := map[int64]bool{}
+
+	for page := 1; ; page++ {
+		// JSON response must be used instead of Object because segment ids exceed int32
+		pageEfforts := []models.SegmentEffort{}
+		query := url.Values{
+			"segment_id": {fmt.Sprint(segmentID)},
+			"page":       {fmt.Sprint(page)},
+			"per_page":   {fmt.Sprint(SEGMENTSPERPAGE)},
+		}
+		err := getStravaJSON(c, "/segment_efforts?"+query.Encode(), &pageEfforts)
+		if statusErr, ok := err.(stravaStatusError); ok && (statusErr == http.StatusPaymentRequired || statusErr == http.StatusForbidden) {
+			updated, err := getUpdatedActivityCache(c)
+			if err != nil {
+				return nil, err
+			}
+			*cache = updated
+			return filterSegmentEfforts(updated, segmentID), nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Stop if pages are not supported and the same efforts are returned again
+		added := 0
+		for _, effort := range pageEfforts {
+			if !seen[effort.Id] {
+				seen[effort.Id] = true
+				efforts = append(efforts, effort)
+				added++
+			}
+		}
+		if len(pageEfforts) < SEGMENTSPERPAGE || added == 0 {
+			break
+		}
+	}
+
+	sort.Slice(efforts, func(i, j int) bool {
+		return efforts[i].StartDateLocal.Before(efforts[j].StartDateLocal)
+	})
+	return efforts, nil
+}
+
+// getUpdatedActivityCache returns the detailed activities of the logged in athlete, activities that
+// are not cached yet are fetched first
+func getUpdatedActivityCache(c *gin.Context) (activityCache, error) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := loadActivityCache(athleteID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add new activities to cache
+	athleteActivityOpts := swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts{
+		PerPage: optional.NewInt32(100),
+	}
+	rateLimitReached, errors := fetchActivities(c, athleteActivityOpts, fetchOptions{}, func(activities []models.Activity) error {
+		return updateActivityCache(c, athleteID, cache, activities)
+	})
+	if rateLimitReached {
+		return nil, errRateLimitReached
+	} else if len(errors) > 0 {
+		return nil, errors[0]
+	}
+	return cache, nil
+}
+
+// filterSegmentEfforts returns the efforts on a segment contained in the cached activities in
+// ascending order
+func filterSegmentEfforts(cache activityCache, segmentID int64) []models.SegmentEffort {
+	efforts := []models.SegmentEffort{}
+	for _, activity := range cache {
+		for _, effort := range activity.SegmentEfforts {
+			if effort.Segment.Id == segmentID {
+				efforts = append(efforts, effort)
+			}
+		}
+	}
+
+	sort.Slice(efforts, func(i, j int) bool {
+		return efforts[i].StartDateLocal.Before(efforts[j].StartDateLocal)
+	})
+	return efforts
+}
+
+// stravaStatusError is returned if the Strava api responds with an unexpected status code
+type stravaStatusError int
+
+func (e stravaStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", int(e))
+}
+
+// getStravaJSON fetches a resource of the Strava api and decodes the JSON response
+func getStravaJSON(c *gin.Context, path string, v interface{}) error {
+	// Get client from authentication middleware
+	client, exists := c.Get("client")
+	if !exists {
+		return fmt.Errorf("client not passed by authentication middleware")
+	}
+
+	resp, err := client.(*http.Client).Get("https://www.strava.com/api/v3" + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return errRateLimitReached
+	} else if resp.StatusCode != http.StatusOK {
+		return stravaStatusError(resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
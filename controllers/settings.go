@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	"github.com/aschbacd/strava-export/pkg/mailer"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// GetSettingsPage returns the settings page listing the personal api tokens, the email settings and
+// the storage target
+func GetSettingsPage(c *gin.Context) {
+	renderSettingsPage(c, c.Query("error"), "")
+}
+
+// CreateAPIToken creates a personal api token, it is only shown once
+func CreateAPIToken(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	name := strings.TrimSpace(c.PostForm("name"))
+	scope := c.PostForm("scope")
+	if name == "" || (scope != models.APITokenScopeRead && scope != models.APITokenScopeExport) {
+		c.Redirect(http.StatusFound, "/settings?error=invalid")
+		return
+	}
+
+	_, plain, err := createAPIToken(athleteID, name, scope)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	renderSettingsPage(c, "", plain)
+}
+
+// RevokeAPIToken removes a personal api token
+func RevokeAPIToken(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	if err := revokeAPIToken(athleteID, c.Param("id")); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/settings")
+}
+
+// renderSettingsPage renders the settings page including a newly created token
+func renderSettingsPage(c *gin.Context, errorType, newToken string) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	tokens, err := loadAPITokens(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created.After(tokens[j].Created)
+	})
+
+	mailSettings, err := loadMailSettings(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	storageSettings, err := loadStorageSettings(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.HTML(http.StatusOK, "settings", gin.H{
+		"tokens":         tokens,
+		"newToken":       newToken,
+		"mail":           mailSettings,
+		"mailConfigured": mailer.GetConfig().IsConfigured(),
+		"languages":      models.MailLanguages,
+		"deliveries":     models.MailDeliveries,
+		"storage":        &storageSettings,
+		"storageTypes":   models.StorageTypes,
+		"localStorage":   getLocalStorageDir() != "",
+		"storageTested":  c.Query("storage") == "ok",
+		"error":          errorType,
+		"message":        c.Query("message"),
+		"csrfToken":      c.GetString("csrfToken"),
+	})
+}
@@ -0,0 +1,275 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	"github.com/aschbacd/strava-export/pkg/storage"
+	"github.com/aschbacd/strava-export/pkg/userdata"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	STORAGESETTINGSFILE = "storage.json"
+	// File uploaded to check the storage settings
+	STORAGETESTFILE = "strava-export-test.txt"
+
+	errLocalStorageDisabled = errors.New("local storage is not enabled on the server")
+	errNoStorageTarget      = errors.New("no storage target configured")
+	errStorageFieldsMissing = errors.New("endpoint, bucket, access key and secret key are required")
+	errInvalidStorageURL    = errors.New("invalid webdav url")
+	errUnknownStorageType   = errors.New("unknown storage type")
+)
+
+// uploadError is returned if an export couldn't be uploaded to the storage target of an athlete
+type uploadError struct {
+	err error
+}
+
+func (e uploadError) Error() string {
+	return "failed to upload export: " + e.err.Error()
+}
+
+func (e uploadError) Unwrap() error {
+	return e.err
+}
+
+// UpdateStorageSettings sets the storage target of exports, stored secrets are kept if the fields are
+// left empty
+func UpdateStorageSettings(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	current, err := loadStorageSettings(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	settings := models.StorageSettings{
+		Type:      c.PostForm("type"),
+		Path:      strings.Trim(strings.TrimSpace(c.PostForm("path")), "/"),
+		Endpoint:  strings.TrimSpace(c.PostForm("endpoint")),
+		Region:    strings.TrimSpace(c.PostForm("region")),
+		Bucket:    strings.TrimSpace(c.PostForm("bucket")),
+		AccessKey: strings.TrimSpace(c.PostForm("access_key")),
+		SecretKey: c.PostForm("secret_key"),
+		PathStyle: c.PostForm("path_style") != "",
+		URL:       strings.TrimSpace(c.PostForm("url")),
+		Username:  strings.TrimSpace(c.PostForm("username")),
+		Password:  c.PostForm("password"),
+	}
+	if settings.SecretKey == "" {
+		settings.SecretKey = current.SecretKey
+	}
+	if settings.Password == "" {
+		settings.Password = current.Password
+	}
+
+	if err := validateStorageSettings(settings); err != nil {
+		logger.Warn(fmt.Sprintf("invalid storage settings of athlete %d: %s", athleteID, err.Error()))
+		c.Redirect(http.StatusFound, "/settings?"+url.Values{"error": {"storage"}, "message": {getStorageErrorMessage(err)}}.Encode())
+		return
+	}
+
+	if err := saveStorageSettings(athleteID, settings); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/settings")
+}
+
+// TestStorage uploads a small file to check the storage settings
+func TestStorage(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	settings, target, err := getAthleteStorage(athleteID)
+	if err == nil {
+		err = target.Put(path.Join(settings.Path, STORAGETESTFILE), []byte("strava-export\n"))
+	}
+	if err != nil {
+		logger.Warn(fmt.Sprintf("storage test of athlete %d failed: %s", athleteID, err.Error()))
+		c.Redirect(http.StatusFound, "/settings?"+url.Values{"error": {"storage"}, "message": {getStorageErrorMessage(err)}}.Encode())
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/settings?storage=ok")
+}
+
+// StoreExport creates the Excel report and uploads it to the storage target of the athlete, the
+// options are sent as form (same options as for downloads)
+func StoreExport(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	settings, err := loadStorageSettings(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	if settings.Type == models.StorageTypeNone {
+		c.Redirect(http.StatusFound, "/settings")
+		return
+	}
+
+	deliverExport(c, athleteID, "storage", func(run models.ScheduleRun, data []byte) error {
+		return storeExport(athleteID, run, data)
+	})
+}
+
+// loadStorageSettings loads the storage target of an athlete (none by default), the stored secrets
+// are decrypted
+func loadStorageSettings(athleteID int64) (models.StorageSettings, error) {
+	settings := models.StorageSettings{}
+	if err := userdata.Load(athleteID, STORAGESETTINGSFILE, &settings); err != nil {
+		return settings, err
+	}
+
+	var err error
+	if settings.SecretKey, err = userdata.Decrypt(settings.SecretKey); err != nil {
+		return settings, err
+	}
+	settings.Password, err = userdata.Decrypt(settings.Password)
+	return settings, err
+}
+
+// saveStorageSettings stores the storage target of an athlete, secrets are encrypted with the key of
+// the server
+func saveStorageSettings(athleteID int64, settings models.StorageSettings) error {
+	var err error
+	if settings.SecretKey, err = userdata.Encrypt(settings.SecretKey); err != nil {
+		return err
+	}
+	if settings.Password, err = userdata.Encrypt(settings.Password); err != nil {
+		return err
+	}
+	return userdata.Save(athleteID, STORAGESETTINGSFILE, settings)
+}
+
+// getAthleteStorage returns the storage target of an athlete
+func getAthleteStorage(athleteID int64) (models.StorageSettings, storage.Storage, error) {
+	settings, err := loadStorageSettings(athleteID)
+	if err != nil {
+		return settings, nil, err
+	}
+
+	switch settings.Type {
+	case models.StorageTypeLocal:
+		// Athletes can only write to their own directory
+		dir := getLocalStorageDir()
+		if dir == "" {
+			return settings, nil, errLocalStorageDisabled
+		}
+		return settings, storage.NewLocal(filepath.Join(dir, fmt.Sprint(athleteID))), nil
+	case models.StorageTypeS3:
+		return settings, storage.NewS3(settings.Endpoint, settings.Region, settings.Bucket, settings.AccessKey, settings.SecretKey, settings.PathStyle), nil
+	case models.StorageTypeWebDAV:
+		return settings, storage.NewWebDAV(settings.URL, settings.Username, settings.Password), nil
+	default:
+		return settings, nil, errNoStorageTarget
+	}
+}
+
+// storeExport uploads the file exported by a run to the storage target of the athlete
+func storeExport(athleteID int64, run models.ScheduleRun, data []byte) error {
+	settings, target, err := getAthleteStorage(athleteID)
+	if err != nil {
+		return uploadError{err}
+	}
+	if err := target.Put(path.Join(settings.Path, getScheduleRunFileName(run)), data); err != nil {
+		return uploadError{err}
+	}
+	return nil
+}
+
+// getStorageErrorMessage returns the message shown to the athlete if the storage settings are invalid
+// or an upload failed (responses of the storage aren't shown, so the storage settings can't be used to
+// probe other servers)
+func getStorageErrorMessage(err error) string {
+	var statusErr storage.StatusError
+	switch {
+	case errors.Is(err, errLocalStorageDisabled):
+		return "Lokaler Speicher ist auf dem Server nicht aktiviert"
+	case errors.Is(err, errNoStorageTarget):
+		return "Kein Speicherziel konfiguriert"
+	case errors.Is(err, errStorageFieldsMissing):
+		return "Bitte Endpunkt, Bucket, Access Key und Secret Key angeben"
+	case errors.Is(err, errInvalidStorageURL):
+		return "Bitte eine gültige WebDAV-URL angeben"
+	case errors.Is(err, errUnknownStorageType):
+		return "Unbekanntes Speicherziel"
+	case errors.Is(err, storage.ErrHostNotAllowed):
+		return "Das Speicherziel verwendet eine interne Adresse, die nicht erlaubt ist"
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("Das Speicherziel hat den Upload abgelehnt (Status %d)", int(statusErr))
+	default:
+		return "Das Speicherziel ist nicht erreichbar"
+	}
+}
+
+// validateStorageSettings checks if all fields required by the storage type are set
+func validateStorageSettings(settings models.StorageSettings) error {
+	isURL := func(value string) bool {
+		u, err := url.Parse(value)
+		return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	}
+
+	switch settings.Type {
+	case models.StorageTypeNone:
+		return nil
+	case models.StorageTypeLocal:
+		if getLocalStorageDir() == "" {
+			return errLocalStorageDisabled
+		}
+		return nil
+	case models.StorageTypeS3:
+		if !isURL(settings.Endpoint) || settings.Bucket == "" || settings.AccessKey == "" || settings.SecretKey == "" {
+			return errStorageFieldsMissing
+		}
+		if err := storage.CheckURL(settings.Endpoint); err != nil {
+			return fmt.Errorf("failed to check endpoint %s: %w", settings.Endpoint, err)
+		}
+		return nil
+	case models.StorageTypeWebDAV:
+		if !isURL(settings.URL) {
+			return errInvalidStorageURL
+		}
+		if err := storage.CheckURL(settings.URL); err != nil {
+			return fmt.Errorf("failed to check webdav url %s: %w", settings.URL, err)
+		}
+		return nil
+	default:
+		return errUnknownStorageType
+	}
+}
+
+// getLocalStorageDir returns the directory used for local storage targets (disabled if empty)
+func getLocalStorageDir() string {
+	return os.Getenv("STORAGE_DIR")
+}
@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antihax/optional"
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	"github.com/aschbacd/strava-export/pkg/parquet"
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Stream types requested for every activity
+	streamKeys = []string{
+		"time", "distance", "latlng", "altitude", "velocity_smooth", "heartrate",
+		"cadence", "watts", "temp", "moving", "grade_smooth",
+	}
+
+	// Columns of the streams export (one row per sample)
+	streamColumns = []parquet.Column{
+		{Name: "activity_id", Type: parquet.Int64},
+		{Name: "t", Type: parquet.Int32},
+		{Name: "timestamp", Type: parquet.Timestamp},
+		{Name: "distance", Type: parquet.Float, Optional: true},
+		{Name: "lat", Type: parquet.Double, Optional: true},
+		{Name: "lng", Type: parquet.Double, Optional: true},
+		{Name: "altitude", Type: parquet.Float, Optional: true},
+		{Name: "velocity_smooth", Type: parquet.Float, Optional: true},
+		{Name: "heartrate", Type: parquet.Int32, Optional: true},
+		{Name: "cadence", Type: parquet.Int32, Optional: true},
+		{Name: "watts", Type: parquet.Int32, Optional: true},
+		{Name: "temp", Type: parquet.Int32, Optional: true},
+		{Name: "moving", Type: parquet.Boolean, Optional: true},
+		{Name: "grade_smooth", Type: parquet.Float, Optional: true},
+	}
+)
+
+// streamWriter writes the rows of the streams export
+type streamWriter interface {
+	Write(row []interface{}) error
+	Close() error
+}
+
+// csvStreamWriter writes the rows of the streams export as CSV (null values are empty)
+type csvStreamWriter struct {
+	w *csv.Writer
+}
+
+// ExportStreams exports the streams of all activities in the date range as long table (CSV or
+// Parquet), activities without streams (e.g. manual activities) are skipped
+func ExportStreams(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "parquet" {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	athleteActivityOpts := swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts{
+		PerPage: optional.NewInt32(100),
+	}
+	if err := setAthleteActivitiesOpts(c, &athleteActivityOpts); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Written to a temporary file first, so the rate limit page can be returned
+	f, err := ioutil.TempFile("", "strava-streams")
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := newStreamWriter(f, format)
+	rateLimitReached, errors := fetchActivities(c, athleteActivityOpts, fetchOptions{}, func(activities []models.Activity) error {
+		for _, activity := range activities {
+			streams, err := getActivityStreams(c, activity.Id)
+			if err != nil {
+				return err
+			}
+			for _, row := range getStreamRows(activity, streams) {
+				if err := w.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if rateLimitReached || (len(errors) > 0 && errors[0] == errRateLimitReached) {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if len(errors) > 0 {
+		for _, err := range errors {
+			logger.Error(err.Error())
+		}
+		utils.ReturnErrorPage(c)
+		return
+	}
+	if err := w.Close(); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Expires", "0")
+	c.FileAttachment(f.Name(), "strava-streams."+format)
+}
+
+// streamSet contains the streams of an activity, coordinates are decoded separately because the
+// generated LatLng is an empty struct
+type streamSet struct {
+	swagger.StreamSet
+	Latlng *latLngStream `json:"latlng,omitempty"`
+}
+
+// latLngStream contains pairs of latitude and longitude
+type latLngStream struct {
+	Data [][]float64 `json:"data,omitempty"`
+}
+
+// getLatLng returns the coordinates of the streams (nil if the activity has no coordinates)
+func (s streamSet) getLatLng() [][]float64 {
+	if s.Latlng == nil {
+		return nil
+	}
+	return s.Latlng.Data
+}
+
+// getActivityStreams returns all streams of an activity (empty if the activity has no streams)
+func getActivityStreams(c *gin.Context, activityID int64) (streamSet, error) {
+	// JSON response must be used instead of Object because coordinates are not supported
+	streams := streamSet{}
+	path := fmt.Sprintf("/activities/%d/streams?keys=%s&key_by_type=true", activityID, strings.Join(streamKeys, ","))
+	err := getStravaJSON(c, path, &streams)
+
+	var statusErr stravaStatusError
+	if errors.As(err, &statusErr) && int(statusErr) == http.StatusNotFound {
+		return streamSet{}, nil
+	}
+	return streams, err
+}
+
+// getStreamRows returns a row per sample in the order of the stream columns, values of missing
+// streams are nil
+func getStreamRows(activity models.Activity, streams streamSet) [][]interface{} {
+	if streams.Time == nil {
+		return nil
+	}
+
+	rows := [][]interface{}{}
+	for i, t := range streams.Time.Data {
+		row := []interface{}{activity.Id, t, activity.Date.Add(time.Duration(t) * time.Second)}
+
+		var distance, lat, lng, altitude, velocity, heartrate, cadence, watts, temp, moving, grade interface{}
+		if streams.Distance != nil && i < len(streams.Distance.Data) {
+			distance = streams.Distance.Data[i]
+		}
+		if latlng := streams.getLatLng(); i < len(latlng) && len(latlng[i]) == 2 {
+			lat, lng = latlng[i][0], latlng[i][1]
+		}
+		if streams.Altitude != nil && i < len(streams.Altitude.Data) {
+			altitude = streams.Altitude.Data[i]
+		}
+		if streams.VelocitySmooth != nil && i < len(streams.VelocitySmooth.Data) {
+			velocity = streams.VelocitySmooth.Data[i]
+		}
+		if streams.Heartrate != nil && i < len(streams.Heartrate.Data) {
+			heartrate = streams.Heartrate.Data[i]
+		}
+		if streams.Cadence != nil && i < len(streams.Cadence.Data) {
+			cadence = streams.Cadence.Data[i]
+		}
+		if streams.Watts != nil && i < len(streams.Watts.Data) {
+			watts = streams.Watts.Data[i]
+		}
+		if streams.Temp != nil && i < len(streams.Temp.Data) {
+			temp = streams.Temp.Data[i]
+		}
+		if streams.Moving != nil && i < len(streams.Moving.Data) {
+			moving = streams.Moving.Data[i]
+		}
+		if streams.GradeSmooth != nil && i < len(streams.GradeSmooth.Data) {
+			grade = streams.GradeSmooth.Data[i]
+		}
+
+		rows = append(rows, append(row, distance, lat, lng, altitude, velocity, heartrate, cadence, watts, temp, moving, grade))
+	}
+	return rows
+}
+
+// newStreamWriter returns a writer for the format of the streams export
+func newStreamWriter(w io.Writer, format string) streamWriter {
+	if format == "parquet" {
+		return parquet.NewWriter(w, streamColumns)
+	}
+
+	csvWriter := csv.NewWriter(w)
+	header := []string{}
+	for _, column := range streamColumns {
+		header = append(header, column.Name)
+	}
+	csvWriter.Write(header)
+	return &csvStreamWriter{w: csvWriter}
+}
+
+// Write writes a row of the streams export
+func (cw *csvStreamWriter) Write(row []interface{}) error {
+	record := make([]string, len(row))
+	for i, value := range row {
+		switch v := value.(type) {
+		case nil:
+			record[i] = ""
+		case float32:
+			record[i] = strconv.FormatFloat(float64(v), 'f', -1, 32)
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case time.Time:
+			record[i] = v.UTC().Format(time.RFC3339)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return cw.w.Write(record)
+}
+
+// Close writes the buffered rows
+func (cw *csvStreamWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
@@ -0,0 +1,673 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antihax/optional"
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+	"github.com/aschbacd/strava-export/pkg/userdata"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/oauth2"
+)
+
+var (
+	// Team of a coach and coaches of an athlete
+	TEAMFILE    = "team.json"
+	COACHESFILE = "coaches.json"
+
+	TEAMCOMPARISONSHEETNAME = "Vergleich"
+
+	// Teams and coaches of two athletes are changed together
+	teamMutex sync.Mutex
+)
+
+type TeamController struct {
+	OAuthConfig oauth2.Config
+}
+
+// GetTeamPage returns the page listing the members of the team (coach) and the coaches of the athlete
+func GetTeamPage(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	team, err := loadTeam(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	sort.Slice(team.Members, func(i, j int) bool {
+		return strings.ToLower(team.Members[i].Name) < strings.ToLower(team.Members[j].Name)
+	})
+
+	// Invite links
+	invites := []gin.H{}
+	for _, invite := range team.Invites {
+		invites = append(invites, gin.H{
+			"link":   getTeamInviteLink(athleteID, invite.Code),
+			"expiry": invite.GetExpiry(),
+		})
+	}
+
+	coaches, err := loadCoaches(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.HTML(http.StatusOK, "team", gin.H{
+		"members":   team.Members,
+		"invites":   invites,
+		"coaches":   coaches,
+		"from":      c.Query("from"),
+		"to":        c.Query("to"),
+		"error":     c.Query("error"),
+		"csrfToken": c.GetString("csrfToken"),
+	})
+}
+
+// CreateTeamInvite creates a single-use link an athlete can open to join the team
+func CreateTeamInvite(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Name is shown to invited athletes
+	name, err := getAthleteName(c)
+	if err == errRateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	teamMutex.Lock()
+	defer teamMutex.Unlock()
+
+	team, err := loadTeam(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	team.CoachName = name
+	team.Invites = append(team.Invites, models.TeamInvite{
+		Code:    utils.GetRandomString(32),
+		Created: time.Now(),
+	})
+	if err := userdata.Save(athleteID, TEAMFILE, team); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/team")
+}
+
+// GetJoinTeamPage asks the athlete to confirm joining the team of a coach
+func GetJoinTeamPage(c *gin.Context) {
+	coachID, team, ok := getTeamInvite(c)
+	if !ok {
+		return
+	}
+
+	c.HTML(http.StatusOK, "team-join", gin.H{
+		"coach":     team.CoachName,
+		"action":    fmt.Sprintf("/team/join/%d/%s", coachID, c.Param("code")),
+		"csrfToken": c.GetString("csrfToken"),
+	})
+}
+
+// JoinTeam adds the athlete to the team of a coach, the invite can't be used again
+func JoinTeam(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	name, err := getAthleteName(c)
+	if err == errRateLimitReached {
+		c.Redirect(http.StatusFound, "/rate-limit")
+		return
+	} else if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	teamMutex.Lock()
+	defer teamMutex.Unlock()
+
+	coachID, team, ok := getTeamInvite(c)
+	if !ok {
+		return
+	}
+
+	// Remove invite
+	invites := []models.TeamInvite{}
+	for _, invite := range team.Invites {
+		if invite.Code != c.Param("code") {
+			invites = append(invites, invite)
+		}
+	}
+	team.Invites = invites
+
+	// Add member (joining again updates the name)
+	members := []models.TeamMember{{AthleteId: athleteID, Name: name, Joined: time.Now()}}
+	for _, member := range team.Members {
+		if member.AthleteId != athleteID {
+			members = append(members, member)
+		}
+	}
+	team.Members = members
+
+	if err := userdata.Save(coachID, TEAMFILE, team); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Add coach to the athlete, so access can be revoked later
+	coaches, err := loadCoaches(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	updated := []models.TeamCoach{{AthleteId: coachID, Name: team.CoachName, Joined: time.Now()}}
+	for _, coach := range coaches {
+		if coach.AthleteId != coachID {
+			updated = append(updated, coach)
+		}
+	}
+	if err := userdata.Save(athleteID, COACHESFILE, updated); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/team")
+}
+
+// RemoveTeamMember removes an athlete from the team of the coach
+func RemoveTeamMember(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	memberID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	if err := removeTeamMember(athleteID, memberID); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/team")
+}
+
+// LeaveTeam revokes the access of a coach to the activities of the athlete
+func LeaveTeam(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	coachID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	if err := removeTeamMember(coachID, athleteID); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/team")
+}
+
+// ExportTeam exports the activities of the selected members (one sheet per athlete) and a sheet
+// comparing their totals, activities are fetched using the stored token of each member
+func (tc *TeamController) ExportTeam(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	team, err := loadTeam(athleteID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Selected members (only members of the team)
+	members := []models.TeamMember{}
+	for _, value := range c.QueryArray("members") {
+		memberID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		if member, exists := team.GetMember(memberID); exists {
+			members = append(members, member)
+		}
+	}
+	query := url.Values{"from": {c.Query("from")}, "to": {c.Query("to")}}
+	if len(members) == 0 {
+		query.Set("error", "members")
+		c.Redirect(http.StatusFound, "/team?"+query.Encode())
+		return
+	}
+
+	// Same date range for all members
+	athleteActivityOpts := swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts{
+		PerPage: optional.NewInt32(100),
+	}
+	if err := setAthleteActivitiesOpts(c, &athleteActivityOpts); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	f := excelize.NewFile()
+	f.SetSheetName("Sheet1", TEAMCOMPARISONSHEETNAME)
+	sheetNames := map[string]bool{TEAMCOMPARISONSHEETNAME: true}
+	stats := []models.TeamMemberStats{}
+
+	for _, member := range members {
+		activities, err := tc.getMemberActivities(member.AthleteId, athleteActivityOpts)
+		if err == errRateLimitReached {
+			c.Redirect(http.StatusFound, "/rate-limit")
+			return
+		}
+
+		// Members that revoked access are shown on the comparison sheet
+		memberStats := getTeamMemberStats(member.Name, activities)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("failed to get activities of team member %d: %s", member.AthleteId, err.Error()))
+			memberStats.Error = "Aktivitäten konnten nicht abgerufen werden (Zugriff widerrufen?)"
+		}
+		stats = append(stats, memberStats)
+
+		sheet := getTeamSheetName(member.Name, sheetNames)
+		f.NewSheet(sheet)
+		if err := addTeamMemberSheet(f, sheet, activities); err != nil {
+			logger.Error(err.Error())
+			utils.ReturnErrorPage(c)
+			return
+		}
+	}
+
+	if err := addTeamComparisonSheet(f, stats, getTeamRangeLabel(c.Query("from"), c.Query("to"))); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Set headers to make file downloadable
+	c.Header("Content-Disposition", "attachment;filename=strava-team.xlsx")
+	c.Header("File-Name", "strava-team.xlsx")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Expires", "0")
+
+	// Write file to gin's response writer
+	f.Write(c.Writer)
+}
+
+// getMemberActivities gets the detailed activities of a member using its stored token
+func (tc *TeamController) getMemberActivities(athleteID int64, athleteActivityOpts swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts) ([]models.Activity, error) {
+	c, err := newAthleteContext(&tc.OAuthConfig, athleteID, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+
+	activities := []models.Activity{}
+	rateLimitReached, errors := fetchActivities(c, athleteActivityOpts, fetchOptions{Details: true}, func(page []models.Activity) error {
+		activities = append(activities, page...)
+		return nil
+	})
+	if rateLimitReached {
+		return nil, errRateLimitReached
+	} else if len(errors) > 0 {
+		for _, err := range errors[1:] {
+			logger.Error(err.Error())
+		}
+		return nil, errors[0]
+	}
+	return activities, nil
+}
+
+// addTeamMemberSheet writes the activities of a member (same columns as the activities sheet)
+func addTeamMemberSheet(f *excelize.File, sheet string, activities []models.Activity) error {
+	if err := setExcelValues(f, sheet, 1, activityColumns); err != nil {
+		return err
+	}
+	for i, activity := range activities {
+		if err := setExcelValues(f, sheet, i+2, getActivityValues(activity)); err != nil {
+			return err
+		}
+	}
+
+	// Format cells
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 22})
+	if err != nil {
+		return err
+	}
+	durationStyle, err := f.NewStyle(&excelize.Style{NumFmt: 21})
+	if err != nil {
+		return err
+	}
+
+	lastRow := len(activities) + 1
+	if err := f.SetCellStyle(sheet, "A1", "O1", headerStyle); err != nil {
+		return err
+	}
+	if lastRow > 1 {
+		if err := f.SetCellStyle(sheet, "A2", fmt.Sprintf("A%d", lastRow), dateStyle); err != nil {
+			return err
+		}
+		if err := f.SetCellStyle(sheet, "D2", fmt.Sprintf("D%d", lastRow), durationStyle); err != nil {
+			return err
+		}
+		if err := f.AutoFilter(sheet, "A1", fmt.Sprintf("O%d", lastRow), ""); err != nil {
+			return err
+		}
+	}
+
+	if err := f.SetColWidth(sheet, "A", "A", 16); err != nil {
+		return err
+	}
+	if err := f.SetColWidth(sheet, "B", "B", 40); err != nil {
+		return err
+	}
+	return f.SetColWidth(sheet, "C", "O", 14)
+}
+
+// addTeamComparisonSheet writes the totals of all members including ranks (calculated by formulas)
+func addTeamComparisonSheet(f *excelize.File, stats []models.TeamMemberStats, rangeLabel string) error {
+	sheet := TEAMCOMPARISONSHEETNAME
+	if err := setExcelValues(f, sheet, 1, []interface{}{"Team-Vergleich " + rangeLabel}); err != nil {
+		return err
+	}
+	if err := setExcelValues(f, sheet, 3, []interface{}{
+		"Athlet", "Aktivitäten", "Strecke [km]", "Zeit", "Höhenzunahme [m]", "Kalorien", "Ø Herzfrequenz", "Rang Strecke", "Rang Zeit", "Rang Höhenzunahme", "Hinweis",
+	}); err != nil {
+		return err
+	}
+
+	firstRow, lastRow := 4, len(stats)+3
+	for i, member := range stats {
+		row := i + firstRow
+		rank := func(col string) excelFormula {
+			return excelFormula(fmt.Sprintf("RANK(%s%d,$%s$%d:$%s$%d)", col, row, col, firstRow, col, lastRow))
+		}
+
+		if err := setExcelValues(f, sheet, row, []interface{}{
+			member.Name,
+			member.Activities,
+			member.Distance,
+			member.MovingTime,
+			member.ElevationGain,
+			member.Calories,
+			member.AverageHeartRate,
+			rank("C"),
+			rank("D"),
+			rank("E"),
+			member.Error,
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Format cells
+	titleStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true, Size: 14}})
+	if err != nil {
+		return err
+	}
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	durationStyle, err := f.NewStyle(&excelize.Style{NumFmt: 46})
+	if err != nil {
+		return err
+	}
+
+	if err := f.SetCellStyle(sheet, "A1", "A1", titleStyle); err != nil {
+		return err
+	}
+	if err := f.SetCellStyle(sheet, "A3", "K3", headerStyle); err != nil {
+		return err
+	}
+	if err := f.SetCellStyle(sheet, "D4", fmt.Sprintf("D%d", lastRow), durationStyle); err != nil {
+		return err
+	}
+	if err := f.SetColWidth(sheet, "A", "A", 25); err != nil {
+		return err
+	}
+	if err := f.SetColWidth(sheet, "B", "J", 18); err != nil {
+		return err
+	}
+	return f.SetColWidth(sheet, "K", "K", 60)
+}
+
+// getTeamMemberStats returns the totals of the activities of a member
+func getTeamMemberStats(name string, activities []models.Activity) models.TeamMemberStats {
+	stats := models.TeamMemberStats{Name: name, Activities: len(activities)}
+
+	var heartRateTime time.Duration
+	var heartRateSum float64
+	for _, activity := range activities {
+		stats.Distance += activity.Distance
+		stats.ElevationGain += activity.ElevationGain
+		stats.MovingTime += activity.Duration
+		stats.Calories += activity.Calories
+
+		if activity.AverageHeartRate > 0 {
+			heartRateSum += activity.AverageHeartRate * activity.Duration.Seconds()
+			heartRateTime += activity.Duration
+		}
+	}
+	if heartRateTime > 0 {
+		stats.AverageHeartRate = math.Round(heartRateSum/heartRateTime.Seconds()*10) / 10
+	}
+	stats.Distance = math.Round(stats.Distance*100) / 100
+	stats.ElevationGain = math.Round(stats.ElevationGain*100) / 100
+	return stats
+}
+
+// getTeamSheetName returns a unique valid sheet name for a member (max. 31 characters)
+func getTeamSheetName(name string, used map[string]bool) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" {
+		name = "Athlet"
+	}
+
+	sheet := truncateRunes(name, 31)
+	for i := 2; used[sheet]; i++ {
+		suffix := fmt.Sprintf(" (%d)", i)
+		sheet = truncateRunes(name, 31-len(suffix)) + suffix
+	}
+	used[sheet] = true
+	return sheet
+}
+
+// truncateRunes returns the first n characters of a string
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
+
+// getTeamRangeLabel returns the date range shown on the comparison sheet
+func getTeamRangeLabel(from, to string) string {
+	format := func(value, fallback string) string {
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return fallback
+		}
+		return date.Format("02.01.2006")
+	}
+	return format(from, "Beginn") + " bis " + format(to, "heute")
+}
+
+// getTeamInvite returns the coach and the team of the invite passed as parameters, the invalid invite
+// page is returned if it doesn't exist or expired
+func getTeamInvite(c *gin.Context) (int64, models.Team, bool) {
+	coachID, err := strconv.ParseInt(c.Param("coach"), 10, 64)
+	if err != nil {
+		c.HTML(http.StatusNotFound, "team-join", gin.H{"invalid": true})
+		return 0, models.Team{}, false
+	}
+
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return 0, models.Team{}, false
+	}
+
+	team, err := loadTeam(coachID)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return 0, models.Team{}, false
+	}
+
+	for _, invite := range team.Invites {
+		if invite.Code == c.Param("code") && coachID != athleteID {
+			return coachID, team, true
+		}
+	}
+
+	c.HTML(http.StatusNotFound, "team-join", gin.H{"invalid": true})
+	return 0, models.Team{}, false
+}
+
+// removeTeamMember removes an athlete from the team of a coach and the coach from the athlete
+func removeTeamMember(coachID, athleteID int64) error {
+	teamMutex.Lock()
+	defer teamMutex.Unlock()
+
+	team, err := loadTeam(coachID)
+	if err != nil {
+		return err
+	}
+	members := []models.TeamMember{}
+	for _, member := range team.Members {
+		if member.AthleteId != athleteID {
+			members = append(members, member)
+		}
+	}
+	team.Members = members
+	if err := userdata.Save(coachID, TEAMFILE, team); err != nil {
+		return err
+	}
+
+	coaches, err := loadCoaches(athleteID)
+	if err != nil {
+		return err
+	}
+	remaining := []models.TeamCoach{}
+	for _, coach := range coaches {
+		if coach.AthleteId != coachID {
+			remaining = append(remaining, coach)
+		}
+	}
+	return userdata.Save(athleteID, COACHESFILE, remaining)
+}
+
+// loadTeam loads the team of a coach, expired invites are removed
+func loadTeam(athleteID int64) (models.Team, error) {
+	team := models.Team{}
+	if err := userdata.Load(athleteID, TEAMFILE, &team); err != nil {
+		return team, err
+	}
+
+	invites := []models.TeamInvite{}
+	for _, invite := range team.Invites {
+		if !invite.IsExpired(time.Now()) {
+			invites = append(invites, invite)
+		}
+	}
+	team.Invites = invites
+	return team, nil
+}
+
+// loadCoaches loads the coaches of an athlete
+func loadCoaches(athleteID int64) ([]models.TeamCoach, error) {
+	coaches := []models.TeamCoach{}
+	if err := userdata.Load(athleteID, COACHESFILE, &coaches); err != nil {
+		return nil, err
+	}
+	return coaches, nil
+}
+
+// getTeamInviteLink returns the link an athlete opens to join a team
+func getTeamInviteLink(coachID int64, code string) string {
+	return fmt.Sprintf("%s/team/join/%d/%s", os.Getenv("BASE_URL"), coachID, code)
+}
+
+// getAthleteName returns the full name of the authenticated athlete
+func getAthleteName(c *gin.Context) (string, error) {
+	var athlete struct {
+		Firstname string `json:"firstname"`
+		Lastname  string `json:"lastname"`
+	}
+	if err := getStravaJSON(c, "/athlete", &athlete); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(athlete.Firstname + " " + athlete.Lastname), nil
+}
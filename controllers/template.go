@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/aschbacd/strava-export/pkg/logger"
+	"github.com/aschbacd/strava-export/pkg/userdata"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+var (
+	// Maximum size of uploaded templates
+	MAXTEMPLATESIZE int64 = 10 << 20
+)
+
+// GetTemplatePage returns the template page
+func GetTemplatePage(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.HTML(http.StatusOK, "template", gin.H{
+		"hasTemplate": userdata.Exists(athleteID, TEMPLATEFILE),
+		"error":       c.Query("error"),
+		"csrfToken":   c.GetString("csrfToken"),
+	})
+}
+
+// UploadTemplate validates and stores a workbook template
+func UploadTemplate(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Get uploaded file
+	fileHeader, err := c.FormFile("template")
+	if err != nil {
+		c.Redirect(http.StatusFound, "/template?error=missing")
+		return
+	}
+	if fileHeader.Size > MAXTEMPLATESIZE {
+		c.Redirect(http.StatusFound, "/template?error=size")
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Check if file is a workbook containing the activities placeholder
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/template?error=invalid")
+		return
+	}
+	if _, cells, err := findPlaceholder(f, placeholderActivities); err != nil || len(cells) == 0 {
+		c.Redirect(http.StatusFound, "/template?error=placeholder")
+		return
+	}
+
+	// Store template
+	if err := userdata.WriteFile(athleteID, TEMPLATEFILE, data); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/template")
+}
+
+// DeleteTemplate removes the workbook template
+func DeleteTemplate(c *gin.Context) {
+	athleteID, err := getContextAthleteID(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	if err := userdata.Delete(athleteID, TEMPLATEFILE); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/template")
+}
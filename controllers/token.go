@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/aschbacd/strava-export/pkg/userdata"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+var (
+	TOKENFILE = "token.json"
+)
+
+// saveAthleteToken stores the oauth token of an athlete, so requests can be sent without a session
+// (e.g. for webhook events)
+func saveAthleteToken(athleteID int64, token *oauth2.Token) error {
+	return userdata.Save(athleteID, TOKENFILE, token)
+}
+
+// getAthleteTokenSource returns a token source using the stored token of an athlete, refreshed tokens
+// are stored again
+func getAthleteTokenSource(config *oauth2.Config, athleteID int64) (oauth2.TokenSource, error) {
+	if !userdata.Exists(athleteID, TOKENFILE) {
+		return nil, fmt.Errorf("no token stored for athlete %d", athleteID)
+	}
+
+	var token oauth2.Token
+	if err := userdata.Load(athleteID, TOKENFILE, &token); err != nil {
+		return nil, err
+	}
+
+	// Refresh token if necessary (Strava may return a new refresh token)
+	tokenSource := config.TokenSource(context.Background(), &token)
+	current, err := tokenSource.Token()
+	if err != nil {
+		return nil, err
+	}
+	if current.AccessToken != token.AccessToken {
+		if err := saveAthleteToken(athleteID, current); err != nil {
+			return nil, err
+		}
+	}
+
+	return oauth2.ReuseTokenSource(current, tokenSource), nil
+}
+
+// getAthleteClient returns a client authenticated with the stored token of an athlete
+func getAthleteClient(config *oauth2.Config, athleteID int64) (*http.Client, error) {
+	tokenSource, err := getAthleteTokenSource(config, athleteID)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.NewClient(context.Background(), tokenSource), nil
+}
+
+// newAthleteContext creates a context containing the same values as a request of the athlete (options
+// as query and the client set by the authentication middleware), so handlers can be reused without a
+// session (e.g. for scheduled exports)
+func newAthleteContext(config *oauth2.Config, athleteID int64, query url.Values) (*gin.Context, error) {
+	request, err := http.NewRequest(http.MethodGet, "/?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenSource, err := getAthleteTokenSource(config, athleteID)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &gin.Context{Request: request}
+	c.Set("tokenSource", tokenSource)
+	c.Set("client", oauth2.NewClient(context.Background(), tokenSource))
+	c.Set("athleteId", athleteID)
+	return c, nil
+}
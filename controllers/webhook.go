@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	"github.com/aschbacd/strava-export/pkg/userdata"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+var (
+	// Url of the Strava push subscription api
+	PUSHSUBSCRIPTIONSURL = "https://www.strava.com/api/v3/push_subscriptions"
+	// Number of events waiting to be processed
+	WEBHOOKQUEUESIZE = 1000
+)
+
+type WebhookController struct {
+	OAuthConfig oauth2.Config
+	// Token sent by Strava when the subscription is validated
+	VerifyToken string
+
+	events chan models.WebhookEvent
+	// Id of the registered subscription, events of other subscriptions are rejected
+	subscriptionID int64
+}
+
+// NewWebhookController creates a webhook controller and starts processing events in the background
+func NewWebhookController(config oauth2.Config, verifyToken string) *WebhookController {
+	wc := &WebhookController{
+		OAuthConfig: config,
+		VerifyToken: verifyToken,
+		events:      make(chan models.WebhookEvent, WEBHOOKQUEUESIZE),
+	}
+	go wc.processEvents()
+	return wc
+}
+
+// SetSubscriptionID sets the id of the subscription events are accepted from (set automatically when
+// the subscription is registered)
+func (wc *WebhookController) SetSubscriptionID(subscriptionID int64) {
+	atomic.StoreInt64(&wc.subscriptionID, subscriptionID)
+}
+
+// VerifySubscription answers the challenge sent by Strava when a subscription is created
+func (wc *WebhookController) VerifySubscription(c *gin.Context) {
+	if c.Query("hub.mode") != "subscribe" || c.Query("hub.verify_token") != wc.VerifyToken {
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"hub.challenge": c.Query("hub.challenge"),
+	})
+}
+
+// ReceiveEvent queues an event sent by Strava, the request has to be acknowledged within two
+// seconds, so events are processed in the background
+func (wc *WebhookController) ReceiveEvent(c *gin.Context) {
+	var event models.WebhookEvent
+	if err := c.BindJSON(&event); err != nil {
+		logger.Warn(err.Error())
+		return
+	}
+
+	// The endpoint is public, so only events of the registered subscription are accepted
+	if subscriptionID := atomic.LoadInt64(&wc.subscriptionID); subscriptionID == 0 || event.SubscriptionId != subscriptionID {
+		logger.Warn(fmt.Sprintf("rejecting event of unknown subscription %d", event.SubscriptionId))
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	select {
+	case wc.events <- event:
+	default:
+		logger.Warn(fmt.Sprintf("webhook queue full, dropping %s event of %s %d", event.AspectType, event.ObjectType, event.ObjectId))
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// RegisterSubscription creates a push subscription for the callback url (Strava allows a single
+// subscription per application), the server must already accept requests because the callback is
+// validated while the subscription is created
+func (wc *WebhookController) RegisterSubscription(callbackURL string) error {
+	subscriptions, err := wc.getSubscriptions()
+	if err != nil {
+		return err
+	}
+	for _, subscription := range subscriptions {
+		if subscription.CallbackURL == callbackURL {
+			logger.Info(fmt.Sprintf("webhook subscription %d already exists", subscription.Id))
+			wc.SetSubscriptionID(subscription.Id)
+			return nil
+		}
+		return fmt.Errorf("subscription %d with callback %s already exists", subscription.Id, subscription.CallbackURL)
+	}
+
+	resp, err := http.PostForm(PUSHSUBSCRIPTIONSURL, url.Values{
+		"client_id":     {wc.OAuthConfig.ClientID},
+		"client_secret": {wc.OAuthConfig.ClientSecret},
+		"callback_url":  {callbackURL},
+		"verify_token":  {wc.VerifyToken},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed to create webhook subscription (status %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var subscription models.WebhookSubscription
+	if err := json.NewDecoder(resp.Body).Decode(&subscription); err != nil {
+		return err
+	}
+	logger.Info(fmt.Sprintf("created webhook subscription %d", subscription.Id))
+	wc.SetSubscriptionID(subscription.Id)
+	return nil
+}
+
+// getSubscriptions returns the push subscriptions of the application
+func (wc *WebhookController) getSubscriptions() ([]models.WebhookSubscription, error) {
+	query := url.Values{
+		"client_id":     {wc.OAuthConfig.ClientID},
+		"client_secret": {wc.OAuthConfig.ClientSecret},
+	}
+	resp, err := http.Get(PUSHSUBSCRIPTIONSURL + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get webhook subscriptions (status %d)", resp.StatusCode)
+	}
+
+	subscriptions := []models.WebhookSubscription{}
+	err = json.NewDecoder(resp.Body).Decode(&subscriptions)
+	return subscriptions, err
+}
+
+// processEvents handles queued events one after another
+func (wc *WebhookController) processEvents() {
+	for event := range wc.events {
+		if err := wc.processEvent(event); err != nil {
+			logger.Error(fmt.Sprintf("failed to process %s event of %s %d: %s", event.AspectType, event.ObjectType, event.ObjectId, err.Error()))
+		}
+	}
+}
+
+// processEvent updates the activity cache of the owner (details of created and updated activities
+// are fetched again) or removes all data of athletes that revoked access
+func (wc *WebhookController) processEvent(event models.WebhookEvent) error {
+	// Remove all data of the athlete (tokens can't be used anymore), the event alone isn't trusted
+	if event.IsDeauthorization() {
+		if !userdata.Exists(event.OwnerId, TOKENFILE) {
+			return nil
+		}
+		revoked, err := wc.isAccessRevoked(event.OwnerId)
+		if err != nil {
+			return err
+		} else if !revoked {
+			logger.Warn(fmt.Sprintf("ignoring deauthorization of athlete %d, stored token is still valid", event.OwnerId))
+			return nil
+		}
+		logger.Info(fmt.Sprintf("athlete %d revoked access, removing data", event.OwnerId))
+		return userdata.DeleteAll(event.OwnerId)
+	}
+	if event.ObjectType != "activity" {
+		return nil
+	}
+
+	// Only athletes that logged in before have a local activity store
+	if !userdata.Exists(event.OwnerId, TOKENFILE) {
+		logger.Info(fmt.Sprintf("ignoring event of unknown athlete %d", event.OwnerId))
+		return nil
+	}
+
+	// Details are fetched before the cache is locked, the outdated activity is removed if they can't
+	// be fetched now (it is added again by the next export using the cache)
+	var details models.ActivityDetails
+	var err error
+	fetch := event.AspectType == "create" || event.AspectType == "update"
+	if fetch {
+		client, clientErr := getAthleteClient(&wc.OAuthConfig, event.OwnerId)
+		if clientErr != nil {
+			return clientErr
+		}
+		details, err = requestActivityDetails(client, event.ObjectId)
+	}
+
+	_, saveErr := modifyActivityCache(event.OwnerId, func(cache activityCache) {
+		delete(cache, event.ObjectId)
+		if fetch && err == nil {
+			cache[event.ObjectId] = models.CachedActivity{
+				Id:             event.ObjectId,
+				Name:           details.Name,
+				Type:           details.Type,
+				DateLocal:      details.StartDateLocal,
+				BestEfforts:    details.BestEfforts,
+				SegmentEfforts: details.SegmentEfforts,
+			}
+		}
+	})
+	if saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// isAccessRevoked checks with Strava if the stored token of an athlete was revoked
+func (wc *WebhookController) isAccessRevoked(athleteID int64) (bool, error) {
+	client, err := getAthleteClient(&wc.OAuthConfig, athleteID)
+	if err == nil {
+		var resp *http.Response
+		if resp, err = client.Get("https://www.strava.com/api/v3/athlete"); err == nil {
+			resp.Body.Close()
+			switch resp.StatusCode {
+			case http.StatusOK:
+				return false, nil
+			case http.StatusUnauthorized:
+				return true, nil
+			default:
+				return false, fmt.Errorf("failed to check access of athlete %d (status %d)", athleteID, resp.StatusCode)
+			}
+		}
+	}
+
+	// Refreshing a revoked token fails
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) && retrieveErr.Response != nil && (retrieveErr.Response.StatusCode == http.StatusBadRequest || retrieveErr.Response.StatusCode == http.StatusUnauthorized) {
+		return true, nil
+	}
+	return false, err
+}
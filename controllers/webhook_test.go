@@ -0,0 +1,282 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/userdata"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+const (
+	testVerifyToken    = "verify-token"
+	testSubscriptionID = 42
+	testAthleteID      = 1001
+)
+
+// fakeStrava answers requests sent to the Strava api during a test
+type fakeStrava struct {
+	athleteStatus int
+	activities    map[int64]models.ActivityDetails
+}
+
+// RoundTrip returns the response of the fake api
+func (f *fakeStrava) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	switch {
+	case req.URL.Path == "/api/v3/athlete":
+		recorder.WriteHeader(f.athleteStatus)
+	case strings.HasPrefix(req.URL.Path, "/api/v3/activities/"):
+		for id, details := range f.activities {
+			if req.URL.Path == "/api/v3/activities/"+fmt.Sprint(id) {
+				json.NewEncoder(recorder).Encode(details)
+				return recorder.Result(), nil
+			}
+		}
+		recorder.WriteHeader(http.StatusNotFound)
+	default:
+		recorder.WriteHeader(http.StatusNotFound)
+	}
+	return recorder.Result(), nil
+}
+
+// jsonString returns the json encoding of a value
+func jsonString(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// newTestWebhookController returns a webhook controller without background processing, a stored
+// token of the test athlete and a fake Strava api
+func newTestWebhookController(t *testing.T) (*WebhookController, *fakeStrava) {
+	t.Setenv("DATA_DIR", t.TempDir())
+	gin.SetMode(gin.TestMode)
+
+	strava := &fakeStrava{athleteStatus: http.StatusOK, activities: map[int64]models.ActivityDetails{}}
+	transport := http.DefaultTransport
+	http.DefaultTransport = strava
+	t.Cleanup(func() { http.DefaultTransport = transport })
+
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}
+	if err := saveAthleteToken(testAthleteID, token); err != nil {
+		t.Fatal(err)
+	}
+
+	wc := &WebhookController{VerifyToken: testVerifyToken, events: make(chan models.WebhookEvent, 1)}
+	wc.SetSubscriptionID(testSubscriptionID)
+	return wc, strava
+}
+
+// newTestWebhookRouter returns a router with the webhook routes
+func newTestWebhookRouter(wc *WebhookController) *gin.Engine {
+	r := gin.New()
+	r.GET("/webhook", wc.VerifySubscription)
+	r.POST("/webhook", wc.ReceiveEvent)
+	return r
+}
+
+func TestVerifySubscription(t *testing.T) {
+	wc, _ := newTestWebhookController(t)
+	r := newTestWebhookRouter(wc)
+
+	tests := []struct {
+		name        string
+		verifyToken string
+		status      int
+	}{
+		{"valid token", testVerifyToken, http.StatusOK},
+		{"invalid token", "wrong", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		query := url.Values{
+			"hub.mode":         {"subscribe"},
+			"hub.challenge":    {"challenge"},
+			"hub.verify_token": {test.verifyToken},
+		}
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/webhook?"+query.Encode(), nil))
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, recorder.Code)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		var answer map[string]string
+		if err := json.NewDecoder(recorder.Body).Decode(&answer); err != nil || answer["hub.challenge"] != "challenge" {
+			t.Errorf("%s: challenge not returned: %v", test.name, answer)
+		}
+	}
+}
+
+func TestReceiveEvent(t *testing.T) {
+	wc, _ := newTestWebhookController(t)
+	r := newTestWebhookRouter(wc)
+
+	tests := []struct {
+		name           string
+		subscriptionID int64
+		status         int
+	}{
+		{"unknown subscription", testSubscriptionID + 1, http.StatusForbidden},
+		{"missing subscription", 0, http.StatusForbidden},
+		{"registered subscription", testSubscriptionID, http.StatusOK},
+	}
+	for _, test := range tests {
+		event := models.WebhookEvent{
+			ObjectType:     "activity",
+			ObjectId:       1,
+			AspectType:     "create",
+			OwnerId:        testAthleteID,
+			SubscriptionId: test.subscriptionID,
+		}
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader([]byte(jsonString(event)))))
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, recorder.Code)
+		}
+		queued := len(wc.events) == 1
+		if queued != (test.status == http.StatusOK) {
+			t.Errorf("%s: event queued %t", test.name, queued)
+		}
+	}
+}
+
+func TestProcessActivityEvents(t *testing.T) {
+	wc, strava := newTestWebhookController(t)
+	strava.activities[1] = models.ActivityDetails{Id: 1, Name: "Morning Run", Type: "Run"}
+
+	event := func(aspectType string) models.WebhookEvent {
+		return models.WebhookEvent{
+			ObjectType:     "activity",
+			ObjectId:       1,
+			AspectType:     aspectType,
+			OwnerId:        testAthleteID,
+			SubscriptionId: testSubscriptionID,
+		}
+	}
+
+	// Create
+	if err := wc.processEvent(event("create")); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := loadActivityCache(testAthleteID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache[1].Name != "Morning Run" {
+		t.Fatalf("created activity not cached: %v", cache)
+	}
+
+	// Update
+	strava.activities[1] = models.ActivityDetails{Id: 1, Name: "Evening Run", Type: "Run"}
+	if err := wc.processEvent(event("update")); err != nil {
+		t.Fatal(err)
+	}
+	if cache, err = loadActivityCache(testAthleteID); err != nil {
+		t.Fatal(err)
+	}
+	if cache[1].Name != "Evening Run" {
+		t.Fatalf("updated activity not cached: %v", cache)
+	}
+
+	// Delete
+	delete(strava.activities, 1)
+	if err := wc.processEvent(event("delete")); err != nil {
+		t.Fatal(err)
+	}
+	if cache, err = loadActivityCache(testAthleteID); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := cache[1]; exists {
+		t.Fatalf("deleted activity still cached: %v", cache)
+	}
+}
+
+func TestProcessDeauthorization(t *testing.T) {
+	wc, strava := newTestWebhookController(t)
+
+	event := models.WebhookEvent{
+		ObjectType:     "athlete",
+		ObjectId:       testAthleteID,
+		AspectType:     "update",
+		Updates:        map[string]interface{}{"authorized": "false"},
+		OwnerId:        testAthleteID,
+		SubscriptionId: testSubscriptionID,
+	}
+
+	// Token is still valid, data must not be removed
+	if err := wc.processEvent(event); err != nil {
+		t.Fatal(err)
+	}
+	if !userdata.Exists(testAthleteID, TOKENFILE) {
+		t.Fatal("data removed although the token is still valid")
+	}
+
+	// Token was revoked
+	strava.athleteStatus = http.StatusUnauthorized
+	if err := wc.processEvent(event); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(userdata.GetDataDir(), "users", fmt.Sprint(testAthleteID))); !os.IsNotExist(err) {
+		t.Fatal("data not removed after access was revoked")
+	}
+}
+
+func TestActivityCacheKeepsEvents(t *testing.T) {
+	wc, strava := newTestWebhookController(t)
+	strava.activities[1] = models.ActivityDetails{Id: 1, Name: "Morning Run", Type: "Run"}
+	strava.activities[2] = models.ActivityDetails{Id: 2, Name: "Evening Run", Type: "Run"}
+	if _, err := modifyActivityCache(testAthleteID, func(cache activityCache) {
+		cache[3] = models.CachedActivity{Id: 3, Name: "Lunch Run"}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cache is loaded by an export before events are processed
+	cache, err := loadActivityCache(testAthleteID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []models.WebhookEvent{
+		{ObjectType: "activity", ObjectId: 1, AspectType: "create", OwnerId: testAthleteID},
+		{ObjectType: "activity", ObjectId: 3, AspectType: "delete", OwnerId: testAthleteID},
+	} {
+		if err := wc.processEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Export adds the details of another activity
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("client", http.DefaultClient)
+	if err := updateActivityCache(c, testAthleteID, cache, []models.Activity{{Id: 2, Name: "Evening Run", Type: "Run"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := loadActivityCache(testAthleteID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, expected := range map[int64]bool{1: true, 2: true, 3: false} {
+		if _, exists := stored[id]; exists != expected {
+			t.Errorf("activity %d: expected cached %t, got %v", id, expected, stored)
+		}
+		if _, exists := cache[id]; exists != expected {
+			t.Errorf("activity %d: expected %t in the cache of the export, got %v", id, expected, cache)
+		}
+	}
+}
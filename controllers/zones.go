@@ -0,0 +1,334 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/antihax/optional"
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+	"github.com/aschbacd/strava-export/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Number of weeks shown on the zones page if no start date is set
+	ZONESDEFAULTWEEKS = 12
+
+	// Zone types in the order they are displayed
+	zoneTypes = []struct {
+		Type  string
+		Label string
+	}{
+		{models.ZoneTypeHeartRate, "Herzfrequenz"},
+		{models.ZoneTypePower, "Leistung"},
+	}
+)
+
+// zoneWeek contains the time spent in every zone during a week
+type zoneWeek struct {
+	Start time.Time
+	Times map[string][]time.Duration
+}
+
+// zoneStats aggregates the time in zone of activities per week
+type zoneStats struct {
+	Weeks map[time.Time]*zoneWeek
+	// Zone ranges of the activities (used if the athlete zones are not available)
+	Ranges map[string][]models.ZoneRange
+}
+
+// newZoneStats creates empty zone stats
+func newZoneStats() *zoneStats {
+	return &zoneStats{
+		Weeks:  map[time.Time]*zoneWeek{},
+		Ranges: map[string][]models.ZoneRange{},
+	}
+}
+
+// Add adds the time in zone of an activity to its week
+func (s *zoneStats) Add(activity models.Activity) {
+	if len(activity.Zones) == 0 {
+		return
+	}
+
+	start := summaryPeriods[0].Start(activity.DateLocal)
+	week, exists := s.Weeks[start]
+	if !exists {
+		week = &zoneWeek{Start: start, Times: map[string][]time.Duration{}}
+		s.Weeks[start] = week
+	}
+
+	for _, zone := range activity.Zones {
+		times := week.Times[zone.Type]
+		for i, bucket := range zone.DistributionBuckets {
+			if i >= len(times) {
+				times = append(times, 0)
+			}
+			times[i] += bucket.GetDuration()
+		}
+		week.Times[zone.Type] = times
+
+		// Keep ranges containing the most zones
+		if len(zone.DistributionBuckets) > len(s.Ranges[zone.Type]) {
+			ranges := []models.ZoneRange{}
+			for _, bucket := range zone.DistributionBuckets {
+				ranges = append(ranges, models.ZoneRange{Min: bucket.Min, Max: bucket.Max})
+			}
+			s.Ranges[zone.Type] = ranges
+		}
+	}
+}
+
+// GetWeeks returns all weeks between the first and the last week containing zones in ascending order
+func (s *zoneStats) GetWeeks() []*zoneWeek {
+	starts := []time.Time{}
+	for start := range s.Weeks {
+		starts = append(starts, start)
+	}
+	if len(starts) == 0 {
+		return nil
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i].Before(starts[j])
+	})
+
+	weeks := []*zoneWeek{}
+	for start := starts[0]; !start.After(starts[len(starts)-1]); start = start.AddDate(0, 0, 7) {
+		week, exists := s.Weeks[start]
+		if !exists {
+			week = &zoneWeek{Start: start, Times: map[string][]time.Duration{}}
+		}
+		weeks = append(weeks, week)
+	}
+	return weeks
+}
+
+// GetLabels returns the labels of all zones of a type, the zones of the athlete are preferred
+func (s *zoneStats) GetLabels(zoneType string, athleteZones *models.AthleteZones) []string {
+	ranges := s.Ranges[zoneType]
+	if athleteZones != nil {
+		athleteRanges := athleteZones.HeartRate.Zones
+		if zoneType == models.ZoneTypePower {
+			athleteRanges = athleteZones.Power.Zones
+		}
+		if len(athleteRanges) == len(ranges) {
+			ranges = athleteRanges
+		}
+	}
+
+	labels := []string{}
+	for i, zoneRange := range ranges {
+		labels = append(labels, zoneRange.GetLabel(i))
+	}
+	return labels
+}
+
+// zonesChart is a stacked bar chart of the time in zone per week
+type zonesChart struct {
+	Title  string
+	Legend []zonesChartSegment
+	Weeks  []zonesChartWeek
+}
+
+type zonesChartWeek struct {
+	Label    string
+	Total    string
+	Segments []zonesChartSegment
+}
+
+type zonesChartSegment struct {
+	Label   string
+	Class   string
+	Percent float64
+}
+
+// GetZonesPage returns a page showing the time in heart rate and power zones per week
+func GetZonesPage(c *gin.Context) {
+	// Create activities api config
+	athleteActivityOpts := swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts{
+		PerPage: optional.NewInt32(100),
+	}
+
+	// Set timestamps for activities api config
+	if err := setAthleteActivitiesOpts(c, &athleteActivityOpts); err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Use last weeks by default
+	from := c.Query("from")
+	if from == "" {
+		start := summaryPeriods[0].Start(time.Now()).AddDate(0, 0, -7*(ZONESDEFAULTWEEKS-1))
+		from = start.Format("2006-01-02")
+		athleteActivityOpts.After = optional.NewInt32(int32(start.Add(-time.Second).Unix()))
+	}
+
+	// Get time in zone of all activities
+	stats := newZoneStats()
+	rateLimitReached, errors := fetchActivities(c, athleteActivityOpts, fetchOptions{Zones: true}, func(activities []models.Activity) error {
+		for _, activity := range activities {
+			stats.Add(activity)
+		}
+		return nil
+	})
+	if len(errors) > 0 || rateLimitReached {
+		// Log all errors
+		for _, err := range errors {
+			logger.Error(err.Error())
+		}
+
+		// Check if rate limit reached
+		if rateLimitReached {
+			c.Redirect(http.StatusFound, "/rate-limit")
+		} else {
+			utils.ReturnErrorPage(c)
+		}
+
+		return
+	}
+
+	// Get zones of athlete (labels)
+	athleteZones, err := getAthleteZones(c)
+	if err != nil {
+		logger.Error(err.Error())
+		utils.ReturnErrorPage(c)
+		return
+	}
+
+	// Create charts
+	weeks := stats.GetWeeks()
+	charts := []zonesChart{}
+
+	for _, zoneType := range zoneTypes {
+		labels := stats.GetLabels(zoneType.Type, athleteZones)
+		if len(labels) == 0 {
+			continue
+		}
+
+		chart := zonesChart{Title: zoneType.Label}
+		for i, label := range labels {
+			chart.Legend = append(chart.Legend, zonesChartSegment{Label: label, Class: fmt.Sprintf("zone-%d", i+1)})
+		}
+
+		// Bars are scaled relative to the longest week
+		var maxTotal time.Duration
+		for _, week := range weeks {
+			if total := sumDurations(week.Times[zoneType.Type]); total > maxTotal {
+				maxTotal = total
+			}
+		}
+
+		for _, week := range weeks {
+			times := week.Times[zoneType.Type]
+			chartWeek := zonesChartWeek{
+				Label: summaryPeriods[0].Label(week.Start),
+				Total: formatHours(sumDurations(times)),
+			}
+			for i, duration := range times {
+				if duration == 0 || maxTotal == 0 {
+					continue
+				}
+				chartWeek.Segments = append(chartWeek.Segments, zonesChartSegment{
+					Label:   fmt.Sprintf("%s: %s", chart.Legend[i].Label, formatHours(duration)),
+					Class:   chart.Legend[i].Class,
+					Percent: float64(duration*10000/maxTotal) / 100,
+				})
+			}
+			chart.Weeks = append(chart.Weeks, chartWeek)
+		}
+
+		charts = append(charts, chart)
+	}
+
+	// Return zones view
+	c.HTML(http.StatusOK, "zones", gin.H{
+		"charts": charts,
+		"from":   from,
+		"to":     c.Query("to"),
+	})
+}
+
+// getActivityZones fetches the heart rate and power zones of an activity, activities without zones
+// (e.g. if the athlete has no subscription) return an empty list
+func getActivityZones(c *gin.Context, activity models.Activity) ([]models.ActivityZone, error) {
+	// Get client from authentication middleware
+	client, exists := c.Get("client")
+	if !exists {
+		return nil, fmt.Errorf("client not passed by authentication middleware")
+	}
+
+	// JSON response must be used instead of Object because the distribution buckets are not supported
+	resp, err := client.(*http.Client).Get(fmt.Sprintf("https://www.strava.com/api/v3/activities/%d/zones", activity.Id))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusPaymentRequired, http.StatusForbidden, http.StatusNotFound:
+		return nil, nil
+	case http.StatusTooManyRequests:
+		return nil, errRateLimitReached
+	default:
+		return nil, fmt.Errorf("failed to get zones of activity %d (status %d)", activity.Id, resp.StatusCode)
+	}
+
+	zones := []models.ActivityZone{}
+	if err := json.NewDecoder(resp.Body).Decode(&zones); err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+// getAthleteZones fetches the heart rate and power zones of the logged in athlete, nil is returned if
+// the token doesn't contain the scope profile:read_all (sessions created before it was requested)
+func getAthleteZones(c *gin.Context) (*models.AthleteZones, error) {
+	// Get client from authentication middleware
+	client, exists := c.Get("client")
+	if !exists {
+		return nil, fmt.Errorf("client not passed by authentication middleware")
+	}
+
+	// JSON response must be used instead of Object because the zone ranges are not supported
+	resp, err := client.(*http.Client).Get("https://www.strava.com/api/v3/athlete/zones")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("failed to get athlete zones (status %d)", resp.StatusCode)
+	}
+
+	var zones models.AthleteZones
+	if err := json.NewDecoder(resp.Body).Decode(&zones); err != nil {
+		return nil, err
+	}
+	return &zones, nil
+}
+
+// sumDurations returns the sum of all durations
+func sumDurations(durations []time.Duration) time.Duration {
+	var sum time.Duration
+	for _, duration := range durations {
+		sum += duration
+	}
+	return sum
+}
+
+// formatHours formats a duration as hours and minutes (e.g. 1:05 h)
+func formatHours(duration time.Duration) string {
+	minutes := int(duration.Round(time.Minute).Minutes())
+	return fmt.Sprintf("%d:%02d h", minutes/60, minutes%60)
+}
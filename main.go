@@ -1,10 +1,16 @@
 package main
 
 import (
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/aschbacd/strava-export/commands"
 	"github.com/aschbacd/strava-export/controllers"
+	"github.com/aschbacd/strava-export/models"
+	"github.com/aschbacd/strava-export/pkg/logger"
 	"github.com/aschbacd/strava-export/pkg/utils"
 	"github.com/foolin/goview/supports/ginview"
 	"github.com/gin-contrib/sessions"
@@ -18,6 +24,15 @@ func main() {
 	// Load .env file (if exists)
 	godotenv.Load()
 
+	// Command line interface
+	if len(os.Args) > 1 {
+		if err := commands.Run(getOAuthConfig(), os.Args[1:]); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Debug logs
 	if os.Getenv("DEBUG") != "true" {
 		gin.SetMode(gin.ReleaseMode)
@@ -32,19 +47,18 @@ func main() {
 
 	// Session storage
 	store := cookie.NewStore([]byte(utils.GetRandomString(64)))
+	// Lax instead of strict, otherwise the session isn't sent when Strava redirects back after login
+	store.Options(sessions.Options{
+		Path:     "/",
+		MaxAge:   30 * 24 * 60 * 60,
+		Secure:   strings.HasPrefix(os.Getenv("BASE_URL"), "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
 	r.Use(sessions.Sessions("session", store))
 
 	// OAuth config
-	config := &oauth2.Config{
-		ClientID:     os.Getenv("STRAVA_CLIENT_ID"),
-		ClientSecret: os.Getenv("STRAVA_CLIENT_SECRET"),
-		Scopes:       []string{"activity:read_all"},
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  "https://www.strava.com/oauth/authorize",
-			TokenURL: "https://www.strava.com/oauth/token",
-		},
-		RedirectURL: os.Getenv("BASE_URL") + "/authenticate",
-	}
+	config := getOAuthConfig()
 
 	authController := controllers.AuthController{OAuthConfig: *config}
 
@@ -58,12 +72,116 @@ func main() {
 		c.HTML(http.StatusInternalServerError, "error", nil)
 	})
 
+	// Webhook (enabled if a verify token is set)
+	var webhookController *controllers.WebhookController
+	if verifyToken := os.Getenv("WEBHOOK_VERIFY_TOKEN"); verifyToken != "" {
+		webhookController = controllers.NewWebhookController(*config, verifyToken)
+		if subscriptionID, err := strconv.ParseInt(os.Getenv("WEBHOOK_SUBSCRIPTION_ID"), 10, 64); err == nil {
+			webhookController.SetSubscriptionID(subscriptionID)
+		}
+		r.GET("/webhook", webhookController.VerifySubscription)
+		r.POST("/webhook", webhookController.ReceiveEvent)
+	}
+
+	// Scheduled exports (run in the background using the stored tokens)
+	controllers.NewScheduleController(*config)
+
+	// Team exports (activities of members are fetched using their stored tokens)
+	teamController := controllers.TeamController{OAuthConfig: *config}
+
 	// Authenticated routes
 	auth := r.Group("")
-	auth.Use(authController.AuthMiddleware())
+	auth.Use(authController.AuthMiddleware(), controllers.CSRFMiddleware())
 	auth.GET("/", controllers.GetActivitiesPage)
 	auth.GET("/export", controllers.ExportData)
+	auth.POST("/export/mail", controllers.SendExport)
+	auth.POST("/export/storage", controllers.StoreExport)
+	auth.GET("/streams/export", controllers.ExportStreams)
+	auth.GET("/fit/export", controllers.ExportFIT)
+	auth.GET("/activities/:id/fit", controllers.DownloadActivityFIT)
+	auth.GET("/zones", controllers.GetZonesPage)
+	auth.GET("/gear", controllers.GetGearPage)
+	auth.POST("/gear/maintenance", controllers.AddMaintenanceInterval)
+	auth.POST("/gear/maintenance/:id/service", controllers.ServiceMaintenanceInterval)
+	auth.POST("/gear/maintenance/:id/delete", controllers.DeleteMaintenanceInterval)
+	auth.GET("/records", controllers.GetRecordsPage)
+	auth.GET("/records/export", controllers.ExportRecords)
+	auth.GET("/segments", controllers.GetSegmentsPage)
+	auth.GET("/segments/export", controllers.ExportSegments)
+	auth.GET("/routes", controllers.GetRoutesPage)
+	auth.GET("/routes/download", controllers.DownloadRoutes)
+	auth.GET("/routes/:id/:format", controllers.DownloadRoute)
+	auth.GET("/clubs", controllers.GetClubsPage)
+	auth.GET("/clubs/:id", controllers.GetClubPage)
+	auth.GET("/clubs/:id/export", controllers.ExportClub)
+	auth.GET("/import", controllers.GetImportPage)
+	auth.POST("/import", controllers.ImportFiles)
+	auth.GET("/edit", controllers.GetEditPage)
+	auth.POST("/edit", controllers.UploadEditWorkbook)
+	auth.GET("/edit/export", controllers.ExportEditWorkbook)
+	auth.POST("/edit/apply", controllers.ApplyEdits)
+	auth.POST("/edit/discard", controllers.DiscardEdits)
+	auth.GET("/edit/log", controllers.ExportAuditLog)
+	auth.GET("/template", controllers.GetTemplatePage)
+	auth.POST("/template", controllers.UploadTemplate)
+	auth.POST("/template/delete", controllers.DeleteTemplate)
+	auth.GET("/schedules", controllers.GetSchedulesPage)
+	auth.POST("/schedules", controllers.CreateSchedule)
+	auth.POST("/schedules/:id/run", controllers.RunScheduleNow)
+	auth.POST("/schedules/:id/delete", controllers.DeleteSchedule)
+	auth.GET("/schedules/runs/:id", controllers.DownloadScheduleRun)
+	auth.GET("/team", controllers.GetTeamPage)
+	auth.GET("/team/export", teamController.ExportTeam)
+	auth.POST("/team/invites", controllers.CreateTeamInvite)
+	auth.GET("/team/join/:coach/:code", controllers.GetJoinTeamPage)
+	auth.POST("/team/join/:coach/:code", controllers.JoinTeam)
+	auth.POST("/team/members/:id/remove", controllers.RemoveTeamMember)
+	auth.POST("/team/coaches/:id/remove", controllers.LeaveTeam)
+	auth.GET("/settings", controllers.GetSettingsPage)
+	auth.POST("/settings/tokens", controllers.CreateAPIToken)
+	auth.POST("/settings/mail", controllers.UpdateMailSettings)
+	auth.POST("/settings/storage", controllers.UpdateStorageSettings)
+	auth.POST("/settings/storage/test", controllers.TestStorage)
+	auth.POST("/settings/tokens/:id/revoke", controllers.RevokeAPIToken)
 	auth.POST("/logout", controllers.Logout)
 
-	r.Run(utils.GetEnv("ADDRESS", "localhost") + ":" + utils.GetEnv("PORT", "8080"))
+	// Api routes
+	api := r.Group("/api/v1")
+	api.Use(authController.AuthMiddleware())
+	api.GET("/activities", controllers.RequireAPIScope(models.APITokenScopeRead), controllers.GetAPIActivities)
+	api.GET("/activities/:id", controllers.RequireAPIScope(models.APITokenScopeRead), controllers.GetAPIActivity)
+	api.GET("/exports", controllers.RequireAPIScope(models.APITokenScopeExport), controllers.GetAPIExport)
+
+	// Listen before the webhook subscription is created (Strava validates the callback immediately)
+	listener, err := net.Listen("tcp", utils.GetEnv("ADDRESS", "localhost")+":"+utils.GetEnv("PORT", "8080"))
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	if webhookController != nil {
+		go func() {
+			if err := webhookController.RegisterSubscription(os.Getenv("BASE_URL") + "/webhook"); err != nil {
+				logger.Error(err.Error())
+			}
+		}()
+	}
+
+	r.RunListener(listener)
+}
+
+// getOAuthConfig returns the OAuth config of the Strava application
+func getOAuthConfig() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     os.Getenv("STRAVA_CLIENT_ID"),
+		ClientSecret: os.Getenv("STRAVA_CLIENT_SECRET"),
+		// Strava expects comma separated scopes (profile:read_all is required for zones, read_all for
+		// private routes and activity:write for imports and edits)
+		Scopes: []string{"read_all,activity:read_all,profile:read_all,activity:write"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://www.strava.com/oauth/authorize",
+			TokenURL: "https://www.strava.com/oauth/token",
+		},
+		RedirectURL: os.Getenv("BASE_URL") + "/authenticate",
+	}
 }
@@ -1,37 +1,54 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
 type Activity struct {
-	Id               int64
-	Date             time.Time
-	DateLocal        time.Time
-	Name             string
-	Distance         float64 // [km]
-	Duration         time.Duration
-	ElevationGain    float64 // [m]
-	AverageSpeed     float64 // [km/h]
-	MaxSpeed         float64 // [km/h]
-	AverageWatts     float64
-	MaxWatts         int32
-	Kilojoules       float64
-	AverageCadence   float64
-	AverageHeartRate float64
-	MaxHeartRate     float64
-	Calories         float64
-	GearName         string
+	Id               int64          `json:"id"`
+	Date             time.Time      `json:"start_date"`
+	DateLocal        time.Time      `json:"start_date_local"`
+	Name             string         `json:"name"`
+	Type             string         `json:"type"`
+	Distance         float64        `json:"distance"` // [km]
+	Duration         time.Duration  `json:"-"`
+	ElevationGain    float64        `json:"elevation_gain"` // [m]
+	AverageSpeed     float64        `json:"average_speed"`  // [km/h]
+	MaxSpeed         float64        `json:"max_speed"`      // [km/h]
+	AverageWatts     float64        `json:"average_watts"`
+	MaxWatts         int32          `json:"max_watts"`
+	Kilojoules       float64        `json:"kilojoules"`
+	AverageCadence   float64        `json:"average_cadence"`
+	AverageHeartRate float64        `json:"average_heartrate"`
+	MaxHeartRate     float64        `json:"max_heartrate"`
+	Calories         float64        `json:"calories"`
+	GearId           string         `json:"gear_id"`
+	GearName         string         `json:"gear_name"`
+	Commute          bool           `json:"commute"`
+	Trainer          bool           `json:"trainer"`
+	Laps             []Lap          `json:"laps,omitempty"`
+	SplitsMetric     []Split        `json:"splits_metric,omitempty"`
+	SplitsStandard   []Split        `json:"splits_standard,omitempty"`
+	Zones            []ActivityZone `json:"zones,omitempty"`
 }
 
 type ActivityDetails struct {
-	AverageCadence   float64 `json:"average_cadence"`
-	AverageHeartRate float64 `json:"average_heartrate"`
-	MaxHeartRate     float64 `json:"max_heartrate"`
-	Calories         float64 `json:"calories"`
+	Id               int64     `json:"id"`
+	Name             string    `json:"name"`
+	Type             string    `json:"type"`
+	StartDateLocal   time.Time `json:"start_date_local"`
+	AverageCadence   float64   `json:"average_cadence"`
+	AverageHeartRate float64   `json:"average_heartrate"`
+	MaxHeartRate     float64   `json:"max_heartrate"`
+	Calories         float64   `json:"calories"`
 	Gear             struct {
 		Name string `json:"name"`
 	} `json:"gear"`
+	SplitsMetric   []Split         `json:"splits_metric"`
+	SplitsStandard []Split         `json:"splits_standard"`
+	BestEfforts    []BestEffort    `json:"best_efforts"`
+	SegmentEfforts []SegmentEffort `json:"segment_efforts"`
 }
 
 func (a *Activity) GetDateString() string {
@@ -41,3 +58,12 @@ func (a *Activity) GetDateString() string {
 func (a *Activity) GetTimeString() string {
 	return a.DateLocal.Format("15:04:05")
 }
+
+// MarshalJSON encodes an activity, the duration is encoded in seconds
+func (a Activity) MarshalJSON() ([]byte, error) {
+	type activity Activity
+	return json.Marshal(struct {
+		activity
+		Duration int64 `json:"moving_time"` // [s]
+	}{activity(a), int64(a.Duration.Seconds())})
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+)
+
+// Scopes of personal api tokens
+const (
+	APITokenScopeRead   = "read"
+	APITokenScopeExport = "export"
+)
+
+type APIToken struct {
+	Id       string    `json:"id"`
+	Name     string    `json:"name"`
+	Scope    string    `json:"scope"`
+	Hash     string    `json:"hash"` // sha256 of the token
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// HasScope checks if a token grants a scope (export includes read)
+func (t *APIToken) HasScope(scope string) bool {
+	return t.Scope == scope || t.Scope == APITokenScopeExport
+}
+
+// GetScopeLabel returns the description of the token scope
+func (t *APIToken) GetScopeLabel() string {
+	if t.Scope == APITokenScopeExport {
+		return "Lesen und Exportieren"
+	}
+	return "Nur lesen"
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+type Club struct {
+	Id          int64
+	Name        string
+	SportType   string
+	City        string
+	MemberCount int32
+}
+
+// ClubActivity is an activity of a club member, Strava only returns the first name and the initial of
+// the last name of the athlete and no id or date
+type ClubActivity struct {
+	Athlete struct {
+		Firstname string `json:"firstname"`
+		Lastname  string `json:"lastname"`
+	} `json:"athlete"`
+	Name               string  `json:"name"`
+	Type               string  `json:"type"`
+	Distance           float64 `json:"distance"`     // [m]
+	MovingTime         int32   `json:"moving_time"`  // [s]
+	ElapsedTime        int32   `json:"elapsed_time"` // [s]
+	TotalElevationGain float64 `json:"total_elevation_gain"`
+}
+
+type ClubMemberStats struct {
+	Rank          int
+	Name          string
+	Activities    int
+	Distance      float64 // [km]
+	ElevationGain float64 // [m]
+	MovingTime    time.Duration
+}
+
+// GetAthleteName returns the name of the athlete as shown by Strava (e.g. Max M.)
+func (a *ClubActivity) GetAthleteName() string {
+	return GetClubMemberName(a.Athlete.Firstname, a.Athlete.Lastname)
+}
+
+// GetClubMemberName returns the name of a club member (last name abbreviated like in club activities)
+func GetClubMemberName(firstname, lastname string) string {
+	lastname = strings.TrimSpace(lastname)
+	if runes := []rune(lastname); len(runes) > 0 {
+		lastname = string(runes[0]) + "."
+	}
+	return strings.TrimSpace(strings.TrimSpace(firstname) + " " + lastname)
+}
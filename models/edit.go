@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+)
+
+type ActivityUpdate struct {
+	ActivityId   int64         `json:"activity_id"`
+	ActivityName string        `json:"activity_name"`
+	Changes      []FieldChange `json:"changes"`
+	// Flags after the update (always sent, so unchanged flags must be set to their current value)
+	Commute bool `json:"commute"`
+	Trainer bool `json:"trainer"`
+}
+
+type FieldChange struct {
+	Field    string `json:"field"` // name of the field in the api (e.g. gear_id)
+	Label    string `json:"label"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+	Value    string `json:"value"` // new value sent to the api (e.g. gear id instead of name)
+}
+
+type AuditEntry struct {
+	Time         time.Time     `json:"time"`
+	ActivityId   int64         `json:"activity_id"`
+	ActivityName string        `json:"activity_name"`
+	Changes      []FieldChange `json:"changes"`
+	Status       string        `json:"status"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// GetChange returns the change of a field and whether the field is changed
+func (u *ActivityUpdate) GetChange(field string) (FieldChange, bool) {
+	for _, change := range u.Changes {
+		if change.Field == field {
+			return change, true
+		}
+	}
+	return FieldChange{}, false
+}
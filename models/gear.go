@@ -0,0 +1,53 @@
+package models
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+type Gear struct {
+	Id        string
+	Name      string
+	BrandName string
+	ModelName string
+	Primary   bool
+	Distance  float64 // [km] (total)
+}
+
+type MaintenanceInterval struct {
+	Id              string    `json:"id"`
+	GearId          string    `json:"gear_id"`
+	Component       string    `json:"component"`
+	Interval        float64   `json:"interval"`         // [km]
+	ServiceDistance float64   `json:"service_distance"` // [km] gear distance at last service
+	ServiceDate     time.Time `json:"service_date"`
+}
+
+// GetType returns the type of a gear (bike ids start with b, shoe ids with g)
+func (g *Gear) GetType() string {
+	if strings.HasPrefix(g.Id, "b") {
+		return "Fahrrad"
+	}
+	return "Schuhe"
+}
+
+// GetDistanceSinceService returns the distance covered with a gear since the last service [km]
+func (m *MaintenanceInterval) GetDistanceSinceService(gear Gear) float64 {
+	return math.Round(math.Max(gear.Distance-m.ServiceDistance, 0)*100) / 100
+}
+
+// GetRemainingDistance returns the distance left until the next service [km]
+func (m *MaintenanceInterval) GetRemainingDistance(gear Gear) float64 {
+	return math.Round((m.Interval-m.GetDistanceSinceService(gear))*100) / 100
+}
+
+// IsDue checks if a component has to be serviced
+func (m *MaintenanceInterval) IsDue(gear Gear) bool {
+	return m.GetRemainingDistance(gear) <= 0
+}
+
+// IsDueSoon checks if less than 10 percent of the interval are left
+func (m *MaintenanceInterval) IsDueSoon(gear Gear) bool {
+	return !m.IsDue(gear) && m.GetRemainingDistance(gear) < m.Interval/10
+}
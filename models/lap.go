@@ -0,0 +1,32 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Lap struct {
+	Id             int64         `json:"id"`
+	ActivityId     int64         `json:"activity_id"`
+	ActivityName   string        `json:"activity_name"`
+	LapIndex       int32         `json:"lap_index"`
+	Name           string        `json:"name"`
+	DateLocal      time.Time     `json:"start_date_local"`
+	Distance       float64       `json:"distance"` // [m]
+	MovingTime     time.Duration `json:"-"`
+	ElapsedTime    time.Duration `json:"-"`
+	AverageSpeed   float64       `json:"average_speed"` // [m/s]
+	MaxSpeed       float64       `json:"max_speed"`     // [m/s]
+	AverageCadence float64       `json:"average_cadence"`
+	ElevationGain  float64       `json:"elevation_gain"` // [m]
+}
+
+// MarshalJSON encodes a lap, durations are encoded in seconds
+func (l Lap) MarshalJSON() ([]byte, error) {
+	type lap Lap
+	return json.Marshal(struct {
+		lap
+		MovingTime  int64 `json:"moving_time"`  // [s]
+		ElapsedTime int64 `json:"elapsed_time"` // [s]
+	}{lap(l), int64(l.MovingTime.Seconds()), int64(l.ElapsedTime.Seconds())})
+}
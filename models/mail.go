@@ -0,0 +1,27 @@
+package models
+
+// Delivery of exported files by email
+const (
+	MailDeliveryAttachment = "attachment"
+	MailDeliveryLink       = "link"
+)
+
+var (
+	// Languages of emails (templates exist for each language)
+	MailLanguages = []SelectOption{
+		{"de", "Deutsch"},
+		{"en", "English"},
+	}
+
+	// Labels of the delivery types
+	MailDeliveries = []SelectOption{
+		{MailDeliveryAttachment, "Als Anhang"},
+		{MailDeliveryLink, "Als Link"},
+	}
+)
+
+type MailSettings struct {
+	Address  string `json:"address"`
+	Language string `json:"language"`
+	Delivery string `json:"delivery"`
+}
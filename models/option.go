@@ -0,0 +1,27 @@
+package models
+
+// SelectOption is a value that can be selected in a form
+type SelectOption struct {
+	Value string
+	Label string
+}
+
+// IsSelectOption checks if a value is one of the options
+func IsSelectOption(options []SelectOption, value string) bool {
+	for _, option := range options {
+		if option.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSelectOptionLabel returns the label of a value (the value itself if it is unknown)
+func GetSelectOptionLabel(options []SelectOption, value string) string {
+	for _, option := range options {
+		if option.Value == value {
+			return option.Label
+		}
+	}
+	return value
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+)
+
+type BestEffort struct {
+	Name           string    `json:"name"`
+	Distance       float64   `json:"distance"`     // [m]
+	ElapsedTime    int32     `json:"elapsed_time"` // [s]
+	MovingTime     int32     `json:"moving_time"`  // [s]
+	StartDateLocal time.Time `json:"start_date_local"`
+}
+
+type CachedActivity struct {
+	Id             int64           `json:"id"`
+	Name           string          `json:"name"`
+	Type           string          `json:"type"`
+	DateLocal      time.Time       `json:"date_local"`
+	BestEfforts    []BestEffort    `json:"best_efforts"`
+	SegmentEfforts []SegmentEffort `json:"segment_efforts"`
+}
+
+type PersonalRecord struct {
+	Effort       BestEffort
+	Year         int
+	ActivityId   int64
+	ActivityName string
+	Improvement  time.Duration // compared to previous record
+}
+
+// GetDuration returns the elapsed time of an effort
+func (e *BestEffort) GetDuration() time.Duration {
+	return time.Duration(e.ElapsedTime) * time.Second
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+type Route struct {
+	Id                  int64     `json:"id"`
+	Name                string    `json:"name"`
+	Description         string    `json:"description"`
+	Distance            float64   `json:"distance"`       // [m]
+	ElevationGain       float64   `json:"elevation_gain"` // [m]
+	Type                int32     `json:"type"`           // 1 = ride, 2 = run
+	Private             bool      `json:"private"`
+	Starred             bool      `json:"starred"`
+	EstimatedMovingTime int32     `json:"estimated_moving_time"` // [s]
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+// GetTypeName returns the sport of a route
+func (r *Route) GetTypeName() string {
+	if r.Type == 2 {
+		return "Laufen"
+	}
+	return "Radfahren"
+}
+
+// GetDistance returns the distance of a route [km]
+func (r *Route) GetDistance() float64 {
+	return math.Round(r.Distance/10) / 100
+}
+
+// GetElevationGain returns the rounded elevation gain of a route [m]
+func (r *Route) GetElevationGain() float64 {
+	return math.Round(r.ElevationGain)
+}
+
+// GetEstimatedMovingTime returns the moving time estimated by Strava
+func (r *Route) GetEstimatedMovingTime() time.Duration {
+	return time.Duration(r.EstimatedMovingTime) * time.Second
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Relative date ranges of scheduled exports
+const (
+	ScheduleRangeLastWeek   = "last-week"
+	ScheduleRangeLastMonth  = "last-month"
+	ScheduleRangeYearToDate = "year-to-date"
+	ScheduleRangeLastYear   = "last-year"
+)
+
+// Destinations of scheduled exports
+const (
+	ScheduleDestinationDownload = "download"
+	ScheduleDestinationEmail    = "email"
+	ScheduleDestinationStorage  = "storage"
+)
+
+// Status of scheduled export runs
+const (
+	ScheduleRunSucceeded = "succeeded"
+	ScheduleRunFailed    = "failed"
+)
+
+var (
+	// Labels of the relative date ranges (in the order shown in the user interface)
+	ScheduleRanges = []SelectOption{
+		{ScheduleRangeLastWeek, "Letzte Woche"},
+		{ScheduleRangeLastMonth, "Letzter Monat"},
+		{ScheduleRangeYearToDate, "Jahr bis heute"},
+		{ScheduleRangeLastYear, "Letztes Jahr"},
+	}
+
+	// Labels of the destinations (in the order shown in the user interface)
+	ScheduleDestinations = []SelectOption{
+		{ScheduleDestinationDownload, "Verlauf (Download)"},
+		{ScheduleDestinationEmail, "E-Mail"},
+		{ScheduleDestinationStorage, "Speicherziel"},
+	}
+
+	// Labels of the export options stored in the query of a schedule
+	scheduleQueryLabels = []SelectOption{
+		{"charts", "Diagramme"},
+		{"laps", "Runden"},
+		{"splits", "Splits"},
+		{"zones", "Zonen"},
+		{"commute", "Nur Pendeln"},
+		{"template", "Vorlage"},
+	}
+)
+
+type Schedule struct {
+	Id          string    `json:"id"`
+	Name        string    `json:"name"`
+	Cron        string    `json:"cron"`  // e.g. "0 6 1 * *"
+	Range       string    `json:"range"` // e.g. last-month
+	Query       string    `json:"query"` // export options (e.g. laps=on&commute=on)
+	Destination string    `json:"destination"`
+	Created     time.Time `json:"created"`
+	LastRun     time.Time `json:"last_run"`
+	NextRun     time.Time `json:"next_run"`
+}
+
+type ScheduleRun struct {
+	Id           string    `json:"id"`
+	ScheduleId   string    `json:"schedule_id"`
+	ScheduleName string    `json:"schedule_name"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	Started      time.Time `json:"started"`
+	Finished     time.Time `json:"finished"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error"`
+	File         string    `json:"file"` // exported file in the data directory of the athlete
+	Seen         bool      `json:"seen"` // failure was shown to the athlete
+}
+
+// GetDateRange returns the first and the last day of the range relative to now
+func (s *Schedule) GetDateRange(now time.Time) (time.Time, time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch s.Range {
+	case ScheduleRangeLastWeek:
+		// Weeks start on monday
+		monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		return monday.AddDate(0, 0, -7), monday.AddDate(0, 0, -1)
+	case ScheduleRangeYearToDate:
+		return time.Date(today.Year(), 1, 1, 0, 0, 0, 0, today.Location()), today
+	case ScheduleRangeLastYear:
+		first := time.Date(today.Year()-1, 1, 1, 0, 0, 0, 0, today.Location())
+		return first, first.AddDate(1, 0, -1)
+	default:
+		first := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+		return first.AddDate(0, -1, 0), first.AddDate(0, 0, -1)
+	}
+}
+
+// GetRangeLabel returns the description of the date range
+func (s *Schedule) GetRangeLabel() string {
+	return GetSelectOptionLabel(ScheduleRanges, s.Range)
+}
+
+// GetDestinationLabel returns the description of the destination
+func (s *Schedule) GetDestinationLabel() string {
+	return GetSelectOptionLabel(ScheduleDestinations, s.Destination)
+}
+
+// GetOptionsLabel returns the description of the export options
+func (s *Schedule) GetOptionsLabel() string {
+	query, _ := url.ParseQuery(s.Query)
+
+	labels := []string{}
+	for _, option := range scheduleQueryLabels {
+		if query.Get(option.Value) != "" {
+			labels = append(labels, option.Label)
+		}
+	}
+	if query.Get("units") == ImperialUnits.Name {
+		labels = append(labels, "Imperial")
+	}
+	return strings.Join(labels, ", ")
+}
+
+// IsFailed checks if a run failed
+func (r *ScheduleRun) IsFailed() bool {
+	return r.Status == ScheduleRunFailed
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+)
+
+type Segment struct {
+	Id            int64   `json:"id"`
+	Name          string  `json:"name"`
+	ActivityType  string  `json:"activity_type"`
+	Distance      float64 `json:"distance"`      // [m]
+	AverageGrade  float64 `json:"average_grade"` // [%]
+	ElevationHigh float64 `json:"elevation_high"`
+	ElevationLow  float64 `json:"elevation_low"`
+	ClimbCategory int32   `json:"climb_category"`
+	City          string  `json:"city"`
+	Country       string  `json:"country"`
+}
+
+type SegmentEffort struct {
+	Id       int64 `json:"id"`
+	Activity struct {
+		Id int64 `json:"id"`
+	} `json:"activity"`
+	Segment struct {
+		Id   int64  `json:"id"`
+		Name string `json:"name"`
+	} `json:"segment"`
+	Name             string    `json:"name"`
+	StartDateLocal   time.Time `json:"start_date_local"`
+	ElapsedTime      int32     `json:"elapsed_time"` // [s]
+	MovingTime       int32     `json:"moving_time"`  // [s]
+	Distance         float64   `json:"distance"`     // [m]
+	AverageWatts     float64   `json:"average_watts"`
+	AverageHeartRate float64   `json:"average_heartrate"`
+	MaxHeartRate     float64   `json:"max_heartrate"`
+	PrRank           int32     `json:"pr_rank"` // 1-3 or 0 if not a personal record
+}
+
+// GetDuration returns the elapsed time of an effort
+func (e *SegmentEffort) GetDuration() time.Duration {
+	return time.Duration(e.ElapsedTime) * time.Second
+}
@@ -0,0 +1,11 @@
+package models
+
+type Split struct {
+	Split               int32   `json:"split"`
+	Distance            float64 `json:"distance"`             // [m]
+	ElapsedTime         int32   `json:"elapsed_time"`         // [s]
+	MovingTime          int32   `json:"moving_time"`          // [s]
+	ElevationDifference float64 `json:"elevation_difference"` // [m]
+	AverageSpeed        float64 `json:"average_speed"`        // [m/s]
+	PaceZone            int32   `json:"pace_zone"`
+}
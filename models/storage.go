@@ -0,0 +1,43 @@
+package models
+
+// Types of storage targets
+const (
+	StorageTypeNone   = ""
+	StorageTypeLocal  = "local"
+	StorageTypeS3     = "s3"
+	StorageTypeWebDAV = "webdav"
+)
+
+var (
+	// Labels of the storage types (in the order shown in the user interface)
+	StorageTypes = []SelectOption{
+		{StorageTypeNone, "Keins"},
+		{StorageTypeLocal, "Server (lokal)"},
+		{StorageTypeS3, "S3-kompatibel (z.B. MinIO)"},
+		{StorageTypeWebDAV, "WebDAV (z.B. Nextcloud)"},
+	}
+)
+
+type StorageSettings struct {
+	Type string `json:"type"`
+	// Directory inside the target (e.g. Finanzen/Pendeln)
+	Path string `json:"path"`
+
+	// S3-compatible
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	PathStyle bool   `json:"path_style"`
+
+	// WebDAV
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// GetTypeLabel returns the description of the storage type
+func (s *StorageSettings) GetTypeLabel() string {
+	return GetSelectOptionLabel(StorageTypes, s.Type)
+}
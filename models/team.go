@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+)
+
+var (
+	// Time until an invite link expires
+	TeamInviteLifetime = 7 * 24 * time.Hour
+)
+
+// Team contains the athletes linked to a coach
+type Team struct {
+	CoachName string       `json:"coach_name"`
+	Invites   []TeamInvite `json:"invites"`
+	Members   []TeamMember `json:"members"`
+}
+
+// TeamInvite is a single-use link an athlete opens to join a team
+type TeamInvite struct {
+	Code    string    `json:"code"`
+	Created time.Time `json:"created"`
+}
+
+type TeamMember struct {
+	AthleteId int64     `json:"athlete_id"`
+	Name      string    `json:"name"`
+	Joined    time.Time `json:"joined"`
+}
+
+// TeamCoach is a coach the athlete granted access to its activities
+type TeamCoach struct {
+	AthleteId int64     `json:"athlete_id"`
+	Name      string    `json:"name"`
+	Joined    time.Time `json:"joined"`
+}
+
+// TeamMemberStats contains the totals of a member shown on the comparison sheet
+type TeamMemberStats struct {
+	Name             string
+	Activities       int
+	Distance         float64 // [km]
+	ElevationGain    float64 // [m]
+	MovingTime       time.Duration
+	Calories         float64
+	AverageHeartRate float64 // weighted by moving time
+	Error            string  // activities couldn't be fetched (e.g. access revoked)
+}
+
+// GetExpiry returns the time the invite expires
+func (i *TeamInvite) GetExpiry() time.Time {
+	return i.Created.Add(TeamInviteLifetime)
+}
+
+// IsExpired checks if the invite can't be used anymore
+func (i *TeamInvite) IsExpired(now time.Time) bool {
+	return now.After(i.GetExpiry())
+}
+
+// GetMember returns a member of the team
+func (t *Team) GetMember(athleteID int64) (TeamMember, bool) {
+	for _, member := range t.Members {
+		if member.AthleteId == athleteID {
+			return member, true
+		}
+	}
+	return TeamMember{}, false
+}
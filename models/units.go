@@ -0,0 +1,70 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+type Units struct {
+	Name          string
+	DistanceUnit  string
+	SpeedUnit     string
+	PaceUnit      string
+	ElevationUnit string
+	// Meters per distance unit
+	distanceFactor float64
+	// Meters per elevation unit
+	elevationFactor float64
+}
+
+var (
+	MetricUnits = Units{
+		Name:            "metric",
+		DistanceUnit:    "km",
+		SpeedUnit:       "km/h",
+		PaceUnit:        "min/km",
+		ElevationUnit:   "m",
+		distanceFactor:  1000,
+		elevationFactor: 1,
+	}
+	ImperialUnits = Units{
+		Name:            "imperial",
+		DistanceUnit:    "mi",
+		SpeedUnit:       "mph",
+		PaceUnit:        "min/mi",
+		ElevationUnit:   "ft",
+		distanceFactor:  1609.344,
+		elevationFactor: 0.3048,
+	}
+)
+
+// GetUnits returns the units for a given name (metric is used by default)
+func GetUnits(name string) Units {
+	if name == ImperialUnits.Name {
+		return ImperialUnits
+	}
+	return MetricUnits
+}
+
+// Distance converts meters into the distance unit
+func (u Units) Distance(meters float64) float64 {
+	return math.Round(meters/u.distanceFactor*100) / 100
+}
+
+// Speed converts meters per second into the speed unit
+func (u Units) Speed(metersPerSecond float64) float64 {
+	return math.Round(metersPerSecond*3600/u.distanceFactor*100) / 100
+}
+
+// Pace converts meters per second into the time needed for one distance unit
+func (u Units) Pace(metersPerSecond float64) time.Duration {
+	if metersPerSecond <= 0 {
+		return 0
+	}
+	return time.Duration(u.distanceFactor/metersPerSecond) * time.Second
+}
+
+// Elevation converts meters into the elevation unit
+func (u Units) Elevation(meters float64) float64 {
+	return math.Round(meters/u.elevationFactor*100) / 100
+}
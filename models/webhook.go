@@ -0,0 +1,25 @@
+package models
+
+import (
+	"fmt"
+)
+
+type WebhookEvent struct {
+	ObjectType     string                 `json:"object_type"` // activity or athlete
+	ObjectId       int64                  `json:"object_id"`
+	AspectType     string                 `json:"aspect_type"` // create, update or delete
+	Updates        map[string]interface{} `json:"updates"`
+	OwnerId        int64                  `json:"owner_id"`
+	SubscriptionId int64                  `json:"subscription_id"`
+	EventTime      int64                  `json:"event_time"`
+}
+
+type WebhookSubscription struct {
+	Id          int64  `json:"id"`
+	CallbackURL string `json:"callback_url"`
+}
+
+// IsDeauthorization checks if an event is sent because the athlete revoked access
+func (e *WebhookEvent) IsDeauthorization() bool {
+	return e.ObjectType == "athlete" && fmt.Sprint(e.Updates["authorized"]) == "false"
+}
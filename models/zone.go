@@ -0,0 +1,52 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Zone types used by Strava
+const (
+	ZoneTypeHeartRate = "heartrate"
+	ZoneTypePower     = "power"
+)
+
+type ActivityZone struct {
+	Type                string       `json:"type"`
+	SensorBased         bool         `json:"sensor_based"`
+	DistributionBuckets []ZoneBucket `json:"distribution_buckets"`
+}
+
+type ZoneBucket struct {
+	Min  int32   `json:"min"`
+	Max  int32   `json:"max"`  // -1 for the last zone
+	Time float64 `json:"time"` // [s]
+}
+
+type ZoneRange struct {
+	Min int32 `json:"min"`
+	Max int32 `json:"max"` // -1 for the last zone
+}
+
+type AthleteZones struct {
+	HeartRate struct {
+		CustomZones bool        `json:"custom_zones"`
+		Zones       []ZoneRange `json:"zones"`
+	} `json:"heart_rate"`
+	Power struct {
+		Zones []ZoneRange `json:"zones"`
+	} `json:"power"`
+}
+
+// GetDuration returns the time spent in a zone
+func (b *ZoneBucket) GetDuration() time.Duration {
+	return time.Duration(b.Time * float64(time.Second))
+}
+
+// GetLabel returns the name of a zone including its range (e.g. Z2 (120-150))
+func (r *ZoneRange) GetLabel(index int) string {
+	if r.Max < 0 {
+		return fmt.Sprintf("Z%d (>%d)", index+1, r.Min)
+	}
+	return fmt.Sprintf("Z%d (%d-%d)", index+1, r.Min, r.Max)
+}
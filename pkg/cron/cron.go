@@ -0,0 +1,175 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Shortcuts for common expressions
+	aliases = map[string]string{
+		"@yearly":  "0 0 1 1 *",
+		"@monthly": "0 0 1 * *",
+		"@weekly":  "0 0 * * 1",
+		"@daily":   "0 0 * * *",
+		"@hourly":  "0 * * * *",
+	}
+
+	// Maximum time searched for the next matching minute (e.g. 29th of February)
+	maxSearch = 5 * 366 * 24 * time.Hour
+)
+
+// Schedule is a parsed cron expression (minute, hour, day of month, month and day of week)
+type Schedule struct {
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+
+	// Days and weekdays are combined with "or" if both are restricted, fields starting with "*" (e.g.
+	// "*/2") aren't restricted (like in cron)
+	daysRestricted     bool
+	weekdaysRestricted bool
+}
+
+// field describes the valid values of a field of an expression
+type field struct {
+	Name     string
+	Min, Max int
+}
+
+var (
+	fields = []field{
+		{"minute", 0, 59},
+		{"hour", 0, 23},
+		{"day of month", 1, 31},
+		{"month", 1, 12},
+		{"day of week", 0, 7}, // 0 and 7 are sunday
+	}
+)
+
+// Parse parses a cron expression with five fields (e.g. "0 6 1 * *" for 06:00 on the first of every
+// month), fields support lists (1,15), ranges (1-5) and steps (*/15)
+func Parse(expression string) (*Schedule, error) {
+	expression = strings.TrimSpace(expression)
+	if alias, exists := aliases[expression]; exists {
+		expression = alias
+	}
+
+	parts := strings.Fields(expression)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("expected %d fields but got %d", len(fields), len(parts))
+	}
+
+	values := []map[int]bool{}
+	for i, part := range parts {
+		value, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	// Sunday can be written as 0 or 7
+	if values[4][7] {
+		values[4][0] = true
+	}
+
+	return &Schedule{
+		minutes:            values[0],
+		hours:              values[1],
+		days:               values[2],
+		months:             values[3],
+		weekdays:           values[4],
+		daysRestricted:     !strings.HasPrefix(parts[2], "*"),
+		weekdaysRestricted: !strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+// Next returns the first time matching the schedule after t (seconds are ignored), the zero time is
+// returned if no time matches (e.g. 31st of February)
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	for end := t.Add(maxSearch); t.Before(end); {
+		if !s.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchesDay checks day of month and day of week
+func (s *Schedule) matchesDay(t time.Time) bool {
+	day := s.days[t.Day()]
+	weekday := s.weekdays[int(t.Weekday())]
+
+	if s.daysRestricted && s.weekdaysRestricted {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+// parseField returns all values matching a field of an expression
+func parseField(expression string, f field) (map[int]bool, error) {
+	values := map[int]bool{}
+
+	for _, part := range strings.Split(expression, ",") {
+		// Step
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in %s: %s", f.Name, part)
+			}
+			part = part[:i]
+		}
+
+		// Range
+		min, max := f.Min, f.Max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			var err error
+			if min, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid %s: %s", f.Name, part)
+			}
+			max = min
+			if len(bounds) == 2 {
+				if max, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid %s: %s", f.Name, part)
+				}
+			} else if step > 1 {
+				// e.g. 5/15 = 5-59/15
+				max = f.Max
+			}
+		}
+		if min < f.Min || max > f.Max || min > max {
+			return nil, fmt.Errorf("%s must be between %d and %d: %s", f.Name, f.Min, f.Max, part)
+		}
+
+		for value := min; value <= max; value += step {
+			values[value] = true
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,82 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expression string
+		valid      bool
+	}{
+		{"0 6 1 * *", true},
+		{"@daily", true},
+		{" @hourly ", true},
+		{"*/15 1,13 1-5 */2 1-5", true},
+		{"5/15 * * * 7", true},
+		{"0 0 31 2 *", true},
+		{"0 6 1 *", false},
+		{"0 6 1 * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"5-1 * * * *", false},
+		{"*/0 * * * *", false},
+		{"a * * * *", false},
+		{"1-a * * * *", false},
+	}
+	for _, test := range tests {
+		if _, err := Parse(test.expression); (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %t, got error %v", test.expression, test.valid, err)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// Wednesday
+	now := time.Date(2024, 1, 10, 10, 30, 20, 0, time.UTC)
+	date := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		next       time.Time
+	}{
+		{"next minute", "* * * * *", date(1, 10, 10, 31)},
+		{"step", "*/20 * * * *", date(1, 10, 10, 40)},
+		{"step with start", "5/20 * * * *", date(1, 10, 10, 45)},
+		{"list", "0 8,12 * * *", date(1, 10, 12, 0)},
+		{"range", "0 2-4 * * *", date(1, 11, 2, 0)},
+		{"range with step", "0 11-23/6 * * *", date(1, 10, 11, 0)},
+		{"alias", "@monthly", date(2, 1, 0, 0)},
+		{"day of month", "0 6 15 * *", date(1, 15, 6, 0)},
+		{"month", "0 0 1 3 *", date(3, 1, 0, 0)},
+		{"day of week", "0 6 * * 5", date(1, 12, 6, 0)},
+		{"sunday as 0", "0 6 * * 0", date(1, 14, 6, 0)},
+		{"sunday as 7", "0 6 * * 7", date(1, 14, 6, 0)},
+		{"range to sunday", "0 6 * * 6-7", date(1, 13, 6, 0)},
+		{"day of month or day of week", "0 6 20 * 5", date(1, 12, 6, 0)},
+		{"day of month or day of week (day first)", "0 6 11 * 1", date(1, 11, 6, 0)},
+		{"day of week with step isn't restricted", "0 6 20 * */3", date(1, 20, 6, 0)},
+		{"day of month with step isn't restricted", "0 6 */2 * 5", date(1, 19, 6, 0)},
+		{"leap day", "0 0 29 2 *", date(2, 29, 0, 0)},
+		{"31st", "0 0 31 * *", date(1, 31, 0, 0)},
+		{"31st of april never occurs", "0 0 31 4 *", time.Time{}},
+		{"31st of february never occurs", "0 0 31 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		schedule, err := Parse(test.expression)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if next := schedule.Next(now); !next.Equal(test.next) {
+			t.Errorf("%s (%q): expected %s, got %s", test.name, test.expression, test.next, next)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package fit
+
+import (
+	"math"
+	"time"
+
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+)
+
+// Values of enum fields
+const (
+	fileActivity            = 4
+	manufacturerDevelopment = 255
+	eventTimer              = 0
+	eventSession            = 8
+	eventLap                = 9
+	eventActivity           = 26
+	eventTypeStart          = 0
+	eventTypeStop           = 1
+	eventTypeStopAll        = 4
+	activityManual          = 0
+)
+
+var (
+	// Sports and sub sports of Strava activity types (generic if not listed)
+	sports = map[swagger.ActivityType][2]int{
+		swagger.ALPINE_SKI:        {13, 0},
+		swagger.BACKCOUNTRY_SKI:   {13, 37},
+		swagger.CANOEING:          {19, 0},
+		swagger.CROSSFIT:          {10, 0},
+		swagger.E_BIKE_RIDE:       {21, 0},
+		swagger.ELLIPTICAL:        {4, 15},
+		swagger.GOLF:              {25, 0},
+		swagger.HANDCYCLE:         {2, 12},
+		swagger.HIKE:              {17, 0},
+		swagger.ICE_SKATE:         {33, 0},
+		swagger.INLINE_SKATE:      {30, 0},
+		swagger.KAYAKING:          {41, 0},
+		swagger.KITESURF:          {44, 0},
+		swagger.NORDIC_SKI:        {12, 0},
+		swagger.RIDE:              {2, 0},
+		swagger.ROCK_CLIMBING:     {31, 0},
+		swagger.ROWING:            {15, 0},
+		swagger.RUN:               {1, 0},
+		swagger.SAIL:              {32, 0},
+		swagger.SNOWBOARD:         {14, 0},
+		swagger.SNOWSHOE:          {35, 0},
+		swagger.SOCCER:            {7, 0},
+		swagger.STAIR_STEPPER:     {4, 16},
+		swagger.STAND_UP_PADDLING: {37, 0},
+		swagger.SURFING:           {38, 0},
+		swagger.SWIM:              {5, 0},
+		swagger.VIRTUAL_RIDE:      {2, 58},
+		swagger.VIRTUAL_RUN:       {1, 58},
+		swagger.WALK:              {11, 0},
+		swagger.WEIGHT_TRAINING:   {10, 20},
+		swagger.WINDSURF:          {43, 0},
+		swagger.YOGA:              {10, 43},
+	}
+)
+
+// sampleStats contains values calculated from the streams of a lap or session
+type sampleStats struct {
+	AverageHeartRate interface{}
+	MaxHeartRate     interface{}
+	AveragePower     interface{}
+	MaxPower         interface{}
+	StartLat         interface{}
+	StartLng         interface{}
+	EndLat           interface{}
+	EndLng           interface{}
+}
+
+// EncodeActivity returns an activity file (file_id, event, record, lap, session and activity
+// messages), if there are no laps a single lap covering the activity is written. Coordinates are
+// passed as pairs of latitude and longitude, because the generated LatLng can't contain them.
+func EncodeActivity(activity swagger.DetailedActivity, laps []swagger.Lap, streams swagger.StreamSet, latlng [][]float64) ([]byte, error) {
+	start := activity.StartDate.UTC()
+	end := start.Add(time.Duration(activity.ElapsedTime) * time.Second)
+	samples := 0
+	if streams.Time != nil {
+		samples = len(streams.Time.Data)
+	}
+
+	sport, subSport := 0, 0
+	if activity.Type_ != nil {
+		if values, exists := sports[*activity.Type_]; exists {
+			sport, subSport = values[0], values[1]
+		}
+	}
+
+	e := NewEncoder()
+	if err := e.Write(MesgFileId, []Field{
+		{0, Enum, fileActivity},
+		{1, Uint16, manufacturerDevelopment},
+		{2, Uint16, 0},
+		{4, Uint32, Timestamp(start)},
+	}); err != nil {
+		return nil, err
+	}
+	if err := writeEvent(e, start, eventTimer, eventTypeStart); err != nil {
+		return nil, err
+	}
+
+	// Records
+	for i := 0; i < samples; i++ {
+		if err := e.Write(MesgRecord, getRecordFields(start, streams, latlng, i)); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeEvent(e, end, eventTimer, eventTypeStopAll); err != nil {
+		return nil, err
+	}
+
+	// Laps
+	if len(laps) == 0 {
+		laps = []swagger.Lap{{
+			AverageSpeed:       activity.AverageSpeed,
+			Distance:           activity.Distance,
+			ElapsedTime:        activity.ElapsedTime,
+			EndIndex:           int32(samples - 1),
+			MaxSpeed:           activity.MaxSpeed,
+			MovingTime:         activity.MovingTime,
+			StartDate:          activity.StartDate,
+			TotalElevationGain: activity.TotalElevationGain,
+		}}
+	}
+	for i, lap := range laps {
+		lapStart := lap.StartDate.UTC()
+		stats := getSampleStats(streams, latlng, int(lap.StartIndex), int(lap.EndIndex))
+		if err := e.Write(MesgLap, []Field{
+			{254, Uint16, i},
+			{253, Uint32, Timestamp(lapStart.Add(time.Duration(lap.ElapsedTime) * time.Second))},
+			{0, Enum, eventLap},
+			{1, Enum, eventTypeStop},
+			{2, Uint32, Timestamp(lapStart)},
+			{3, Sint32, stats.StartLat},
+			{4, Sint32, stats.StartLng},
+			{5, Sint32, stats.EndLat},
+			{6, Sint32, stats.EndLng},
+			{7, Uint32, scale(float64(lap.ElapsedTime), 1000)},
+			{8, Uint32, scale(float64(lap.MovingTime), 1000)},
+			{9, Uint32, scale(float64(lap.Distance), 100)},
+			{13, Uint16, scale(float64(lap.AverageSpeed), 1000)},
+			{14, Uint16, scale(float64(lap.MaxSpeed), 1000)},
+			{15, Uint8, stats.AverageHeartRate},
+			{16, Uint8, stats.MaxHeartRate},
+			{17, Uint8, optionalValue(math.Round(float64(lap.AverageCadence)))},
+			{19, Uint16, stats.AveragePower},
+			{20, Uint16, stats.MaxPower},
+			{21, Uint16, scale(float64(lap.TotalElevationGain), 1)},
+			{25, Enum, sport},
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	// Session (heart rate isn't part of the activity details, so it's calculated from the streams)
+	stats := getSampleStats(streams, latlng, 0, samples-1)
+	if err := e.Write(MesgSession, []Field{
+		{254, Uint16, 0},
+		{253, Uint32, Timestamp(end)},
+		{0, Enum, eventSession},
+		{1, Enum, eventTypeStop},
+		{2, Uint32, Timestamp(start)},
+		{3, Sint32, stats.StartLat},
+		{4, Sint32, stats.StartLng},
+		{5, Enum, sport},
+		{6, Enum, subSport},
+		{7, Uint32, scale(float64(activity.ElapsedTime), 1000)},
+		{8, Uint32, scale(float64(activity.MovingTime), 1000)},
+		{9, Uint32, scale(float64(activity.Distance), 100)},
+		{11, Uint16, optionalValue(math.Round(float64(activity.Calories)))},
+		{14, Uint16, scale(float64(activity.AverageSpeed), 1000)},
+		{15, Uint16, scale(float64(activity.MaxSpeed), 1000)},
+		{16, Uint8, stats.AverageHeartRate},
+		{17, Uint8, stats.MaxHeartRate},
+		{20, Uint16, optionalValue(math.Round(float64(activity.AverageWatts)))},
+		{21, Uint16, optionalValue(float64(activity.MaxWatts))},
+		{22, Uint16, scale(float64(activity.TotalElevationGain), 1)},
+		{25, Uint16, 0},
+		{26, Uint16, len(laps)},
+	}); err != nil {
+		return nil, err
+	}
+
+	// Activity (local time is passed by Strava as UTC)
+	var localOffset time.Duration
+	if !activity.StartDateLocal.IsZero() {
+		localOffset = activity.StartDateLocal.Sub(activity.StartDate)
+	}
+	if err := e.Write(MesgActivity, []Field{
+		{253, Uint32, Timestamp(end)},
+		{0, Uint32, scale(float64(activity.MovingTime), 1000)},
+		{1, Uint16, 1},
+		{2, Enum, activityManual},
+		{3, Enum, eventActivity},
+		{4, Enum, eventTypeStop},
+		{5, Uint32, Timestamp(end.Add(localOffset))},
+	}); err != nil {
+		return nil, err
+	}
+
+	return e.Bytes(), nil
+}
+
+// writeEvent adds an event message (e.g. start of the timer)
+func writeEvent(e *Encoder, timestamp time.Time, event, eventType int) error {
+	return e.Write(MesgEvent, []Field{
+		{253, Uint32, Timestamp(timestamp)},
+		{0, Enum, event},
+		{1, Enum, eventType},
+	})
+}
+
+// getRecordFields returns the fields of the record of a sample, values of missing streams are invalid
+func getRecordFields(start time.Time, streams swagger.StreamSet, latlng [][]float64, i int) []Field {
+	var lat, lng, altitude, heartRate, cadence, distance, speed, power, grade, temperature interface{}
+	if i < len(latlng) && len(latlng[i]) == 2 {
+		lat, lng = Semicircles(latlng[i][0]), Semicircles(latlng[i][1])
+	}
+	if streams.Altitude != nil && i < len(streams.Altitude.Data) {
+		altitude = scale(float64(streams.Altitude.Data[i])+500, 5)
+	}
+	if streams.Heartrate != nil && i < len(streams.Heartrate.Data) {
+		heartRate = streams.Heartrate.Data[i]
+	}
+	if streams.Cadence != nil && i < len(streams.Cadence.Data) {
+		cadence = streams.Cadence.Data[i]
+	}
+	if streams.Distance != nil && i < len(streams.Distance.Data) {
+		distance = scale(float64(streams.Distance.Data[i]), 100)
+	}
+	if streams.VelocitySmooth != nil && i < len(streams.VelocitySmooth.Data) {
+		speed = scale(float64(streams.VelocitySmooth.Data[i]), 1000)
+	}
+	if streams.Watts != nil && i < len(streams.Watts.Data) {
+		power = streams.Watts.Data[i]
+	}
+	if streams.GradeSmooth != nil && i < len(streams.GradeSmooth.Data) {
+		grade = scale(float64(streams.GradeSmooth.Data[i]), 100)
+	}
+	if streams.Temp != nil && i < len(streams.Temp.Data) {
+		temperature = streams.Temp.Data[i]
+	}
+
+	return []Field{
+		{253, Uint32, Timestamp(start.Add(time.Duration(streams.Time.Data[i]) * time.Second))},
+		{0, Sint32, lat},
+		{1, Sint32, lng},
+		{2, Uint16, altitude},
+		{3, Uint8, heartRate},
+		{4, Uint8, cadence},
+		{5, Uint32, distance},
+		{6, Uint16, speed},
+		{7, Uint16, power},
+		{9, Sint16, grade},
+		{13, Sint8, temperature},
+	}
+}
+
+// getSampleStats calculates heart rate, power and positions of the samples in a range (inclusive)
+func getSampleStats(streams swagger.StreamSet, latlng [][]float64, from, to int) sampleStats {
+	stats := sampleStats{}
+
+	average := func(data []int32) (interface{}, interface{}) {
+		var sum, count, max int64
+		for i := from; i <= to && i < len(data); i++ {
+			if i < 0 || data[i] <= 0 {
+				continue
+			}
+			sum += int64(data[i])
+			count++
+			if int64(data[i]) > max {
+				max = int64(data[i])
+			}
+		}
+		if count == 0 {
+			return nil, nil
+		}
+		return optionalValue(math.Round(float64(sum) / float64(count))), max
+	}
+	if streams.Heartrate != nil {
+		stats.AverageHeartRate, stats.MaxHeartRate = average(streams.Heartrate.Data)
+	}
+	if streams.Watts != nil {
+		stats.AveragePower, stats.MaxPower = average(streams.Watts.Data)
+	}
+
+	for i := from; i <= to && i < len(latlng); i++ {
+		if i < 0 || len(latlng[i]) != 2 {
+			continue
+		}
+		if stats.StartLat == nil {
+			stats.StartLat, stats.StartLng = Semicircles(latlng[i][0]), Semicircles(latlng[i][1])
+		}
+		stats.EndLat, stats.EndLng = Semicircles(latlng[i][0]), Semicircles(latlng[i][1])
+	}
+	return stats
+}
+
+// scale returns the rounded value of a field with a scale factor
+func scale(value, factor float64) int64 {
+	return int64(math.Round(value * factor))
+}
+
+// optionalValue returns nil for zero values (not set by Strava)
+func optionalValue(value float64) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return int64(value)
+}
@@ -0,0 +1,208 @@
+package fit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidFile = errors.New("invalid FIT file")
+)
+
+// Message is a decoded data message, fields with invalid values are omitted (numbers are returned as
+// int64, strings as string and arrays as []int64)
+type Message struct {
+	Num    uint16
+	Fields map[byte]interface{}
+}
+
+// definition is a decoded definition message
+type definition struct {
+	global    uint16
+	bigEndian bool
+	fields    []fieldDefinition
+	devSize   int
+}
+
+// fieldDefinition is a field of a definition message
+type fieldDefinition struct {
+	num      byte
+	size     byte
+	baseType BaseType
+}
+
+// Decode reads the data messages of a file, header and checksums are validated
+func Decode(data []byte) ([]Message, error) {
+	if len(data) < 12 || string(data[8:12]) != ".FIT" {
+		return nil, ErrInvalidFile
+	}
+	headerSize := int(data[0])
+	if headerSize < 12 || len(data) < headerSize {
+		return nil, ErrInvalidFile
+	}
+	if headerSize >= 14 {
+		if sum := binary.LittleEndian.Uint16(data[12:14]); sum != 0 && sum != crc(data[:12]) {
+			return nil, fmt.Errorf("%w: header checksum mismatch", ErrInvalidFile)
+		}
+	}
+	end := headerSize + int(binary.LittleEndian.Uint32(data[4:8]))
+	if len(data) < end+2 {
+		return nil, fmt.Errorf("%w: file truncated", ErrInvalidFile)
+	}
+	if binary.LittleEndian.Uint16(data[end:end+2]) != crc(data[:end]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidFile)
+	}
+
+	messages := []Message{}
+	definitions := map[byte]definition{}
+	for pos := headerSize; pos < end; {
+		header := data[pos]
+		pos++
+
+		// Compressed timestamp header (timestamp offset isn't added to the fields)
+		if header&0x80 != 0 {
+			message, n, err := decodeMessage(data[pos:end], definitions, (header>>5)&0x03)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, message)
+			pos += n
+			continue
+		}
+
+		local := header & 0x0f
+		if header&0x40 == 0 {
+			message, n, err := decodeMessage(data[pos:end], definitions, local)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, message)
+			pos += n
+			continue
+		}
+
+		// Definition message
+		if end-pos < 5 {
+			return nil, fmt.Errorf("%w: definition truncated", ErrInvalidFile)
+		}
+		def := definition{bigEndian: data[pos+1] == 1}
+		if def.bigEndian {
+			def.global = binary.BigEndian.Uint16(data[pos+2 : pos+4])
+		} else {
+			def.global = binary.LittleEndian.Uint16(data[pos+2 : pos+4])
+		}
+		count := int(data[pos+4])
+		pos += 5
+		if end-pos < count*3 {
+			return nil, fmt.Errorf("%w: definition truncated", ErrInvalidFile)
+		}
+		for i := 0; i < count; i++ {
+			def.fields = append(def.fields, fieldDefinition{num: data[pos], size: data[pos+1], baseType: BaseType(data[pos+2])})
+			pos += 3
+		}
+
+		// Developer fields are skipped
+		if header&0x20 != 0 {
+			if pos >= end {
+				return nil, fmt.Errorf("%w: definition truncated", ErrInvalidFile)
+			}
+			devCount := int(data[pos])
+			pos++
+			if end-pos < devCount*3 {
+				return nil, fmt.Errorf("%w: definition truncated", ErrInvalidFile)
+			}
+			for i := 0; i < devCount; i++ {
+				def.devSize += int(data[pos+1])
+				pos += 3
+			}
+		}
+		definitions[local] = def
+	}
+	return messages, nil
+}
+
+// decodeMessage decodes a data message and returns the number of bytes read
+func decodeMessage(data []byte, definitions map[byte]definition, local byte) (Message, int, error) {
+	def, exists := definitions[local]
+	if !exists {
+		return Message{}, 0, fmt.Errorf("%w: missing definition of local message %d", ErrInvalidFile, local)
+	}
+
+	message := Message{Num: def.global, Fields: map[byte]interface{}{}}
+	pos := 0
+	for _, field := range def.fields {
+		if len(data)-pos < int(field.size) {
+			return Message{}, 0, fmt.Errorf("%w: message truncated", ErrInvalidFile)
+		}
+		if value, valid := decodeValue(data[pos:pos+int(field.size)], field.baseType, def.bigEndian); valid {
+			message.Fields[field.num] = value
+		}
+		pos += int(field.size)
+	}
+	if len(data)-pos < def.devSize {
+		return Message{}, 0, fmt.Errorf("%w: message truncated", ErrInvalidFile)
+	}
+	return message, pos + def.devSize, nil
+}
+
+// decodeValue decodes a field value, false if the value is invalid
+func decodeValue(data []byte, baseType BaseType, bigEndian bool) (interface{}, bool) {
+	if baseType == String {
+		for i, b := range data {
+			if b == 0 {
+				data = data[:i]
+				break
+			}
+		}
+		return string(data), len(data) > 0
+	}
+
+	// Other types (e.g. floats) aren't used by the encoder
+	switch baseType {
+	case Enum, Sint8, Uint8, Sint16, Uint16, Sint32, Uint32, Uint8z, Uint16z, Uint32z:
+	default:
+		return nil, false
+	}
+
+	size := int(baseType.size())
+	if size > len(data) || len(data)%size != 0 {
+		return nil, false
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+
+	_, _, invalid := baseType.limits()
+	values := []int64{}
+	for i := 0; i < len(data); i += size {
+		var v int64
+		switch baseType {
+		case Sint8:
+			v = int64(int8(data[i]))
+		case Sint16:
+			v = int64(int16(order.Uint16(data[i:])))
+		case Sint32:
+			v = int64(int32(order.Uint32(data[i:])))
+		case Uint16, Uint16z:
+			v = int64(order.Uint16(data[i:]))
+		case Uint32, Uint32z:
+			v = int64(order.Uint32(data[i:]))
+		default:
+			v = int64(data[i])
+		}
+		if v != invalid {
+			values = append(values, v)
+		}
+	}
+
+	switch {
+	case len(values) == 0:
+		return nil, false
+	case len(data) == size:
+		return values[0], true
+	default:
+		return values, true
+	}
+}
@@ -0,0 +1,214 @@
+package fit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// BaseType is the type of a field value
+type BaseType byte
+
+// Base types (the high bit marks multi-byte types)
+const (
+	Enum    BaseType = 0x00
+	Sint8   BaseType = 0x01
+	Uint8   BaseType = 0x02
+	Sint16  BaseType = 0x83
+	Uint16  BaseType = 0x84
+	Sint32  BaseType = 0x85
+	Uint32  BaseType = 0x86
+	String  BaseType = 0x07
+	Uint8z  BaseType = 0x0a
+	Uint16z BaseType = 0x8b
+	Uint32z BaseType = 0x8c
+)
+
+// Global message numbers
+const (
+	MesgFileId   uint16 = 0
+	MesgSession  uint16 = 18
+	MesgLap      uint16 = 19
+	MesgRecord   uint16 = 20
+	MesgEvent    uint16 = 21
+	MesgActivity uint16 = 34
+)
+
+var (
+	// Protocol version 2.0 and profile version 21.40 written to the header
+	ProtocolVersion byte   = 0x20
+	ProfileVersion  uint16 = 2140
+
+	// Timestamps are seconds since this date
+	Epoch = time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	crcTable = [16]uint16{
+		0x0000, 0xcc01, 0xd801, 0x1400, 0xf001, 0x3c00, 0x2800, 0xe401,
+		0xa001, 0x6c00, 0x7800, 0xb401, 0x5000, 0x9c01, 0x8801, 0x4400,
+	}
+)
+
+// Field is a field of a message, nil values are written as invalid value of the base type
+type Field struct {
+	Num   byte
+	Type  BaseType
+	Value interface{}
+}
+
+// Encoder writes messages to a FIT file, a message definition is written whenever the fields of a
+// message type change
+type Encoder struct {
+	data        bytes.Buffer
+	localTypes  map[uint16]byte
+	definitions map[byte]string
+}
+
+// NewEncoder returns an encoder for a new file
+func NewEncoder() *Encoder {
+	return &Encoder{localTypes: map[uint16]byte{}, definitions: map[byte]string{}}
+}
+
+// Write adds a message
+func (e *Encoder) Write(global uint16, fields []Field) error {
+	local, exists := e.localTypes[global]
+	if !exists {
+		if len(e.localTypes) == 16 {
+			return fmt.Errorf("too many message types")
+		}
+		local = byte(len(e.localTypes))
+		e.localTypes[global] = local
+	}
+
+	// Definition message
+	signature := fmt.Sprint(global)
+	for _, field := range fields {
+		signature += fmt.Sprintf(",%d:%d", field.Num, field.Type)
+	}
+	if e.definitions[local] != signature {
+		e.data.WriteByte(0x40 | local)
+		e.data.Write([]byte{0, 0})
+		binary.Write(&e.data, binary.LittleEndian, global)
+		e.data.WriteByte(byte(len(fields)))
+		for _, field := range fields {
+			e.data.Write([]byte{field.Num, field.Type.size(), byte(field.Type)})
+		}
+		e.definitions[local] = signature
+	}
+
+	// Data message
+	e.data.WriteByte(local)
+	for _, field := range fields {
+		value, err := field.Type.encode(field.Value)
+		if err != nil {
+			return fmt.Errorf("field %d of message %d: %s", field.Num, global, err.Error())
+		}
+		e.data.Write(value)
+	}
+	return nil
+}
+
+// Bytes returns the file including header and checksum
+func (e *Encoder) Bytes() []byte {
+	var file bytes.Buffer
+	file.WriteByte(14)
+	file.WriteByte(ProtocolVersion)
+	binary.Write(&file, binary.LittleEndian, ProfileVersion)
+	binary.Write(&file, binary.LittleEndian, uint32(e.data.Len()))
+	file.WriteString(".FIT")
+	binary.Write(&file, binary.LittleEndian, crc(file.Bytes()))
+
+	file.Write(e.data.Bytes())
+	binary.Write(&file, binary.LittleEndian, crc(file.Bytes()))
+	return file.Bytes()
+}
+
+// Timestamp returns the value of a date time field
+func Timestamp(t time.Time) uint32 {
+	return uint32(t.Sub(Epoch) / time.Second)
+}
+
+// Semicircles returns the value of a position field for degrees
+func Semicircles(degrees float64) int32 {
+	return int32(degrees * (1 << 31) / 180)
+}
+
+// size returns the number of bytes of a value
+func (t BaseType) size() byte {
+	switch t {
+	case Sint16, Uint16, Uint16z:
+		return 2
+	case Sint32, Uint32, Uint32z:
+		return 4
+	default:
+		return 1
+	}
+}
+
+// limits returns the range of valid values and the invalid value of a type
+func (t BaseType) limits() (min, max, invalid int64) {
+	switch t {
+	case Sint8:
+		return -0x80, 0x7e, 0x7f
+	case Sint16:
+		return -0x8000, 0x7ffe, 0x7fff
+	case Sint32:
+		return -0x80000000, 0x7ffffffe, 0x7fffffff
+	case Uint16:
+		return 0, 0xfffe, 0xffff
+	case Uint32:
+		return 0, 0xfffffffe, 0xffffffff
+	case Uint8z:
+		return 1, 0xff, 0
+	case Uint16z:
+		return 1, 0xffff, 0
+	case Uint32z:
+		return 1, 0xffffffff, 0
+	default:
+		return 0, 0xfe, 0xff
+	}
+}
+
+// encode returns the bytes of a value (out of range values are written as invalid value)
+func (t BaseType) encode(value interface{}) ([]byte, error) {
+	min, max, invalid := t.limits()
+
+	v := invalid
+	switch value := value.(type) {
+	case nil:
+	case int:
+		v = int64(value)
+	case int32:
+		v = int64(value)
+	case int64:
+		v = value
+	case uint8:
+		v = int64(value)
+	case uint16:
+		v = int64(value)
+	case uint32:
+		v = int64(value)
+	default:
+		return nil, fmt.Errorf("unsupported value %v", value)
+	}
+	if v < min || v > max {
+		v = invalid
+	}
+
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, uint32(v))
+	return data[:t.size()], nil
+}
+
+// crc returns the checksum of data
+func crc(data []byte) uint16 {
+	var sum uint16
+	for _, b := range data {
+		for _, nibble := range []byte{b & 0xf, b >> 4} {
+			tmp := crcTable[sum&0xf]
+			sum = (sum >> 4) & 0x0fff
+			sum = sum ^ tmp ^ crcTable[nibble]
+		}
+	}
+	return sum
+}
@@ -0,0 +1,209 @@
+package fit
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+)
+
+var (
+	testStart = time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+)
+
+// encodeTestActivity returns a run of ten seconds with a single lap and three samples (no power)
+func encodeTestActivity(t *testing.T) []byte {
+	run := swagger.RUN
+	activity := swagger.DetailedActivity{
+		Id:                 1,
+		Type_:              &run,
+		StartDate:          testStart,
+		StartDateLocal:     testStart.Add(2 * time.Hour),
+		ElapsedTime:        10,
+		MovingTime:         9,
+		Distance:           1000.25,
+		AverageSpeed:       3.5,
+		MaxSpeed:           4.25,
+		TotalElevationGain: 12,
+	}
+	laps := []swagger.Lap{{
+		StartDate:    testStart,
+		StartIndex:   0,
+		EndIndex:     2,
+		ElapsedTime:  10,
+		MovingTime:   9,
+		Distance:     1000.25,
+		AverageSpeed: 3.5,
+		MaxSpeed:     4.25,
+	}}
+	streams := swagger.StreamSet{
+		Time:      &swagger.TimeStream{Data: []int32{0, 5, 10}},
+		Distance:  &swagger.DistanceStream{Data: []float32{0, 500.5, 1000.25}},
+		Altitude:  &swagger.AltitudeStream{Data: []float32{100, 101.2, 103}},
+		Heartrate: &swagger.HeartrateStream{Data: []int32{120, 130, 141}},
+	}
+	latlng := [][]float64{{47.5, 9.5}, {47.25, 9.75}, {47, 10}}
+
+	data, err := EncodeActivity(activity, laps, streams, latlng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// getMessages returns the decoded messages grouped by global message number
+func getMessages(t *testing.T, data []byte) map[uint16][]Message {
+	messages, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grouped := map[uint16][]Message{}
+	for _, message := range messages {
+		grouped[message.Num] = append(grouped[message.Num], message)
+	}
+	return grouped
+}
+
+// checkFields compares the fields of a message, nil values must be omitted
+func checkFields(t *testing.T, name string, message Message, expected map[byte]interface{}) {
+	t.Helper()
+	for num, value := range expected {
+		actual, exists := message.Fields[num]
+		if value == nil {
+			if exists {
+				t.Errorf("%s: field %d should be omitted, got %v", name, num, actual)
+			}
+			continue
+		}
+		if !exists {
+			t.Errorf("%s: field %d missing", name, num)
+		} else if actual != value {
+			t.Errorf("%s: field %d expected %v, got %v", name, num, value, actual)
+		}
+	}
+}
+
+func TestEncodeActivity(t *testing.T) {
+	messages := getMessages(t, encodeTestActivity(t))
+	start := int64(Timestamp(testStart))
+	end := int64(Timestamp(testStart.Add(10 * time.Second)))
+
+	for num, count := range map[uint16]int{MesgFileId: 1, MesgEvent: 2, MesgRecord: 3, MesgLap: 1, MesgSession: 1, MesgActivity: 1} {
+		if len(messages[num]) != count {
+			t.Fatalf("expected %d messages of type %d, got %d", count, num, len(messages[num]))
+		}
+	}
+
+	checkFields(t, "file_id", messages[MesgFileId][0], map[byte]interface{}{
+		0: int64(fileActivity),
+		1: int64(manufacturerDevelopment),
+		4: start,
+	})
+
+	// Scaled values (distance in cm, speed in mm/s, altitude with offset 500 m and scale 5)
+	checkFields(t, "record", messages[MesgRecord][1], map[byte]interface{}{
+		253: start + 5,
+		0:   int64(Semicircles(47.25)),
+		1:   int64(Semicircles(9.75)),
+		2:   int64(3006),
+		3:   int64(130),
+		5:   int64(50050),
+		6:   nil,
+		7:   nil,
+	})
+
+	checkFields(t, "lap", messages[MesgLap][0], map[byte]interface{}{
+		253: end,
+		2:   start,
+		3:   int64(Semicircles(47.5)),
+		4:   int64(Semicircles(9.5)),
+		5:   int64(Semicircles(47)),
+		6:   int64(Semicircles(10)),
+		7:   int64(10000),
+		8:   int64(9000),
+		9:   int64(100025),
+		13:  int64(3500),
+		14:  int64(4250),
+		15:  int64(130),
+		16:  int64(141),
+		17:  nil,
+		19:  nil,
+		25:  int64(1),
+	})
+
+	checkFields(t, "session", messages[MesgSession][0], map[byte]interface{}{
+		253: end,
+		2:   start,
+		5:   int64(1),
+		7:   int64(10000),
+		8:   int64(9000),
+		9:   int64(100025),
+		11:  nil,
+		14:  int64(3500),
+		15:  int64(4250),
+		16:  int64(130),
+		17:  int64(141),
+		20:  nil,
+		22:  int64(12),
+		26:  int64(1),
+	})
+
+	checkFields(t, "activity", messages[MesgActivity][0], map[byte]interface{}{
+		253: end,
+		0:   int64(9000),
+		1:   int64(1),
+		5:   end + 2*60*60,
+	})
+}
+
+func TestChecksums(t *testing.T) {
+	data := encodeTestActivity(t)
+
+	if sum := binary.LittleEndian.Uint16(data[12:14]); sum != crc(data[:12]) {
+		t.Errorf("header checksum %x doesn't match %x", sum, crc(data[:12]))
+	}
+	end := len(data) - 2
+	if sum := binary.LittleEndian.Uint16(data[end:]); sum != crc(data[:end]) {
+		t.Errorf("file checksum %x doesn't match %x", sum, crc(data[:end]))
+	}
+
+	// Changed header or data must be detected
+	for _, pos := range []int{2, 20} {
+		corrupt := append([]byte{}, data...)
+		corrupt[pos] ^= 0xff
+		if _, err := Decode(corrupt); !errors.Is(err, ErrInvalidFile) {
+			t.Errorf("corrupt byte %d not detected: %v", pos, err)
+		}
+	}
+}
+
+func TestEncodeLimits(t *testing.T) {
+	tests := []struct {
+		baseType BaseType
+		value    interface{}
+		expected []byte
+	}{
+		{Sint8, -0x80, []byte{0x80}},
+		{Sint8, -0x81, []byte{0x7f}},
+		{Sint8, 0x7e, []byte{0x7e}},
+		{Sint8, 0x7f, []byte{0x7f}},
+		{Sint16, -0x8000, []byte{0x00, 0x80}},
+		{Sint16, 0x7fff, []byte{0xff, 0x7f}},
+		{Sint32, int64(-0x80000000), []byte{0x00, 0x00, 0x00, 0x80}},
+		{Uint8, 0xff, []byte{0xff}},
+		{Uint16, -1, []byte{0xff, 0xff}},
+		{Uint8z, 0, []byte{0x00}},
+		{Uint8, nil, []byte{0xff}},
+	}
+	for _, test := range tests {
+		data, err := test.baseType.encode(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != string(test.expected) {
+			t.Errorf("type %d: expected %x for %v, got %x", test.baseType, test.expected, test.value, data)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/antihax/optional"
+	swagger "github.com/aschbacd/strava-export/pkg/strava"
+)
+
+var (
+	// Time between status requests of an upload
+	PollInterval = 2 * time.Second
+	// Maximum time to wait for an upload to be processed
+	PollTimeout = 2 * time.Minute
+	// Maximum uncompressed size of all files contained in ZIP archives
+	MaxExpandedSize int64 = 500 << 20
+
+	// Data types supported by Strava (compressed files end with .gz)
+	dataTypes = []string{"fit", "tcx", "gpx"}
+)
+
+// Status of an imported file
+const (
+	StatusCreated     = "Importiert"
+	StatusDuplicate   = "Duplikat"
+	StatusFailed      = "Fehler"
+	StatusUnsupported = "Nicht unterstützt"
+	StatusTimeout     = "Zeitüberschreitung"
+)
+
+// File is an activity file that should be imported
+type File struct {
+	Name string
+	Data []byte
+}
+
+// Result describes the outcome of importing a file
+type Result struct {
+	File       string
+	Status     string
+	Error      string
+	UploadId   int64
+	ActivityId int64
+}
+
+// Importer uploads activity files to Strava
+type Importer struct {
+	Client *swagger.APIClient
+	// Context containing the token source (swagger.ContextOAuth2)
+	Auth context.Context
+}
+
+// GetDataType returns the Strava data type of a file based on its extension (e.g. gpx.gz)
+func GetDataType(name string) (string, bool) {
+	name = strings.ToLower(name)
+	for _, dataType := range dataTypes {
+		for _, extension := range []string{dataType, dataType + ".gz"} {
+			if strings.HasSuffix(name, "."+extension) {
+				return extension, true
+			}
+		}
+	}
+	return "", false
+}
+
+// ExpandFiles replaces ZIP archives by the files they contain, files exceeding the maximum size of
+// all expanded files are skipped
+func ExpandFiles(files []File) ([]File, []Result) {
+	expanded := []File{}
+	results := []Result{}
+	remaining := MaxExpandedSize
+
+	for _, file := range files {
+		if !strings.HasSuffix(strings.ToLower(file.Name), ".zip") {
+			expanded = append(expanded, file)
+			continue
+		}
+
+		archive, err := zip.NewReader(bytes.NewReader(file.Data), int64(len(file.Data)))
+		if err != nil {
+			results = append(results, Result{File: file.Name, Status: StatusFailed, Error: err.Error()})
+			continue
+		}
+
+		for _, entry := range archive.File {
+			if entry.FileInfo().IsDir() {
+				continue
+			}
+			name := file.Name + "/" + entry.Name
+
+			reader, err := entry.Open()
+			if err != nil {
+				results = append(results, Result{File: name, Status: StatusFailed, Error: err.Error()})
+				continue
+			}
+			// Sizes in the archive can't be trusted, so the read data is limited
+			data, err := ioutil.ReadAll(io.LimitReader(reader, remaining+1))
+			reader.Close()
+			if err != nil {
+				results = append(results, Result{File: name, Status: StatusFailed, Error: err.Error()})
+				continue
+			} else if int64(len(data)) > remaining {
+				results = append(results, Result{File: name, Status: StatusFailed, Error: fmt.Sprintf("uncompressed files exceed %d MB", MaxExpandedSize>>20)})
+				continue
+			}
+			remaining -= int64(len(data))
+
+			expanded = append(expanded, File{Name: name, Data: data})
+		}
+	}
+
+	return expanded, results
+}
+
+// Import uploads all files one after another (ZIP archives are expanded), the callback is called
+// after every file
+func (i *Importer) Import(files []File, callback func(Result)) []Result {
+	files, results := ExpandFiles(files)
+	for _, result := range results {
+		if callback != nil {
+			callback(result)
+		}
+	}
+
+	for _, file := range files {
+		result := i.Upload(file)
+		results = append(results, result)
+		if callback != nil {
+			callback(result)
+		}
+	}
+	return results
+}
+
+// Upload uploads a single file and waits until Strava processed it
+func (i *Importer) Upload(file File) Result {
+	result := Result{File: file.Name}
+
+	dataType, ok := GetDataType(file.Name)
+	if !ok {
+		result.Status = StatusUnsupported
+		result.Error = fmt.Sprintf("supported file types: %s (optionally compressed as .gz) and zip", strings.Join(dataTypes, ", "))
+		return result
+	}
+
+	// The generated client only accepts files stored on disk
+	osFile, cleanup, err := writeTempFile(file)
+	if err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+		return result
+	}
+	defer cleanup()
+
+	upload, resp, err := i.Client.UploadsApi.CreateUpload(i.Auth, &swagger.UploadsApiCreateUploadOpts{
+		File:       optional.NewInterface(osFile),
+		DataType:   optional.NewString(dataType),
+		ExternalId: optional.NewString(filepath.Base(file.Name)),
+	})
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		result.Status = StatusFailed
+		result.Error = "rate limit reached"
+		return result
+	} else if err != nil {
+		result.Status = StatusFailed
+		result.Error = getErrorMessage(err)
+		return result
+	}
+
+	// Wait until upload is processed
+	result.UploadId = upload.Id
+	for start := time.Now(); upload.Error_ == "" && upload.ActivityId == 0; {
+		if time.Since(start) > PollTimeout {
+			result.Status = StatusTimeout
+			return result
+		}
+		time.Sleep(PollInterval)
+
+		upload, resp, err = i.Client.UploadsApi.GetUploadById(i.Auth, result.UploadId)
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			result.Status = StatusFailed
+			result.Error = "rate limit reached"
+			return result
+		} else if err != nil {
+			result.Status = StatusFailed
+			result.Error = getErrorMessage(err)
+			return result
+		}
+	}
+
+	result.ActivityId = upload.ActivityId
+	switch {
+	case upload.Error_ == "":
+		result.Status = StatusCreated
+	case strings.Contains(upload.Error_, "duplicate of"):
+		result.Status = StatusDuplicate
+		result.Error = upload.Error_
+	default:
+		result.Status = StatusFailed
+		result.Error = upload.Error_
+	}
+	return result
+}
+
+// getErrorMessage returns the error message including the response body of failed requests
+func getErrorMessage(err error) string {
+	if swaggerErr, ok := err.(swagger.GenericSwaggerError); ok && len(swaggerErr.Body()) > 0 {
+		return fmt.Sprintf("%s: %s", swaggerErr.Error(), strings.TrimSpace(string(swaggerErr.Body())))
+	}
+	return err.Error()
+}
+
+// writeTempFile stores a file in a temporary directory (keeping its name) and returns a function to
+// remove it again
+func writeTempFile(file File) (*os.File, func(), error) {
+	dir, err := ioutil.TempDir("", "strava-import")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	path := filepath.Join(dir, filepath.Base(file.Name))
+	if err := ioutil.WriteFile(path, file.Data, 0600); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	osFile, err := os.Open(path)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return osFile, cleanup, nil
+}
@@ -0,0 +1,272 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aschbacd/strava-export/pkg/utils"
+)
+
+// Connection security of the smtp server
+const (
+	SecurityStartTLS = "starttls"
+	SecurityTLS      = "tls"
+	SecurityNone     = "none"
+)
+
+var (
+	// Directory containing the mail templates (<name>.<language>.txt)
+	TemplatesDir = "views/mail"
+	// Language used if no template exists for the requested language
+	DefaultLanguage = "de"
+
+	// Timeout for connecting to the smtp server
+	dialTimeout = 30 * time.Second
+)
+
+// Config contains the settings of the smtp server
+type Config struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string // e.g. Strava Export <export@example.com>
+	Security string
+}
+
+// Attachment is a file attached to a message
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Message is a plain text email
+type Message struct {
+	To          string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// GetConfig returns the smtp settings set in the environment
+func GetConfig() Config {
+	return Config{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     utils.GetEnv("SMTP_PORT", "587"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+		Security: utils.GetEnv("SMTP_SECURITY", SecurityStartTLS),
+	}
+}
+
+// IsConfigured checks if emails can be sent
+func (c Config) IsConfigured() bool {
+	return c.Host != "" && c.From != ""
+}
+
+// Render executes the templates "subject" and "body" of a mail template in the requested language
+func Render(name, language string, data interface{}) (string, string, error) {
+	path := filepath.Join(TemplatesDir, fmt.Sprintf("%s.%s.txt", name, language))
+	if _, err := os.Stat(path); err != nil {
+		path = filepath.Join(TemplatesDir, fmt.Sprintf("%s.%s.txt", name, DefaultLanguage))
+	}
+
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(subject.String()), strings.TrimSpace(body.String()) + "\n", nil
+}
+
+// Send delivers a message using the smtp server
+func Send(config Config, message Message) error {
+	if !config.IsConfigured() {
+		return fmt.Errorf("smtp server not configured")
+	}
+
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %s", err.Error())
+	}
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %s", err.Error())
+	}
+
+	data, err := encodeMessage(from, to, message)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial(config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// dial connects to the smtp server using the configured connection security
+func dial(config Config) (*smtp.Client, error) {
+	address := net.JoinHostPort(config.Host, config.Port)
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	tlsConfig := &tls.Config{ServerName: config.Host}
+
+	switch config.Security {
+	case SecurityTLS:
+		conn, err := tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		return smtp.NewClient(conn, config.Host)
+	case SecurityStartTLS, SecurityNone:
+		conn, err := dialer.Dial("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		client, err := smtp.NewClient(conn, config.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		// Credentials must not be sent unencrypted
+		if config.Security == SecurityStartTLS {
+			if ok, _ := client.Extension("STARTTLS"); !ok {
+				client.Close()
+				return nil, fmt.Errorf("smtp server %s doesn't support STARTTLS", address)
+			}
+			if err := client.StartTLS(tlsConfig); err != nil {
+				client.Close()
+				return nil, err
+			}
+		}
+		return client, nil
+	default:
+		return nil, fmt.Errorf("unknown smtp security %s (expected %s, %s or %s)", config.Security, SecurityStartTLS, SecurityTLS, SecurityNone)
+	}
+}
+
+// encodeMessage returns the MIME encoded message (text body followed by the attachments)
+func encodeMessage(from, to *mail.Address, message Message) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := multipart.NewWriter(&buffer)
+
+	// Headers
+	headers := []string{
+		"From: " + from.String(),
+		"To: " + to.String(),
+		"Subject: " + mime.QEncoding.Encode("utf-8", message.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: " + fmt.Sprintf("<%s@%s>", utils.GetRandomString(32), getDomain(from.Address)),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=" + writer.Boundary(),
+	}
+	buffer.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	// Body
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	body := quotedprintable.NewWriter(part)
+	if _, err := body.Write([]byte(strings.ReplaceAll(message.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+
+	// Attachments
+	for _, attachment := range message.Attachments {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(part, attachment.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// writeBase64 writes base64 encoded data with lines of 76 characters
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := io.WriteString(w, encoded[:76]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err := io.WriteString(w, encoded+"\r\n")
+	return err
+}
+
+// getDomain returns the domain of an address (used for message ids)
+func getDomain(address string) string {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return address[i+1:]
+	}
+	return "localhost"
+}
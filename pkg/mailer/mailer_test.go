@@ -0,0 +1,232 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receivedMail is a message accepted by the test smtp server
+type receivedMail struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// startSMTPServer accepts a single message on a local port (no authentication and encryption)
+func startSMTPServer(t *testing.T) (Config, <-chan receivedMail) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	mails := make(chan receivedMail, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tp := textproto.NewConn(conn)
+		received := receivedMail{}
+		tp.PrintfLine("220 localhost ESMTP test")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch command {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 localhost")
+			case "MAIL":
+				received.From = line[len("MAIL FROM:"):]
+				tp.PrintfLine("250 ok")
+			case "RCPT":
+				received.To = append(received.To, line[len("RCPT TO:"):])
+				tp.PrintfLine("250 ok")
+			case "DATA":
+				tp.PrintfLine("354 send data")
+				if received.Data, err = tp.ReadDotBytes(); err != nil {
+					return
+				}
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				mails <- received
+				return
+			default:
+				tp.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	config := Config{
+		Host:     host,
+		Port:     port,
+		From:     "Strava Export <export@example.com>",
+		Security: SecurityNone,
+	}
+	return config, mails
+}
+
+// receive waits for the message sent to the test smtp server
+func receive(t *testing.T, mails <-chan receivedMail) (receivedMail, *mail.Message) {
+	select {
+	case received := <-mails:
+		message, err := mail.ReadMessage(bytes.NewReader(received.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return received, message
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	return receivedMail{}, nil
+}
+
+// getParts returns the decoded parts of a multipart message by content type
+func getParts(t *testing.T, message *mail.Message) map[string][]byte {
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("unexpected content type %s", message.Header.Get("Content-Type"))
+	}
+
+	parts := map[string][]byte{}
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Quoted-printable parts are decoded by the reader
+		if part.Header.Get("Content-Transfer-Encoding") == "base64" {
+			if data, err = base64.StdEncoding.DecodeString(strings.ReplaceAll(string(data), "\r\n", "")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if part.FileName() != "" {
+			parts[part.Header.Get("Content-Type")+";"+part.FileName()] = data
+		} else {
+			parts[part.Header.Get("Content-Type")] = data
+		}
+	}
+	return parts
+}
+
+// exportData returns the data of the export mail template
+func exportData(link string) interface{} {
+	return struct {
+		Name     string
+		From     time.Time
+		To       time.Time
+		FileName string
+		Link     string
+	}{
+		Name:     "Wöchentlicher Export",
+		From:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:       time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC),
+		FileName: "strava-export.xlsx",
+		Link:     link,
+	}
+}
+
+func TestSendAttachment(t *testing.T) {
+	TemplatesDir = "../../views/mail"
+	config, mails := startSMTPServer(t)
+
+	subject, body, err := Render("export", "de", exportData(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	xlsx := bytes.Repeat([]byte("PK\x03\x04 spreadsheet data "), 20)
+	err = Send(config, Message{
+		To:      "Athlete <athlete@example.com>",
+		Subject: subject,
+		Body:    body,
+		Attachments: []Attachment{{
+			Name:        "strava-export.xlsx",
+			ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			Data:        xlsx,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received, message := receive(t, mails)
+	if received.From != "<export@example.com>" {
+		t.Errorf("unexpected sender %s", received.From)
+	}
+	if len(received.To) != 1 || received.To[0] != "<athlete@example.com>" {
+		t.Errorf("unexpected recipients %v", received.To)
+	}
+
+	// Subject contains non-ascii characters, so it must be Q-encoded
+	rawSubject := message.Header.Get("Subject")
+	if !strings.HasPrefix(rawSubject, "=?utf-8?q?") {
+		t.Errorf("subject not Q-encoded: %s", rawSubject)
+	}
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(rawSubject); err != nil || decoded != "Strava-Export: Wöchentlicher Export (01.01.2024 bis 07.01.2024)" {
+		t.Errorf("unexpected subject %q (%v)", decoded, err)
+	}
+
+	parts := getParts(t, message)
+	if len(parts) != 2 {
+		t.Fatalf("expected body and attachment, got %d parts", len(parts))
+	}
+	if text := string(parts["text/plain; charset=utf-8"]); !strings.Contains(text, "im Anhang befindet sich der Export „Wöchentlicher Export“") {
+		t.Errorf("unexpected body %q", text)
+	}
+	attachment, exists := parts["application/vnd.openxmlformats-officedocument.spreadsheetml.sheet;strava-export.xlsx"]
+	if !exists {
+		t.Fatalf("xlsx attachment missing: %v", parts)
+	}
+	if !bytes.Equal(attachment, xlsx) {
+		t.Errorf("attachment changed")
+	}
+}
+
+func TestSendLink(t *testing.T) {
+	TemplatesDir = "../../views/mail"
+	config, mails := startSMTPServer(t)
+
+	link := "https://export.example.com/schedules/runs/abc"
+	subject, body, err := Render("export", "en", exportData(link))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Send(config, Message{To: "athlete@example.com", Subject: subject, Body: body}); err != nil {
+		t.Fatal(err)
+	}
+
+	received, message := receive(t, mails)
+	if len(received.To) != 1 || received.To[0] != "<athlete@example.com>" {
+		t.Errorf("unexpected recipients %v", received.To)
+	}
+
+	parts := getParts(t, message)
+	if len(parts) != 1 {
+		t.Fatalf("expected body only, got %d parts", len(parts))
+	}
+	text := string(parts["text/plain; charset=utf-8"])
+	if !strings.Contains(text, "can be downloaded here") || !strings.Contains(text, link) {
+		t.Errorf("link missing in body %q", text)
+	}
+}
@@ -0,0 +1,427 @@
+package mirror
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Version of the directory layout, mirrors with a different version are not changed
+	Version = 1
+	// Name of the file containing the state of the mirror
+	ManifestFile = "manifest.json"
+
+	BaseURL = "https://www.strava.com/api/v3"
+	// Maximum number of items per page allowed by Strava
+	PageSize = 200
+
+	// Stream types requested for every activity
+	StreamKeys = []string{
+		"time", "distance", "latlng", "altitude", "velocity_smooth", "heartrate",
+		"cadence", "watts", "temp", "moving", "grade_smooth",
+	}
+
+	// ErrRateLimited is returned if the rate limit is reached and the mirror doesn't wait
+	ErrRateLimited = errors.New("rate limit reached, run again later to resume")
+	// errNotAvailable is returned for resources that don't exist for an activity (e.g. streams of
+	// manual activities or zones without a subscription)
+	errNotAvailable = errors.New("not available")
+)
+
+// Manifest contains the state of a mirror, activities are only added after all of their resources
+// were written
+type Manifest struct {
+	Version    int                 `json:"version"`
+	AthleteId  int64               `json:"athlete_id"`
+	Started    time.Time           `json:"started"`
+	Finished   time.Time           `json:"finished"`
+	Activities map[int64]time.Time `json:"activities"`
+}
+
+// Mirror copies all data of an athlete into a directory:
+//
+//	manifest.json
+//	athlete.json
+//	athlete-zones.json
+//	gear/<id>.json
+//	routes/<id>.json, routes/<id>.gpx
+//	activities/<year>/<id>/activity.json, streams.json, laps.json, zones.json, comments.json, kudoers.json
+//
+// Files are replaced atomically, so an interrupted mirror can be resumed by running it again.
+type Mirror struct {
+	Dir string
+	// Client adding the token of the athlete to requests
+	Client *http.Client
+	// Wait until the rate limit is reset instead of returning ErrRateLimited
+	Wait bool
+	// Activities started within this duration are mirrored again (e.g. to get new comments)
+	Refresh time.Duration
+	// Log is called for every mirrored item
+	Log func(format string, args ...interface{})
+
+	manifest Manifest
+}
+
+// activitySummary contains the fields of listed activities used by the mirror
+type activitySummary struct {
+	Id             int64     `json:"id"`
+	Name           string    `json:"name"`
+	StartDateLocal time.Time `json:"start_date_local"`
+	GearId         string    `json:"gear_id"`
+}
+
+// activityResource is a file written for every activity
+type activityResource struct {
+	File  string
+	Path  string
+	Paged bool
+}
+
+// Run mirrors the athlete, activities that were mirrored completely are skipped
+func (m *Mirror) Run() error {
+	if err := m.loadManifest(); err != nil {
+		return err
+	}
+	m.manifest.Started = time.Now()
+	m.manifest.Finished = time.Time{}
+
+	// Athlete
+	var athlete struct {
+		Id    int64 `json:"id"`
+		Bikes []struct {
+			Id string `json:"id"`
+		} `json:"bikes"`
+		Shoes []struct {
+			Id string `json:"id"`
+		} `json:"shoes"`
+	}
+	data, err := m.get("/athlete")
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &athlete); err != nil {
+		return err
+	}
+	if m.manifest.AthleteId != 0 && m.manifest.AthleteId != athlete.Id {
+		return fmt.Errorf("directory contains the mirror of athlete %d", m.manifest.AthleteId)
+	}
+	m.manifest.AthleteId = athlete.Id
+	if err := m.writeJSON("athlete.json", data); err != nil {
+		return err
+	}
+	if err := m.mirrorResource("athlete-zones.json", "/athlete/zones", false); err != nil && err != errNotAvailable {
+		return err
+	}
+
+	// Activities (listed completely to find activities uploaded with an earlier date)
+	activities := []activitySummary{}
+	items, err := m.getPages("/athlete/activities")
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		activity := activitySummary{}
+		if err := json.Unmarshal(item, &activity); err != nil {
+			return err
+		}
+		activities = append(activities, activity)
+	}
+	sort.Slice(activities, func(i, j int) bool {
+		return activities[i].StartDateLocal.Before(activities[j].StartDateLocal)
+	})
+
+	gearIDs := map[string]bool{}
+	for _, bike := range athlete.Bikes {
+		gearIDs[bike.Id] = true
+	}
+	for _, shoe := range athlete.Shoes {
+		gearIDs[shoe.Id] = true
+	}
+
+	for i, activity := range activities {
+		if activity.GearId != "" {
+			gearIDs[activity.GearId] = true
+		}
+
+		_, exists := m.manifest.Activities[activity.Id]
+		if exists && time.Since(activity.StartDateLocal) > m.Refresh {
+			continue
+		}
+		if err := m.mirrorActivity(activity, exists); err != nil {
+			return err
+		}
+		m.log("activity %d/%d: %s (%d)", i+1, len(activities), activity.Name, activity.Id)
+	}
+
+	// Gear
+	for id := range gearIDs {
+		err := m.mirrorResource(filepath.Join("gear", cleanName(id)+".json"), "/gear/"+id, false)
+		if err == errNotAvailable {
+			m.log("gear %s: %s", id, err.Error())
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// Routes (the GPX file is only downloaded once)
+	routes, err := m.getPages(fmt.Sprintf("/athletes/%d/routes", athlete.Id))
+	if err != nil {
+		return err
+	}
+	for _, item := range routes {
+		var route struct {
+			Id   json.Number `json:"id"`
+			Name string      `json:"name"`
+		}
+		if err := json.Unmarshal(item, &route); err != nil {
+			return err
+		}
+		name := filepath.Join("routes", cleanName(route.Id.String()))
+		if err := m.writeJSON(name+".json", item); err != nil {
+			return err
+		}
+		if _, err := os.Stat(filepath.Join(m.Dir, name+".gpx")); err == nil {
+			continue
+		}
+		data, err := m.get("/routes/" + route.Id.String() + "/export_gpx")
+		if err == nil {
+			err = m.writeFile(name+".gpx", data)
+		}
+		if err != nil && err != errNotAvailable {
+			return err
+		}
+		m.log("route: %s (%s)", route.Name, route.Id)
+	}
+
+	m.manifest.Finished = time.Now()
+	return m.saveManifest()
+}
+
+// mirrorActivity writes all resources of an activity and adds it to the manifest, existing files of
+// an interrupted run are kept unless the activity is refreshed
+func (m *Mirror) mirrorActivity(activity activitySummary, refresh bool) error {
+	dir := filepath.Join("activities", fmt.Sprint(activity.StartDateLocal.Year()), fmt.Sprint(activity.Id))
+	path := fmt.Sprintf("/activities/%d", activity.Id)
+
+	resources := []activityResource{
+		{"activity.json", path + "?include_all_efforts=true", false},
+		{"streams.json", path + "/streams?key_by_type=true&keys=" + strings.Join(StreamKeys, ","), false},
+		{"laps.json", path + "/laps", false},
+		{"zones.json", path + "/zones", false},
+		{"comments.json", path + "/comments", true},
+		{"kudoers.json", path + "/kudos", true},
+	}
+	for _, resource := range resources {
+		name := filepath.Join(dir, resource.File)
+		if _, err := os.Stat(filepath.Join(m.Dir, name)); err == nil && !refresh {
+			continue
+		}
+		if err := m.mirrorResource(name, resource.Path, resource.Paged); err != nil && err != errNotAvailable {
+			return err
+		}
+	}
+
+	m.manifest.Activities[activity.Id] = time.Now()
+	return m.saveManifest()
+}
+
+// mirrorResource writes the response of a request (all pages if paged)
+func (m *Mirror) mirrorResource(name, path string, paged bool) error {
+	if !paged {
+		data, err := m.get(path)
+		if err != nil {
+			return err
+		}
+		return m.writeJSON(name, data)
+	}
+
+	items, err := m.getPages(path)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	return m.writeJSON(name, data)
+}
+
+// getPages requests all pages of a list
+func (m *Mirror) getPages(path string) ([]json.RawMessage, error) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	items := []json.RawMessage{}
+	for page := 1; ; page++ {
+		data, err := m.get(fmt.Sprintf("%s%sper_page=%d&page=%d", path, separator, PageSize, page))
+		if err != nil {
+			return nil, err
+		}
+		pageItems := []json.RawMessage{}
+		if err := json.Unmarshal(data, &pageItems); err != nil {
+			return nil, err
+		}
+		items = append(items, pageItems...)
+		if len(pageItems) < PageSize {
+			return items, nil
+		}
+	}
+}
+
+// get requests a resource of the Strava api, if the rate limit is reached it waits until the limit
+// is reset (or returns ErrRateLimited)
+func (m *Mirror) get(path string) ([]byte, error) {
+	for {
+		resp, err := m.Client.Get(BaseURL + path)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			// Wait before the next request if the limit is exhausted by this one
+			if reset, exhausted := getRateLimitReset(resp, time.Now()); exhausted {
+				if err := m.waitForReset(reset); err != nil {
+					return nil, err
+				}
+			}
+			return data, nil
+		case http.StatusTooManyRequests:
+			reset, _ := getRateLimitReset(resp, time.Now())
+			if err := m.waitForReset(reset); err != nil {
+				return nil, err
+			}
+		case http.StatusNotFound, http.StatusPaymentRequired, http.StatusForbidden:
+			return nil, errNotAvailable
+		default:
+			return nil, fmt.Errorf("request %s failed: %s", strings.SplitN(path, "?", 2)[0], resp.Status)
+		}
+	}
+}
+
+// waitForReset sleeps until the rate limit is reset
+func (m *Mirror) waitForReset(reset time.Time) error {
+	if !m.Wait {
+		return ErrRateLimited
+	}
+	m.log("rate limit reached, waiting until %s", reset.Local().Format("15:04"))
+	time.Sleep(time.Until(reset))
+	return nil
+}
+
+// getRateLimitReset checks the rate limit headers of a response (e.g. "X-RateLimit-Usage: 100,250"
+// and "X-RateLimit-Limit: 100,1000") and returns when the exhausted limit is reset, the short term
+// limit is reset every 15 minutes and the daily limit at midnight UTC
+func getRateLimitReset(resp *http.Response, now time.Time) (time.Time, bool) {
+	now = now.UTC()
+	nextWindow := now.Truncate(15 * time.Minute).Add(15*time.Minute + 5*time.Second)
+	nextDay := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 5, 0, time.UTC)
+
+	limits := strings.Split(resp.Header.Get("X-RateLimit-Limit"), ",")
+	usages := strings.Split(resp.Header.Get("X-RateLimit-Usage"), ",")
+	exhausted := []bool{false, false}
+	for i := 0; i < len(limits) && i < len(usages) && i < len(exhausted); i++ {
+		limit, err := strconv.Atoi(strings.TrimSpace(limits[i]))
+		if err != nil {
+			continue
+		}
+		usage, err := strconv.Atoi(strings.TrimSpace(usages[i]))
+		if err != nil {
+			continue
+		}
+		exhausted[i] = usage >= limit
+	}
+
+	if exhausted[1] {
+		return nextDay, true
+	}
+	return nextWindow, exhausted[0]
+}
+
+// loadManifest loads the manifest of the directory (a new mirror is created if it doesn't exist)
+func (m *Mirror) loadManifest() error {
+	m.manifest = Manifest{Version: Version, Activities: map[int64]time.Time{}}
+
+	data, err := ioutil.ReadFile(filepath.Join(m.Dir, ManifestFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &m.manifest); err != nil {
+		return err
+	}
+
+	if m.manifest.Version != Version {
+		return fmt.Errorf("directory contains a mirror of version %d (supported: %d)", m.manifest.Version, Version)
+	}
+	if m.manifest.Activities == nil {
+		m.manifest.Activities = map[int64]time.Time{}
+	}
+	return nil
+}
+
+// saveManifest writes the manifest of the directory
+func (m *Mirror) saveManifest() error {
+	data, err := json.MarshalIndent(m.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return m.writeFile(ManifestFile, data)
+}
+
+// writeJSON writes a response indented (easier to compare different versions)
+func (m *Mirror) writeJSON(name string, data []byte) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	return m.writeFile(name, buf.Bytes())
+}
+
+// writeFile writes a file of the mirror (replaced atomically)
+func (m *Mirror) writeFile(name string, data []byte) error {
+	filePath := filepath.Join(m.Dir, name)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filePath+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(filePath+".tmp", filePath)
+}
+
+// log prints a progress message
+func (m *Mirror) log(format string, args ...interface{}) {
+	if m.Log != nil {
+		m.Log(format, args...)
+	}
+}
+
+// cleanName removes characters of ids that aren't valid in file names
+func cleanName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == '.' {
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,374 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Type of a column
+type Type int
+
+// Supported column types (timestamps are stored as milliseconds in UTC)
+const (
+	Boolean Type = iota
+	Int32
+	Int64
+	Float
+	Double
+	Timestamp
+)
+
+// Physical types, encodings and other values of the parquet format
+const (
+	typeBoolean = 0
+	typeInt32   = 1
+	typeInt64   = 2
+	typeFloat   = 4
+	typeDouble  = 5
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	convertedTimestampMillis = 9
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecUncompressed = 0
+	pageTypeData      = 0
+)
+
+var (
+	// Number of rows buffered before a row group is written
+	RowGroupSize = 100000
+	// Written to the metadata of files
+	CreatedBy = "strava-export"
+
+	magic = []byte("PAR1")
+)
+
+// Column describes a column of a file, optional columns can contain null values
+type Column struct {
+	Name     string
+	Type     Type
+	Optional bool
+}
+
+// Writer writes a file with a flat schema (uncompressed and plain encoded), rows are buffered and
+// written as row groups
+type Writer struct {
+	w       io.Writer
+	offset  int64
+	columns []Column
+	chunks  []columnChunk
+
+	rows      int
+	totalRows int64
+	rowGroups [][]columnChunkMeta
+}
+
+// columnChunk contains the buffered values of a column
+type columnChunk struct {
+	defined []bool
+	values  bytes.Buffer
+	bools   []bool
+}
+
+// columnChunkMeta describes a written column chunk
+type columnChunkMeta struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+// NewWriter returns a writer for the given columns
+func NewWriter(w io.Writer, columns []Column) *Writer {
+	return &Writer{w: w, columns: columns, chunks: make([]columnChunk, len(columns))}
+}
+
+// Write adds a row, values must match the column types (bool, int32, int64, float32, float64 or
+// time.Time) and can be nil for optional columns
+func (pw *Writer) Write(row []interface{}) error {
+	if len(row) != len(pw.columns) {
+		return fmt.Errorf("row has %d values, expected %d", len(row), len(pw.columns))
+	}
+
+	for i, value := range row {
+		column := pw.columns[i]
+		chunk := &pw.chunks[i]
+
+		if value == nil {
+			if !column.Optional {
+				return fmt.Errorf("column %s is required", column.Name)
+			}
+			chunk.defined = append(chunk.defined, false)
+			continue
+		}
+		if column.Optional {
+			chunk.defined = append(chunk.defined, true)
+		}
+
+		var ok bool
+		switch column.Type {
+		case Boolean:
+			var v bool
+			if v, ok = value.(bool); ok {
+				chunk.bools = append(chunk.bools, v)
+			}
+		case Int32:
+			var v int32
+			if v, ok = value.(int32); ok {
+				binary.Write(&chunk.values, binary.LittleEndian, v)
+			}
+		case Int64:
+			var v int64
+			if v, ok = value.(int64); ok {
+				binary.Write(&chunk.values, binary.LittleEndian, v)
+			}
+		case Float:
+			var v float32
+			if v, ok = value.(float32); ok {
+				binary.Write(&chunk.values, binary.LittleEndian, math.Float32bits(v))
+			}
+		case Double:
+			var v float64
+			if v, ok = value.(float64); ok {
+				binary.Write(&chunk.values, binary.LittleEndian, math.Float64bits(v))
+			}
+		case Timestamp:
+			var v time.Time
+			if v, ok = value.(time.Time); ok {
+				binary.Write(&chunk.values, binary.LittleEndian, v.UnixNano()/int64(time.Millisecond))
+			}
+		}
+		if !ok {
+			return fmt.Errorf("invalid value %v for column %s", value, column.Name)
+		}
+	}
+
+	pw.rows++
+	if pw.rows >= RowGroupSize {
+		return pw.flush()
+	}
+	return nil
+}
+
+// Close writes the remaining rows and the metadata, the underlying writer isn't closed
+func (pw *Writer) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+	if err := pw.ensureMagic(); err != nil {
+		return err
+	}
+
+	metadata := pw.encodeMetadata()
+	if err := pw.write(metadata); err != nil {
+		return err
+	}
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(metadata)))
+	if err := pw.write(length[:]); err != nil {
+		return err
+	}
+	return pw.write(magic)
+}
+
+// flush writes the buffered rows as row group (one data page per column)
+func (pw *Writer) flush() error {
+	if pw.rows == 0 {
+		return nil
+	}
+	if err := pw.ensureMagic(); err != nil {
+		return err
+	}
+
+	metas := []columnChunkMeta{}
+	for i, column := range pw.columns {
+		chunk := &pw.chunks[i]
+
+		// Page: definition levels (optional columns only) and values
+		var page bytes.Buffer
+		if column.Optional {
+			levels := encodeLevels(chunk.defined)
+			binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+			page.Write(levels)
+		}
+		if column.Type == Boolean {
+			page.Write(packBools(chunk.bools))
+		} else {
+			page.Write(chunk.values.Bytes())
+		}
+
+		header := newThriftWriter()
+		header.fieldI32(1, pageTypeData)
+		header.fieldI32(2, int32(page.Len()))
+		header.fieldI32(3, int32(page.Len()))
+		header.fieldStruct(5, func() {
+			header.fieldI32(1, int32(pw.rows))
+			header.fieldI32(2, encodingPlain)
+			header.fieldI32(3, encodingRLE)
+			header.fieldI32(4, encodingRLE)
+		})
+		headerBytes := header.bytes()
+
+		meta := columnChunkMeta{offset: pw.offset, values: int64(pw.rows)}
+		if err := pw.write(headerBytes); err != nil {
+			return err
+		}
+		if err := pw.write(page.Bytes()); err != nil {
+			return err
+		}
+		meta.size = pw.offset - meta.offset
+		metas = append(metas, meta)
+
+		*chunk = columnChunk{}
+	}
+
+	pw.rowGroups = append(pw.rowGroups, metas)
+	pw.totalRows += int64(pw.rows)
+	pw.rows = 0
+	return nil
+}
+
+// encodeMetadata returns the file metadata (schema and row groups)
+func (pw *Writer) encodeMetadata() []byte {
+	t := newThriftWriter()
+	t.fieldI32(1, 1)
+
+	// Schema (root element followed by the columns)
+	t.fieldList(2, thriftStruct, len(pw.columns)+1)
+	t.structValue(func() {
+		t.fieldString(4, "schema")
+		t.fieldI32(5, int32(len(pw.columns)))
+	})
+	for _, column := range pw.columns {
+		column := column
+		t.structValue(func() {
+			t.fieldI32(1, column.physicalType())
+			if column.Optional {
+				t.fieldI32(3, repetitionOptional)
+			} else {
+				t.fieldI32(3, repetitionRequired)
+			}
+			t.fieldString(4, column.Name)
+			if column.Type == Timestamp {
+				t.fieldI32(6, convertedTimestampMillis)
+				// Logical type: timestamp (adjusted to UTC) in milliseconds
+				t.fieldStruct(10, func() {
+					t.fieldStruct(8, func() {
+						t.fieldBool(1, true)
+						t.fieldStruct(2, func() {
+							t.fieldStruct(1, func() {})
+						})
+					})
+				})
+			}
+		})
+	}
+
+	t.fieldI64(3, pw.totalRows)
+
+	// Row groups
+	t.fieldList(4, thriftStruct, len(pw.rowGroups))
+	for _, metas := range pw.rowGroups {
+		metas := metas
+		t.structValue(func() {
+			var size int64
+			t.fieldList(1, thriftStruct, len(metas))
+			for i, meta := range metas {
+				column, meta := pw.columns[i], meta
+				size += meta.size
+				t.structValue(func() {
+					t.fieldI64(2, meta.offset)
+					t.fieldStruct(3, func() {
+						t.fieldI32(1, column.physicalType())
+						t.fieldList(2, thriftI32, 2)
+						t.i32Value(encodingPlain)
+						t.i32Value(encodingRLE)
+						t.fieldList(3, thriftBinary, 1)
+						t.stringValue(column.Name)
+						t.fieldI32(4, codecUncompressed)
+						t.fieldI64(5, meta.values)
+						t.fieldI64(6, meta.size)
+						t.fieldI64(7, meta.size)
+						t.fieldI64(9, meta.offset)
+					})
+				})
+			}
+			t.fieldI64(2, size)
+			t.fieldI64(3, metas[0].values)
+		})
+	}
+
+	t.fieldString(6, CreatedBy)
+	return t.bytes()
+}
+
+// ensureMagic writes the magic bytes at the beginning of the file
+func (pw *Writer) ensureMagic() error {
+	if pw.offset > 0 {
+		return nil
+	}
+	return pw.write(magic)
+}
+
+// write writes to the underlying writer and keeps track of the offset
+func (pw *Writer) write(data []byte) error {
+	n, err := pw.w.Write(data)
+	pw.offset += int64(n)
+	return err
+}
+
+// physicalType returns the type used to store the values of a column
+func (c *Column) physicalType() int32 {
+	switch c.Type {
+	case Boolean:
+		return typeBoolean
+	case Int32:
+		return typeInt32
+	case Float:
+		return typeFloat
+	case Double:
+		return typeDouble
+	default:
+		return typeInt64
+	}
+}
+
+// encodeLevels encodes definition levels (bit width 1) using runs of the RLE/bit-packing hybrid
+// encoding
+func encodeLevels(defined []bool) []byte {
+	var buf bytes.Buffer
+	var header [binary.MaxVarintLen64]byte
+	for start := 0; start < len(defined); {
+		end := start
+		for end < len(defined) && defined[end] == defined[start] {
+			end++
+		}
+
+		buf.Write(header[:binary.PutUvarint(header[:], uint64(end-start)<<1)])
+		if defined[start] {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		start = end
+	}
+	return buf.Bytes()
+}
+
+// packBools encodes boolean values as bits (least significant bit first)
+func packBools(values []bool) []byte {
+	packed := make([]byte, (len(values)+7)/8)
+	for i, value := range values {
+		if value {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return packed
+}
@@ -0,0 +1,300 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// thriftReader decodes structs of the thrift compact protocol, fields are returned by id (integers as
+// int64, binary as string, lists as []interface{} and structs as map[int16]interface{})
+type thriftReader struct {
+	t    *testing.T
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.data) {
+		r.t.Fatal("unexpected end of thrift data")
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.t.Fatal("invalid varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) varint() int64 {
+	v, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 {
+		r.t.Fatal("invalid varint")
+	}
+	r.pos += n
+	return v
+}
+
+// value decodes a value of a type
+func (r *thriftReader) value(valueType byte) interface{} {
+	switch valueType {
+	case thriftBooleanTrue:
+		return true
+	case thriftBooleanFalse:
+		return false
+	case thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		length := int(r.uvarint())
+		value := string(r.data[r.pos : r.pos+length])
+		r.pos += length
+		return value
+	case thriftList:
+		header := r.byte()
+		size, elementType := int(header>>4), header&0x0f
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = r.value(elementType)
+		}
+		return list
+	case thriftStruct:
+		return r.structValue()
+	}
+	r.t.Fatalf("unsupported thrift type %d", valueType)
+	return nil
+}
+
+// structValue decodes the fields of a struct until the stop field
+func (r *thriftReader) structValue() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	var last int16
+	for {
+		header := r.byte()
+		if header == 0 {
+			return fields
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.varint())
+		}
+		fields[id] = r.value(header & 0x0f)
+		last = id
+	}
+}
+
+// getStruct returns a struct field
+func getStruct(t *testing.T, fields map[int16]interface{}, id int16) map[int16]interface{} {
+	t.Helper()
+	value, ok := fields[id].(map[int16]interface{})
+	if !ok {
+		t.Fatalf("field %d is not a struct: %v", id, fields[id])
+	}
+	return value
+}
+
+// getList returns a list field
+func getList(t *testing.T, fields map[int16]interface{}, id int16) []interface{} {
+	t.Helper()
+	value, ok := fields[id].([]interface{})
+	if !ok {
+		t.Fatalf("field %d is not a list: %v", id, fields[id])
+	}
+	return value
+}
+
+// decodeLevels decodes definition levels written as RLE runs
+func decodeLevels(t *testing.T, data []byte, count int) []bool {
+	levels := []bool{}
+	for pos := 0; pos < len(data); {
+		header, n := binary.Uvarint(data[pos:])
+		if header&1 != 0 {
+			t.Fatal("bit-packed runs are not expected")
+		}
+		pos += n
+		for i := 0; i < int(header>>1); i++ {
+			levels = append(levels, data[pos] == 1)
+		}
+		pos++
+	}
+	if len(levels) != count {
+		t.Fatalf("expected %d levels, got %d", count, len(levels))
+	}
+	return levels
+}
+
+// decodeValues decodes the plain encoded values of a page, null values are nil
+func decodeValues(t *testing.T, column Column, page []byte, rows int) []interface{} {
+	defined := make([]bool, rows)
+	for i := range defined {
+		defined[i] = true
+	}
+	if column.Optional {
+		length := int(binary.LittleEndian.Uint32(page))
+		defined = decodeLevels(t, page[4:4+length], rows)
+		page = page[4+length:]
+	}
+
+	values := []interface{}{}
+	index := 0
+	for _, isDefined := range defined {
+		if !isDefined {
+			values = append(values, nil)
+			continue
+		}
+		switch column.Type {
+		case Boolean:
+			values = append(values, page[index/8]&(1<<(index%8)) != 0)
+			index++
+		case Int32:
+			values = append(values, int32(binary.LittleEndian.Uint32(page[index:])))
+			index += 4
+		case Int64:
+			values = append(values, int64(binary.LittleEndian.Uint64(page[index:])))
+			index += 8
+		case Float:
+			values = append(values, math.Float32frombits(binary.LittleEndian.Uint32(page[index:])))
+			index += 4
+		case Double:
+			values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(page[index:])))
+			index += 8
+		case Timestamp:
+			millis := int64(binary.LittleEndian.Uint64(page[index:]))
+			values = append(values, time.Unix(0, millis*int64(time.Millisecond)).UTC())
+			index += 8
+		}
+	}
+	return values
+}
+
+func TestWriter(t *testing.T) {
+	columns := []Column{
+		{Name: "activity_id", Type: Int64},
+		{Name: "t", Type: Int32},
+		{Name: "timestamp", Type: Timestamp},
+		{Name: "distance", Type: Float, Optional: true},
+		{Name: "lat", Type: Double, Optional: true},
+		{Name: "moving", Type: Boolean, Optional: true},
+	}
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	rows := [][]interface{}{
+		{int64(12345678901), int32(0), start, float32(0), 47.5, true},
+		{int64(12345678901), int32(5), start.Add(5 * time.Second), nil, nil, nil},
+		{int64(12345678902), int32(0), start.Add(time.Hour), float32(1000.25), 47.25, false},
+	}
+
+	// Two row groups
+	rowGroupSize := RowGroupSize
+	RowGroupSize = 2
+	defer func() { RowGroupSize = rowGroupSize }()
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, columns)
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// Footer: metadata, its length and magic bytes
+	if !bytes.HasPrefix(data, magic) || !bytes.HasSuffix(data, magic) {
+		t.Fatal("magic bytes missing")
+	}
+	length := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	reader := &thriftReader{t: t, data: data[len(data)-8-length : len(data)-8]}
+	metadata := reader.structValue()
+	if reader.pos != length {
+		t.Fatalf("metadata has %d bytes, decoded %d", length, reader.pos)
+	}
+	if metadata[1] != int64(1) || metadata[3] != int64(len(rows)) || metadata[6] != CreatedBy {
+		t.Errorf("unexpected metadata %v", metadata)
+	}
+
+	// Schema
+	schema := getList(t, metadata, 2)
+	if len(schema) != len(columns)+1 {
+		t.Fatalf("expected %d schema elements, got %d", len(columns)+1, len(schema))
+	}
+	if root := schema[0].(map[int16]interface{}); root[4] != "schema" || root[5] != int64(len(columns)) {
+		t.Errorf("unexpected root element %v", root)
+	}
+	for i, column := range columns {
+		element := schema[i+1].(map[int16]interface{})
+		repetition := int64(repetitionRequired)
+		if column.Optional {
+			repetition = repetitionOptional
+		}
+		if element[4] != column.Name || element[1] != int64(column.physicalType()) || element[3] != repetition {
+			t.Errorf("unexpected schema element of %s: %v", column.Name, element)
+		}
+		if column.Type == Timestamp {
+			logicalType := getStruct(t, getStruct(t, element, 10), 8)
+			if element[6] != int64(convertedTimestampMillis) || logicalType[1] != true {
+				t.Errorf("unexpected timestamp type %v", element)
+			}
+		}
+	}
+
+	// Row groups: column chunks point to a page header followed by the values
+	decoded := make([][]interface{}, len(rows))
+	rowGroups := getList(t, metadata, 4)
+	if len(rowGroups) != 2 {
+		t.Fatalf("expected 2 row groups, got %d", len(rowGroups))
+	}
+	first := 0
+	for _, rowGroup := range rowGroups {
+		rowGroup := rowGroup.(map[int16]interface{})
+		count := int(rowGroup[3].(int64))
+		chunks := getList(t, rowGroup, 1)
+		var size int64
+		for i, chunk := range chunks {
+			meta := getStruct(t, chunk.(map[int16]interface{}), 3)
+			if path := getList(t, meta, 3); len(path) != 1 || path[0] != columns[i].Name {
+				t.Errorf("unexpected path %v", path)
+			}
+			if meta[5] != int64(count) || meta[4] != int64(codecUncompressed) {
+				t.Errorf("unexpected column chunk %v", meta)
+			}
+			offset := int(meta[9].(int64))
+			size += meta[6].(int64)
+
+			header := &thriftReader{t: t, data: data[offset:]}
+			pageHeader := header.structValue()
+			pageSize := int(pageHeader[2].(int64))
+			if pageHeader[1] != int64(pageTypeData) || getStruct(t, pageHeader, 5)[1] != int64(count) {
+				t.Errorf("unexpected page header %v", pageHeader)
+			}
+			if int64(header.pos+pageSize) != meta[6].(int64) {
+				t.Errorf("column chunk size %d doesn't match page size %d", meta[6], header.pos+pageSize)
+			}
+
+			page := data[offset+header.pos : offset+header.pos+pageSize]
+			for j, value := range decodeValues(t, columns[i], page, count) {
+				decoded[first+j] = append(decoded[first+j], value)
+			}
+		}
+		if rowGroup[2] != size {
+			t.Errorf("row group size %v doesn't match the column chunks (%d)", rowGroup[2], size)
+		}
+		first += count
+	}
+
+	if !reflect.DeepEqual(decoded, rows) {
+		t.Errorf("decoded rows don't match\n%v\nexpected\n%v", decoded, rows)
+	}
+}
@@ -0,0 +1,122 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Types of the thrift compact protocol
+const (
+	thriftBooleanTrue  = 1
+	thriftBooleanFalse = 2
+	thriftI32          = 5
+	thriftI64          = 6
+	thriftBinary       = 8
+	thriftList         = 9
+	thriftStruct       = 12
+)
+
+// thriftWriter encodes the metadata of a file using the thrift compact protocol
+type thriftWriter struct {
+	buf bytes.Buffer
+	// Last field id of the current struct and of all parent structs
+	lastFields []int16
+}
+
+// newThriftWriter returns a writer for a top-level struct
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{lastFields: []int16{0}}
+}
+
+// fieldHeader writes the id (as delta to the last field if possible) and type of a field
+func (t *thriftWriter) fieldHeader(id int16, fieldType byte) {
+	last := &t.lastFields[len(t.lastFields)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		t.buf.WriteByte(fieldType)
+		t.varint(int64(id))
+	}
+	*last = id
+}
+
+// varint writes a zigzag encoded integer
+func (t *thriftWriter) varint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	t.buf.Write(buf[:binary.PutVarint(buf[:], v)])
+}
+
+// uvarint writes an unsigned integer (e.g. sizes)
+func (t *thriftWriter) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	t.buf.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (t *thriftWriter) fieldBool(id int16, v bool) {
+	if v {
+		t.fieldHeader(id, thriftBooleanTrue)
+	} else {
+		t.fieldHeader(id, thriftBooleanFalse)
+	}
+}
+
+func (t *thriftWriter) fieldI32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) fieldI64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) fieldString(id int16, v string) {
+	t.fieldHeader(id, thriftBinary)
+	t.uvarint(uint64(len(v)))
+	t.buf.WriteString(v)
+}
+
+// fieldStruct writes a struct, its fields are written by the function
+func (t *thriftWriter) fieldStruct(id int16, fields func()) {
+	t.fieldHeader(id, thriftStruct)
+	t.structValue(fields)
+}
+
+// fieldList writes the header of a list, the elements have to be written afterwards
+func (t *thriftWriter) fieldList(id int16, elemType byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		t.buf.WriteByte(0xf0 | elemType)
+		t.uvarint(uint64(size))
+	}
+}
+
+// structValue writes a struct without field header (e.g. list elements)
+func (t *thriftWriter) structValue(fields func()) {
+	t.lastFields = append(t.lastFields, 0)
+	fields()
+	t.stop()
+	t.lastFields = t.lastFields[:len(t.lastFields)-1]
+}
+
+func (t *thriftWriter) i32Value(v int32) {
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) stringValue(v string) {
+	t.uvarint(uint64(len(v)))
+	t.buf.WriteString(v)
+}
+
+// stop ends the current struct
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
+
+// bytes returns the encoded top-level struct
+func (t *thriftWriter) bytes() []byte {
+	t.stop()
+	return t.buf.Bytes()
+}
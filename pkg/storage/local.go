@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Local stores files in a directory of the server
+type Local struct {
+	Dir string
+}
+
+// NewLocal returns a storage writing to a directory (created if it doesn't exist)
+func NewLocal(dir string) *Local {
+	return &Local{Dir: dir}
+}
+
+// Put writes a file to the directory (replaced atomically)
+func (l *Local) Put(name string, data []byte) error {
+	filePath := filepath.Join(l.Dir, filepath.FromSlash(cleanName(name)))
+	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filePath+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(filePath+".tmp", filePath)
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 stores files in a bucket of an S3-compatible object storage (e.g. AWS S3 or MinIO)
+type S3 struct {
+	// Url of the service (e.g. https://s3.eu-central-1.amazonaws.com or http://localhost:9000)
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Bucket is part of the path instead of the host (required by MinIO by default)
+	PathStyle bool
+}
+
+// NewS3 returns a storage writing to a bucket (region defaults to us-east-1)
+func NewS3(endpoint, region, bucket, accessKey, secretKey string, pathStyle bool) *S3 {
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		PathStyle: pathStyle,
+	}
+}
+
+// Put uploads an object, the request is signed using signature version 4
+func (s *S3) Put(name string, data []byte) error {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	// Object url (the escaped path is signed, so it must be sent unchanged)
+	objectPath := "/" + cleanName(name)
+	if s.PathStyle {
+		objectPath = "/" + s.Bucket + objectPath
+	} else {
+		endpoint.Host = s.Bucket + "." + endpoint.Host
+	}
+	endpoint.Path = objectPath
+	endpoint.RawPath = escapeS3Path(objectPath)
+
+	req, err := http.NewRequest(http.MethodPut, endpoint.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	payloadHash := sha256.Sum256(data)
+	req.Header.Set("x-amz-content-sha256", hex.EncodeToString(payloadHash[:]))
+	signV4(req, s.AccessKey, s.SecretKey, s.Region, "s3", time.Now().UTC())
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to upload %s to bucket %s: %w", name, s.Bucket, StatusError(resp.StatusCode))
+	}
+	return nil
+}
+
+// signV4 adds the authorization header (AWS signature version 4) to a request without query, the
+// host and all headers set on the request are signed
+func signV4(req *http.Request, accessKey, secretKey, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+
+	// Payload hash is sent by S3 clients, other services sign the hash of an empty payload
+	payloadHash := req.Header.Get("x-amz-content-sha256")
+	if payloadHash == "" {
+		emptyHash := sha256.Sum256(nil)
+		payloadHash = hex.EncodeToString(emptyHash[:])
+	}
+
+	// Canonical headers (lowercase names sorted alphabetically)
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// Canonical request
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapeS3Path(req.URL.Path),
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+
+	// String to sign
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	key := deriveSigningKey(secretKey, date, region, service)
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+// deriveSigningKey returns the signing key derived from the secret key, date, region and service
+func deriveSigningKey(secretKey, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+// hmacSHA256 returns the hmac of data
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapeS3Path encodes all characters except unreserved ones and slashes (as expected by S3)
+func escapeS3Path(path string) string {
+	var builder strings.Builder
+	for _, b := range []byte(path) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || strings.IndexByte("-_.~/", b) >= 0 {
+			builder.WriteByte(b)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", b)
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// Client used for remote storages, connections to internal addresses are rejected
+	httpClient = &http.Client{
+		Timeout: 5 * time.Minute,
+		Transport: &http.Transport{
+			DialContext:         dialPublic,
+			TLSHandshakeTimeout: 30 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+
+	// ErrHostNotAllowed is returned for hosts resolving to internal addresses (e.g. loopback)
+	ErrHostNotAllowed = errors.New("host not allowed")
+
+	// Address ranges not covered by the net package (carrier-grade NAT)
+	internalNetworks = []*net.IPNet{
+		{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+	}
+)
+
+// Storage stores finished files (e.g. exports) outside of the application
+type Storage interface {
+	// Put stores a file, the name may contain directories separated by slashes
+	Put(name string, data []byte) error
+}
+
+// StatusError is returned if a storage responds with an unexpected status code (the response body
+// isn't included, because errors are shown to the athlete)
+type StatusError int
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", int(e))
+}
+
+// CheckURL checks if requests may be sent to the host of a url, hosts resolving to internal
+// addresses are only allowed if listed in STORAGE_ALLOWED_HOSTS
+func CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if isAllowedHost(u.Hostname()) {
+		return nil
+	}
+
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if !isPublicIP(ip) {
+			return fmt.Errorf("%w: %s", ErrHostNotAllowed, u.Hostname())
+		}
+	}
+	return nil
+}
+
+// dialPublic connects to an address, the resolved ip is checked right before connecting (so the
+// check can't be bypassed by redirects or changed dns records)
+func dialPublic(ctx context.Context, network, address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if !isAllowedHost(host) {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			ipAddress, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(ipAddress); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+			}
+			return nil
+		}
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// isAllowedHost checks if a host is listed in STORAGE_ALLOWED_HOSTS (comma separated, e.g. a local
+// MinIO server)
+func isAllowedHost(host string) bool {
+	for _, allowed := range strings.Split(os.Getenv("STORAGE_ALLOWED_HOSTS"), ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublicIP checks if an ip isn't a loopback, private, link-local or otherwise internal address
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range internalNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanName returns a relative slash separated path without parent directory references
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestInternalHosts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	for _, rawURL := range []string{server.URL, "http://localhost:9000", "http://10.0.0.1", "http://169.254.169.254/latest", "http://[::1]:8080"} {
+		if err := CheckURL(rawURL); !errors.Is(err, ErrHostNotAllowed) {
+			t.Errorf("%s: expected host to be rejected, got %v", rawURL, err)
+		}
+	}
+	if err := NewWebDAV(server.URL, "", "").Put("export.xlsx", []byte("data")); !errors.Is(err, ErrHostNotAllowed) {
+		t.Errorf("upload to internal host not rejected: %v", err)
+	}
+
+	// Hosts allowed by the operator
+	t.Setenv("STORAGE_ALLOWED_HOSTS", "example.com, "+serverURL.Hostname())
+	if err := CheckURL(server.URL); err != nil {
+		t.Errorf("allowed host rejected: %v", err)
+	}
+	if err := NewWebDAV(server.URL, "", "").Put("export.xlsx", []byte("data")); err != nil {
+		t.Errorf("upload to allowed host failed: %v", err)
+	}
+}
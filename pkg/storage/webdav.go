@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// WebDAV stores files on a WebDAV server (e.g. Nextcloud)
+type WebDAV struct {
+	// Url of the base directory (e.g. https://cloud.example.com/remote.php/dav/files/<user>)
+	URL      string
+	Username string
+	Password string
+}
+
+// NewWebDAV returns a storage writing to a directory of a WebDAV server
+func NewWebDAV(baseURL, username, password string) *WebDAV {
+	return &WebDAV{URL: strings.TrimSuffix(baseURL, "/"), Username: username, Password: password}
+}
+
+// Put uploads a file, missing directories are created
+func (w *WebDAV) Put(name string, data []byte) error {
+	parts := strings.Split(cleanName(name), "/")
+
+	// Create parent directories one after another (405 is returned if a directory exists)
+	dir := ""
+	for _, part := range parts[:len(parts)-1] {
+		dir += "/" + url.PathEscape(part)
+		resp, err := w.request("MKCOL", dir, nil)
+		if err != nil {
+			return err
+		}
+		if resp != http.StatusCreated && resp != http.StatusMethodNotAllowed {
+			return fmt.Errorf("failed to create WebDAV directory %s: %w", dir, StatusError(resp))
+		}
+	}
+
+	status, err := w.request(http.MethodPut, dir+"/"+url.PathEscape(parts[len(parts)-1]), data)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusCreated && status != http.StatusNoContent {
+		return fmt.Errorf("failed to upload %s to WebDAV server: %w", name, StatusError(status))
+	}
+	return nil
+}
+
+// request sends a request to a path relative to the base directory and returns the status code
+func (w *WebDAV) request(method, path string, data []byte) (int, error) {
+	req, err := http.NewRequest(method, w.URL+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	if w.Username != "" {
+		req.SetBasicAuth(w.Username, w.Password)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -11,9 +11,9 @@ package swagger
 
 type UpdatableActivity struct {
 	// Whether this activity is a commute
-	Commute bool `json:"commute,omitempty"`
+	Commute bool `json:"commute"`
 	// Whether this activity was recorded on a training machine
-	Trainer bool `json:"trainer,omitempty"`
+	Trainer bool `json:"trainer"`
 	// Whether this activity is muted
 	HideFromHome bool `json:"hide_from_home,omitempty"`
 	// The description of the activity
@@ -0,0 +1,112 @@
+package userdata
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// Prefix of encrypted values (values without prefix were stored before encryption was added)
+	encryptedPrefix = "enc:"
+	// File in the data directory containing the generated key (if SECRET_KEY isn't set)
+	secretKeyFile = "secret.key"
+)
+
+// Encrypt encrypts a secret (e.g. a password) with the key of the server, so it can be stored in the
+// data directory of an athlete
+func Encrypt(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	gcm, err := getCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(value), nil)), nil
+}
+
+// Decrypt decrypts a secret returned by Encrypt, values stored without encryption are returned
+// unchanged
+func Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := getCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("encrypted value too short")
+	}
+
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// getCipher returns the cipher used for secrets, the key is taken from SECRET_KEY or generated once
+// and stored in the data directory
+func getCipher() (cipher.AEAD, error) {
+	secret := os.Getenv("SECRET_KEY")
+	if secret == "" {
+		var err error
+		if secret, err = loadSecretKeyFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadSecretKeyFile returns the key stored in the data directory (created if it doesn't exist)
+func loadSecretKeyFile() (string, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	path := filepath.Join(GetDataDir(), secretKeyFile)
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(GetDataDir(), 0700); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(key)
+	if err := ioutil.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
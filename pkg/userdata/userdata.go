@@ -0,0 +1,135 @@
+package userdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/aschbacd/strava-export/pkg/utils"
+)
+
+var (
+	mutex sync.Mutex
+)
+
+// GetDataDir returns the directory used to store application data
+func GetDataDir() string {
+	return utils.GetEnv("DATA_DIR", "data")
+}
+
+// GetUserDir returns the data directory of an athlete (created if it doesn't exist)
+func GetUserDir(athleteID int64) (string, error) {
+	dir := getUserPath(athleteID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// GetFilePath returns the path of a file in the data directory of an athlete (the directory isn't
+// created, so reading files of unknown athletes doesn't add them to the data directory)
+func GetFilePath(athleteID int64, name string) string {
+	return filepath.Join(getUserPath(athleteID), name)
+}
+
+// Exists checks if a file exists in the data directory of an athlete
+func Exists(athleteID int64, name string) bool {
+	_, err := os.Stat(GetFilePath(athleteID, name))
+	return err == nil
+}
+
+// Load decodes a json file of an athlete, v is not changed if the file doesn't exist
+func Load(athleteID int64, name string, v interface{}) error {
+	path := GetFilePath(athleteID, name)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Save encodes v as json and stores it in the data directory of an athlete
+func Save(athleteID int64, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFile(athleteID, name, data)
+}
+
+// WriteFile stores a file in the data directory of an athlete (replaced atomically)
+func WriteFile(athleteID int64, name string, data []byte) error {
+	dir, err := GetUserDir(athleteID)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// Write to temporary file first to not corrupt existing data
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Delete removes a file from the data directory of an athlete
+func Delete(athleteID int64, name string) error {
+	path := GetFilePath(athleteID, name)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// DeleteAll removes the data directory of an athlete including all files
+func DeleteAll(athleteID int64) error {
+	dir := getUserPath(athleteID)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return os.RemoveAll(dir)
+}
+
+// GetAthleteIDs returns the ids of all athletes with a data directory
+func GetAthleteIDs() ([]int64, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(GetDataDir(), "users"))
+	if os.IsNotExist(err) {
+		return []int64{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	ids := []int64{}
+	for _, entry := range entries {
+		if id, err := strconv.ParseInt(entry.Name(), 10, 64); err == nil && entry.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// getUserPath returns the path of the data directory of an athlete
+func getUserPath(athleteID int64) string {
+	return filepath.Join(GetDataDir(), "users", fmt.Sprint(athleteID))
+}
@@ -1,7 +1,8 @@
 package utils
 
 import (
-	"math/rand"
+	"crypto/rand"
+	"math/big"
 	"net/http"
 	"os"
 
@@ -22,13 +23,19 @@ func GetEnv(key, fallback string) string {
 	return fallback
 }
 
-// GetRandomString returns a random string with a given length
+// GetRandomString returns a cryptographically secure random string with a given length (used for
+// secrets like session keys and api tokens)
 func GetRandomString(n int) string {
 	var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 
 	s := make([]rune, n)
 	for i := range s {
-		s[i] = letters[rand.Intn(len(letters))]
+		index, err := rand.Int(rand.Reader, big.NewInt(int64(len(letters))))
+		if err != nil {
+			// The random number generator of the operating system is not available
+			panic(err)
+		}
+		s[i] = letters[index.Int64()]
 	}
 
 	return string(s)